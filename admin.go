@@ -0,0 +1,96 @@
+package watchman
+
+import (
+	"context"
+
+	"github.com/cdmistman/watchman/protocol"
+)
+
+// A LogLevel selects messages from the log of the Watchman server; see
+// protocol.LogLevel.
+type LogLevel = protocol.LogLevel
+
+// A LogEntry is a message from the log of the Watchman server. After
+// SetLogLevel, log entries are emitted by Notifications.
+type LogEntry struct {
+	// Level is empty if the Watchman server does not report it.
+	Level   LogLevel
+	Message string
+}
+
+// VersionInfo describes the Watchman server.
+type VersionInfo struct {
+	Version string
+	// Capabilities reports, for each capability passed to
+	// ServerVersion, whether the Watchman server supports it.
+	Capabilities map[string]bool
+}
+
+// ShutdownServer asks the Watchman server to exit. The connection is
+// then lost: unless the Client was configured with Options.Reconnect,
+// in which case it starts a new server, subscriptions end and further
+// requests fail.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/shutdown-server.html
+func (c *Client) ShutdownServer(ctx context.Context) error {
+	_, err := c.send(ctx, &protocol.ShutdownServerRequest{})
+	return err
+}
+
+// ServerVersion returns the version of the Watchman server, and which
+// of the required and optional capabilities it supports. It fails if
+// the server does not support every required capability.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/version.html
+func (c *Client) ServerVersion(ctx context.Context, required, optional []string) (*VersionInfo, error) {
+	req := &protocol.VersionRequest{Required: required, Optional: optional}
+	pdu, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := protocol.NewVersionResponse(pdu)
+	return &VersionInfo{
+		Version:      res.Version(),
+		Capabilities: res.Capabilities(),
+	}, nil
+}
+
+// ServerPID returns the process ID of the Watchman server.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/get-pid.html
+func (c *Client) ServerPID(ctx context.Context) (int, error) {
+	pdu, err := c.send(ctx, &protocol.GetPidRequest{})
+	if err != nil {
+		return 0, err
+	}
+	return protocol.NewGetPidResponse(pdu).Pid(), nil
+}
+
+// Log writes a message to the log of the Watchman server. level must
+// be protocol.LogError or protocol.LogDebug.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/log.html
+func (c *Client) Log(ctx context.Context, level LogLevel, message string) error {
+	req := &protocol.LogRequest{Level: level, Message: message}
+	_, err := c.send(ctx, req)
+	return err
+}
+
+// SetLogLevel selects the messages from the log of the Watchman server
+// that are emitted by Notifications, as *LogEntry values. Use
+// protocol.LogOff to stop receiving them. The level is restored after
+// reconnecting.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/log-level.html
+func (c *Client) SetLogLevel(ctx context.Context, level LogLevel) error {
+	req := &protocol.LogLevelRequest{Level: level}
+	if _, err := c.send(ctx, req); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.logLevel = level
+	c.mu.Unlock()
+	return nil
+}
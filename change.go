@@ -2,14 +2,36 @@ package watchman
 
 import (
 	"github.com/cdmistman/watchman/protocol"
+	"github.com/cdmistman/watchman/protocol/query"
 )
 
+// A File describes a filesystem entry returned by Watchman. Only the
+// fields requested by the query are populated.
+type File = protocol.File
+
 // A ChangeNotification represents changes two one or more filesystem entries.
+//
+// If State is set, the notification reports a state entered or left on
+// the watched root instead, and Files is empty.
 type ChangeNotification struct {
 	IsFreshInstance bool
 	Clock           string
 	Subscription    string
-	Files           []interface{}
+	Files           []File
+	State           *StateChange
+	root            string
+	canceled        bool
+}
+
+// A StateChange describes a state entered or left on a watched root.
+type StateChange struct {
+	Name string
+	// Entered is true for state-enter, and false for state-leave.
+	Entered  bool
+	Metadata interface{}
+	// Abandoned indicates that the state was left because the client
+	// that entered it disconnected.
+	Abandoned bool
 }
 
 func newChangeNotification(sub *protocol.Subscription) *ChangeNotification {
@@ -20,6 +42,28 @@ func newChangeNotification(sub *protocol.Subscription) *ChangeNotification {
 		Clock:           clock,
 		Subscription:    sub.Subscription(),
 		Files:           files,
+		root:            sub.Root(),
+		canceled:        sub.Canceled(),
+	}
+	if name := sub.StateEnter(); name != "" {
+		cn.State = &StateChange{
+			Name:     name,
+			Entered:  true,
+			Metadata: sub.Metadata(),
+		}
+	} else if name := sub.StateLeave(); name != "" {
+		cn.State = &StateChange{
+			Name:      name,
+			Metadata:  sub.Metadata(),
+			Abandoned: sub.Abandoned(),
+		}
 	}
 	return cn
 }
+
+// decodeFiles decodes the files list of a PDU using the fields of the
+// query that produced it, which is needed to interpret bare values.
+func decodeFiles(pdu protocol.ResponsePDU, fields query.Fields) []File {
+	files, _ := pdu["files"].([]interface{})
+	return protocol.NewFiles(files, fields)
+}
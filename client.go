@@ -1,53 +1,298 @@
 package watchman
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"time"
 
 	"github.com/cdmistman/watchman/protocol"
 )
 
+// ErrClosed is returned when the connection to the Watchman server is
+// closed, or was lost, before a response was received.
+var ErrClosed = errors.New("watchman: connection closed")
+
 // Client provides a high-level interface to Watchman.
+//
+// A Client is safe for concurrent use by multiple goroutines. Requests
+// share a single connection and are pipelined: each is sent without
+// waiting for the responses to earlier requests, and responses are
+// paired with requests in the order they were sent.
 type Client struct {
-	conn      *protocol.Connection
-	stop      func(bool)
-	requests  chan<- protocol.Request
-	responses <-chan result
-	updates   <-chan interface{}
+	opts      Options
+	updates   chan interface{}
+	signal    chan struct{}
+	closeOnce sync.Once
+	// closed is canceled by Close to stop the lanes, fail new requests
+	// with ErrClosed and interrupt reconnection
+	closed context.Context
+	cancel context.CancelFunc
+
+	// commands carries commands, and also subscriptions unless
+	// Options.SubscriptionConnections is set, in which case they are
+	// carried by the subscriptions lanes
+	commands      *lane
+	subscriptions []*lane
+
+	mu       sync.Mutex
+	watches  map[string]struct{}
+	subs     map[subscriptionKey]*Subscription
+	logLevel LogLevel
+	// pending holds the messages not yet emitted by Notifications, and
+	// stopped is set once every lane has stopped
+	pending []interface{}
+	stopped bool
+}
+
+// Options configures a Client.
+type Options struct {
+	// Reconnect enables reconnecting to the Watchman server when the
+	// connection is lost, for example because the server restarted.
+	// Watches and subscriptions are restored after reconnecting, and
+	// a *ReconnectNotification is emitted by Notifications.
+	Reconnect bool
+
+	// ReconnectInterval is the delay before the first attempt to
+	// reconnect. It doubles after each failed attempt, up to one
+	// minute. The default is one second.
+	ReconnectInterval time.Duration
+
+	// SubscriptionConnections is the number of connections dedicated
+	// to subscriptions. By default, subscriptions share the connection
+	// used for other commands, so a large notification, such as a
+	// fresh instance of a big root, delays the responses to commands
+	// until it has been received. With dedicated connections, each
+	// subscription is assigned to one of them, and commands are not
+	// delayed. If Reconnect is set, each connection reconnects on its
+	// own, and emits its own ReconnectNotification.
+	SubscriptionConnections int
+
+	// Connection configures how to locate and connect to the Watchman
+	// server, including when reconnecting.
+	Connection protocol.ConnectOptions
 }
 
 // Connect connects to or starts the Watchman server and returns a
 // new Client.
 func Connect() (c *Client, err error) {
-	conn, err := protocol.Connect()
-	if err != nil {
-		return
+	return ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect, but ctx limits how long it waits to
+// connect to the Watchman server.
+func ConnectContext(ctx context.Context) (c *Client, err error) {
+	return ConnectWithOptions(ctx, Options{})
+}
+
+// ConnectWithOptions is like ConnectContext, but configures the Client
+// with opts.
+func ConnectWithOptions(ctx context.Context, opts Options) (c *Client, err error) {
+	n := 1
+	if opts.SubscriptionConnections > 0 {
+		n += opts.SubscriptionConnections
+	}
+	conns := make([]*protocol.Connection, 0, n)
+	for i := 0; i < n; i++ {
+		conn, err := protocol.ConnectWithOptions(ctx, opts.Connection)
+		if err != nil {
+			for _, conn := range conns {
+				conn.Close()
+			}
+			return nil, err
+		}
+		conns = append(conns, conn)
 	}
 
-	loop, stop := startEventLoop(conn)
+	closed, cancel := context.WithCancel(context.Background())
 	c = &Client{
-		conn:      conn,
-		stop:      stop,
-		requests:  loop.requests,
-		responses: loop.responses,
-		updates:   loop.updates,
+		closed:  closed,
+		cancel:  cancel,
+		opts:    opts,
+		updates: make(chan interface{}),
+		signal:  make(chan struct{}, 1),
+		watches: map[string]struct{}{},
+		subs:    map[subscriptionKey]*Subscription{},
 	}
+	c.commands = newLane(c, conns[0])
+	for _, conn := range conns[1:] {
+		c.subscriptions = append(c.subscriptions, newLane(c, conn))
+	}
+	go c.run()
+	go c.deliver()
 	return
 }
 
-func (c *Client) send(req protocol.Request) (protocol.ResponsePDU, error) {
-	c.requests <- req
-	result, ok := <-c.responses
-	if !ok {
-		return nil, fmt.Errorf("watchman: connection closed")
+// run drives the lanes until the Client is closed.
+func (c *Client) run() {
+	var wg sync.WaitGroup
+	for _, l := range c.lanes() {
+		wg.Add(1)
+		go func(l *lane) {
+			defer wg.Done()
+			l.run()
+		}(l)
+	}
+	wg.Wait()
+
+	c.mu.Lock()
+	c.stopped = true
+	c.mu.Unlock()
+	c.notify()
+}
+
+// emit queues a message to be emitted by Notifications, so that the
+// event loop never waits for the consumer.
+func (c *Client) emit(msg interface{}) {
+	c.mu.Lock()
+	c.pending = append(c.pending, msg)
+	c.mu.Unlock()
+	c.notify()
+}
+
+func (c *Client) notify() {
+	select {
+	case c.signal <- struct{}{}:
+	default:
 	}
+}
+
+// deliver moves queued messages to the updates channel. Messages that
+// have not been delivered when the Client is closed are dropped.
+func (c *Client) deliver() {
+	/* SHUTDOWN
+	updates:     closed locally after every lane stops
+	*/
+	defer close(c.updates)
 
-	if result.err == nil {
-		return result.pdu, nil
-	} else {
-		return nil, result.err
+	for {
+		c.mu.Lock()
+		for len(c.pending) == 0 && !c.stopped {
+			c.mu.Unlock()
+			<-c.signal
+			c.mu.Lock()
+		}
+		if len(c.pending) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		msg := c.pending[0]
+		c.pending[0] = nil
+		c.pending = c.pending[1:]
+		c.mu.Unlock()
+
+		select {
+		case c.updates <- msg:
+		case <-c.closed.Done():
+		}
 	}
 }
 
+// dispatch handles a unilateral PDU from the Watchman server.
+//
+// Notifications for known subscriptions are routed to the subscription;
+// anything else is emitted by Notifications.
+func (c *Client) dispatch(pdu protocol.ResponsePDU) {
+	msg := translateUnilateralPDU(pdu)
+	if cn, ok := msg.(*ChangeNotification); ok {
+		key := subscriptionKey{root: cn.root, name: cn.Subscription}
+		c.mu.Lock()
+		s, ok := c.subs[key]
+		if ok && cn.Clock != "" && cn.State == nil {
+			// changes deferred by a state are notified after the
+			// state-leave, so resuming from its clock would skip them
+			s.clock = cn.Clock
+		}
+		c.mu.Unlock()
+
+		if ok {
+			if cn.canceled {
+				c.removeSubscription(s, ErrSubscriptionCanceled)
+				return
+			}
+			if s.query != nil && len(s.query.Fields) == 1 {
+				cn.Files = decodeFiles(pdu, s.query.Fields)
+			}
+			s.push(cn)
+			return
+		}
+		if cn.canceled {
+			// the subscription already ended, for example because
+			// its root was removed by this Client
+			return
+		}
+	}
+	c.emit(msg)
+}
+
+func (c *Client) addSubscription(s *Subscription) {
+	c.mu.Lock()
+	if old, ok := c.subs[s.key()]; ok {
+		// the server replaces a subscription with the same name
+		old.end(ErrSubscriptionCanceled)
+	}
+	c.subs[s.key()] = s
+	c.mu.Unlock()
+	go s.deliver()
+}
+
+func (c *Client) removeSubscription(s *Subscription, err error) {
+	c.mu.Lock()
+	if c.subs[s.key()] == s {
+		delete(c.subs, s.key())
+	}
+	c.mu.Unlock()
+	s.end(err)
+}
+
+// removeWatch forgets a root that is no longer watched, so that it is
+// not restored after reconnecting, and cancels its subscriptions.
+func (c *Client) removeWatch(root string) {
+	c.mu.Lock()
+	delete(c.watches, root)
+	var subs []*Subscription
+	for key, s := range c.subs {
+		if key.root == root {
+			subs = append(subs, s)
+			delete(c.subs, key)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, s := range subs {
+		s.end(ErrSubscriptionCanceled)
+	}
+}
+
+// endSubscriptions ends every subscription carried by l because of err.
+func (c *Client) endSubscriptions(l *lane, err error) {
+	c.mu.Lock()
+	var subs []*Subscription
+	for key, s := range c.subs {
+		if s.lane == l {
+			subs = append(subs, s)
+			delete(c.subs, key)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, s := range subs {
+		s.end(err)
+	}
+}
+
+// send issues a request over the command connection and waits for its
+// response. If ctx is done first, send returns ctx.Err() and the
+// response is discarded when it arrives.
+//
+// send is safe to call from multiple goroutines. Requests from
+// concurrent callers are pipelined over the connection; requests from
+// a single goroutine are sent, and answered, in the order it made them.
+func (c *Client) send(ctx context.Context, req protocol.Request) (protocol.ResponsePDU, error) {
+	return c.commands.send(ctx, req)
+}
+
 // AddWatch requests that the Watchman server monitor a directory for changes.
 //
 // Please note that Watchman may reuse an existing watch, or choose to start
@@ -55,8 +300,14 @@ func (c *Client) send(req protocol.Request) (protocol.ResponsePDU, error) {
 //
 // For details, see: https://facebook.github.io/watchman/docs/cmd/watch-project.html
 func (c *Client) AddWatch(path string) (*Watch, error) {
+	return c.AddWatchContext(context.Background(), path)
+}
+
+// AddWatchContext is like AddWatch, but gives up waiting for the
+// Watchman server when ctx is done.
+func (c *Client) AddWatchContext(ctx context.Context, path string) (*Watch, error) {
 	req := &protocol.WatchProjectRequest{Path: path}
-	pdu, err := c.send(req)
+	pdu, err := c.send(ctx, req)
 	if err != nil {
 		return nil, err
 	}
@@ -67,34 +318,88 @@ func (c *Client) AddWatch(path string) (*Watch, error) {
 		root:   res.Watch(),
 		rel:    res.RelativePath(),
 	}
+
+	c.mu.Lock()
+	c.watches[w.root] = struct{}{}
+	c.mu.Unlock()
 	return w, nil
 }
 
 // Close closes the connection to the Watchman server.
 func (c *Client) Close() error {
-	c.stop(false)
+	// Cancel c.closed and empty channels so that other goroutines can
+	// shutdown. The requests channels of the lanes are not closed, as
+	// other goroutines may still send on them.
+	c.closeOnce.Do(c.cancel)
+	for range c.updates {
+		continue
+	}
+	// allow other goroutines to run their shutdown logic;
+	// avoid false positives in tests to detect leaks
+	runtime.Gosched()
 	return nil
 }
 
+func (c *Client) connection() *protocol.Connection {
+	return c.commands.connection()
+}
+
 // HasCapability checks if the Watchman server supports a feature.
 //
 // For details, see: https://facebook.github.io/watchman/docs/capabilities.html
 func (c *Client) HasCapability(capability string) bool {
-	return c.conn.HasCapability(capability)
+	return c.connection().HasCapability(capability)
 }
 
 // ListWatches returns a list of directories that Watchman is monitoring.
 func (c *Client) ListWatches() (roots []string, err error) {
+	return c.ListWatchesContext(context.Background())
+}
+
+// ListWatchesContext is like ListWatches, but gives up waiting for the
+// Watchman server when ctx is done.
+func (c *Client) ListWatchesContext(ctx context.Context) (roots []string, err error) {
 	req := &protocol.WatchListRequest{}
-	if pdu, err := c.send(req); err == nil {
+	pdu, err := c.send(ctx, req)
+	if err == nil {
 		res := protocol.NewWatchListResponse(pdu)
 		roots = res.Roots()
 	}
 	return
 }
 
+// RemoveAllWatches stops watching every root, including the roots
+// watched by other clients of the Watchman server, and returns them.
+// The subscriptions of this Client end with ErrSubscriptionCanceled.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/watch-del-all.html
+func (c *Client) RemoveAllWatches(ctx context.Context) ([]string, error) {
+	req := &protocol.WatchDelAllRequest{}
+	pdu, err := c.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.watches = map[string]struct{}{}
+	subs := c.subs
+	c.subs = map[subscriptionKey]*Subscription{}
+	c.mu.Unlock()
+
+	for _, s := range subs {
+		s.end(ErrSubscriptionCanceled)
+	}
+	return protocol.NewWatchDelAllResponse(pdu).Roots(), nil
+}
+
 // Notifications returns a channel that emits unilateral messages
-// from the Watchman server.
+// from the Watchman server, other than the notifications of the
+// subscriptions made by this Client, which are emitted by
+// Subscription.Changes instead.
+//
+// Messages are buffered, so a slow consumer does not delay requests or
+// subscriptions. The channel is closed after the Client is closed, or
+// after the connection is lost if the Client does not reconnect.
 func (c *Client) Notifications() <-chan interface{} {
 	return c.updates
 }
@@ -102,10 +407,10 @@ func (c *Client) Notifications() <-chan interface{} {
 // SockName returns the location of then UNIX domain socket used
 // to communicate with the Watchman server.
 func (c *Client) SockName() string {
-	return c.conn.SockName()
+	return c.connection().SockName()
 }
 
 // Version returns the version of the Watchman server.
 func (c *Client) Version() string {
-	return c.conn.Version()
+	return c.connection().Version()
 }
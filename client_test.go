@@ -0,0 +1,1138 @@
+package watchman_test
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fortytw2/leaktest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cdmistman/watchman"
+	"github.com/cdmistman/watchman/ignore"
+	"github.com/cdmistman/watchman/protocol"
+	"github.com/cdmistman/watchman/protocol/query"
+	"github.com/cdmistman/watchman/watchmantest"
+)
+
+const timeout = 5 * time.Second
+
+func fake(t *testing.T) *watchmantest.Server {
+	srv := watchmantest.NewServer()
+	t.Setenv("WATCHMAN_SOCK", srv.SockName())
+	return srv
+}
+
+func next[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case x, ok := <-ch:
+		require.True(t, ok, "channel closed")
+		return x
+	case <-time.After(timeout):
+		require.FailNow(t, "timed out")
+	}
+	panic("unreachable")
+}
+
+func TestFakeSubscribe(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+	srv.WriteFile("/src", protocol.File{Name: "lib/a.go"})
+	srv.WriteFile("/src", protocol.File{Name: "main.go"})
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src/lib")
+	require.NoError(err)
+	require.Equal("/src", watch.Root())
+	require.Equal("lib", watch.RelativePath())
+
+	s, err := watch.Subscribe("sub1", &query.Query{
+		Fields: query.Fields{query.FName},
+	})
+	require.NoError(err)
+
+	cn := next(t, s.Changes())
+	require.True(cn.IsFreshInstance)
+	require.Equal("sub1", cn.Subscription)
+	require.Equal([]watchman.File{{Name: "a.go"}}, cn.Files)
+
+	srv.WriteFile("/src", protocol.File{Name: "main.go"})
+	srv.WriteFile("/src", protocol.File{Name: "lib/b.go"})
+	cn = next(t, s.Changes())
+	require.False(cn.IsFreshInstance)
+	require.Equal([]watchman.File{{Name: "b.go"}}, cn.Files)
+
+	srv.Push(map[string]interface{}{"log": "hello"})
+	require.Equal(&watchman.LogEntry{Message: "hello"}, next(t, c.Notifications()))
+	srv.Push(map[string]interface{}{"spoon": "there is no"})
+	pdu := next(t, c.Notifications()).(protocol.ResponsePDU)
+	require.Equal("there is no", pdu["spoon"])
+
+	require.NoError(s.Unsubscribe())
+	<-s.Done()
+	require.ErrorIs(s.Err(), watchman.ErrUnsubscribed)
+	_, ok := <-s.Changes()
+	require.False(ok)
+}
+
+func TestFakeQuery(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.WriteFile("/src", protocol.File{Name: "a.go", Size: 3})
+	srv.WriteFile("/src", protocol.File{Name: "b.txt"})
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+
+	result, err := watch.Query(context.Background(), &query.Query{
+		Expression: query.TSuffix{"go"},
+		Fields:     query.Fields{query.FName, query.FSize},
+	})
+	require.NoError(err)
+	require.True(result.IsFreshInstance)
+	require.Equal([]watchman.File{{Name: "a.go", Size: 3}}, result.Files)
+
+	clock, err := watch.Clock(0)
+	require.NoError(err)
+	require.Equal(result.Clock, clock)
+
+	srv.RemoveFile("/src", "a.go")
+	result, err = watch.Query(context.Background(), &query.Query{
+		Generators: query.Generators{query.GSince: clock},
+		Fields:     query.Fields{query.FName},
+	})
+	require.NoError(err)
+	require.False(result.IsFreshInstance)
+	require.Equal([]watchman.File{{Name: "a.go"}}, result.Files)
+
+	// queries are checked before they are sent, including against the
+	// capabilities of the server
+	n := len(srv.Requests())
+	_, err = watch.Query(context.Background(), &query.Query{Expression: query.TSuffix{}})
+	require.IsType(&query.ParseError{}, err)
+	_, err = watch.Query(context.Background(), &query.Query{Expression: query.TPCRE{Regexp: `\.go$`}})
+	require.ErrorIs(err, watchman.ErrUnsupported)
+	require.Contains(err.Error(), "term-pcre")
+	_, err = watch.Subscribe("pcre", &query.Query{Expression: query.TPCRE{Regexp: `\.go$`}})
+	require.ErrorIs(err, watchman.ErrUnsupported)
+	require.Len(srv.Requests(), n)
+}
+
+func TestFakeRelativeRoot(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+	srv.WriteFile("/src", protocol.File{Name: "lib/a.go"})
+	srv.WriteFile("/src", protocol.File{Name: "lib/sub/b.go"})
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src/lib")
+	require.NoError(err)
+
+	// the relative root of a query is relative to the watch, and the
+	// query of the caller is not modified
+	q := &query.Query{RelativeRoot: "sub", Fields: query.Fields{query.FName}}
+	result, err := watch.Query(context.Background(), q)
+	require.NoError(err)
+	require.Equal([]watchman.File{{Name: "b.go"}}, result.Files)
+	require.Equal("sub", q.RelativeRoot)
+
+	s, err := watch.Subscribe("sub1", q)
+	require.NoError(err)
+	require.Equal([]watchman.File{{Name: "b.go"}}, next(t, s.Changes()).Files)
+	require.Equal("sub", q.RelativeRoot)
+
+	reqs := srv.Requests()
+	spec := reqs[len(reqs)-1].Args[2].(map[string]interface{})
+	require.Equal("lib/sub", spec["relative_root"])
+}
+
+func TestFakeConnectionLost(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+	s, err := watch.Subscribe("sub1", nil)
+	require.NoError(err)
+	next(t, s.Changes())
+
+	srv.CloseConnections()
+	<-s.Done()
+	require.Error(s.Err())
+
+	_, err = c.ListWatches()
+	require.ErrorIs(err, watchman.ErrClosed)
+}
+
+func TestFakeClose(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+
+	// requests racing with Close either succeed or fail with ErrClosed
+	const n = 20
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := watch.Clock(0); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	require.NoError(c.Close())
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.ErrorIs(err, watchman.ErrClosed)
+	}
+
+	_, err = watch.Clock(0)
+	require.ErrorIs(err, watchman.ErrClosed)
+	_, err = c.ListWatches()
+	require.ErrorIs(err, watchman.ErrClosed)
+	_, err = watch.Subscribe("sub1", nil)
+	require.ErrorIs(err, watchman.ErrClosed)
+	require.NoError(c.Close())
+}
+
+func TestFakeReconnect(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := watchman.ConnectWithOptions(ctx, watchman.Options{
+		Reconnect:         true,
+		ReconnectInterval: 10 * time.Millisecond,
+	})
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+	s, err := watch.Subscribe("sub1", &query.Query{
+		Fields: query.Fields{query.FName},
+	})
+	require.NoError(err)
+	next(t, s.Changes())
+
+	srv.CloseConnections()
+	srv.WriteFile("/src", protocol.File{Name: "missed.go"})
+
+	n := next(t, c.Notifications()).(*watchman.ReconnectNotification)
+	require.Error(n.Err)
+	require.Equal([]string{"sub1"}, n.Subscriptions)
+	require.Empty(n.RestoreErrors)
+
+	// the restored subscription resumes from its last clock
+	cn := next(t, s.Changes())
+	require.False(cn.IsFreshInstance)
+	require.Equal([]watchman.File{{Name: "missed.go"}}, cn.Files)
+
+	srv.WriteFile("/src", protocol.File{Name: "after.go"})
+	cn = next(t, s.Changes())
+	require.Equal([]watchman.File{{Name: "after.go"}}, cn.Files)
+	require.NoError(s.Err())
+}
+
+func TestFakeCloseDuringRestore(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := watchman.ConnectWithOptions(ctx, watchman.Options{
+		Reconnect:         true,
+		ReconnectInterval: 10 * time.Millisecond,
+	})
+	require.NoError(err)
+	_, err = c.AddWatch("/src")
+	require.NoError(err)
+
+	// the server stalls restoring the watch after reconnecting
+	restoring := make(chan struct{}, 1)
+	release := make(chan struct{})
+	defer close(release)
+	srv.Handle("watch-project", func(args []interface{}) (map[string]interface{}, error) {
+		select {
+		case restoring <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil, nil
+	})
+	srv.CloseConnections()
+	next(t, restoring)
+
+	closed := make(chan error)
+	go func() { closed <- c.Close() }()
+	require.NoError(next(t, closed))
+}
+
+func TestFakeTriggers(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src/web")
+	require.NoError(err)
+
+	ctx := context.Background()
+	spec := &watchman.TriggerSpec{
+		Name:        "assets",
+		Command:     []string{"make", "assets"},
+		Expression:  query.TSuffix{"css"},
+		AppendFiles: true,
+		Stdin:       protocol.StdinNamePerLine,
+		Stdout:      protocol.Redirect{Path: "/tmp/assets.log", Append: true},
+	}
+	require.NoError(watch.AddTrigger(ctx, spec))
+
+	triggers, err := watch.ListTriggers(ctx)
+	require.NoError(err)
+	require.Len(triggers, 1)
+	require.Equal("assets", triggers[0].Name)
+	require.Equal([]string{"make", "assets"}, triggers[0].Command)
+	require.Equal("web", triggers[0].RelativeRoot)
+	require.Equal(protocol.StdinNamePerLine, triggers[0].Stdin)
+	require.Equal(spec.Stdout, triggers[0].Stdout)
+
+	require.NoError(watch.RemoveTrigger(ctx, "assets"))
+	triggers, err = watch.ListTriggers(ctx)
+	require.NoError(err)
+	require.Empty(triggers)
+
+	err = watch.RemoveTrigger(ctx, "assets")
+	require.IsType(&protocol.WatchmanError{}, err)
+
+	// expressions are checked against the capabilities of the server
+	err = watch.AddTrigger(ctx, &watchman.TriggerSpec{
+		Name:       "pcre",
+		Command:    []string{"true"},
+		Expression: query.TPCRE{Regexp: `\.go$`},
+	})
+	require.ErrorIs(err, watchman.ErrUnsupported)
+	require.Contains(err.Error(), "term-pcre")
+}
+
+func TestFakeStates(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+
+	ctx := context.Background()
+	q := &query.Query{Fields: query.Fields{query.FName}}
+	deferred, err := watch.SubscribeWithOptions(ctx, "deferred", q, watchman.SubscribeOptions{
+		Defer: []string{"hg.update"},
+	})
+	require.NoError(err)
+	dropped, err := watch.SubscribeWithOptions(ctx, "dropped", q, watchman.SubscribeOptions{
+		Drop: []string{"hg.update"},
+	})
+	require.NoError(err)
+	next(t, deferred.Changes())
+	next(t, dropped.Changes())
+
+	state, err := watch.EnterState(ctx, "hg.update", map[string]interface{}{"rev": "abc"})
+	require.NoError(err)
+	require.Equal("hg.update", state.Name())
+	require.NotEmpty(state.Clock())
+
+	for _, s := range []*watchman.Subscription{deferred, dropped} {
+		cn := next(t, s.Changes())
+		require.Equal(&watchman.StateChange{
+			Name:     "hg.update",
+			Entered:  true,
+			Metadata: map[string]interface{}{"rev": "abc"},
+		}, cn.State)
+		require.Empty(cn.Files)
+	}
+
+	srv.WriteFile("/src", protocol.File{Name: "during"})
+	require.NoError(state.Leave("done"))
+
+	for _, s := range []*watchman.Subscription{deferred, dropped} {
+		cn := next(t, s.Changes())
+		require.Equal(&watchman.StateChange{
+			Name:     "hg.update",
+			Metadata: "done",
+		}, cn.State)
+	}
+
+	// deferred changes follow the state-leave; dropped changes are lost
+	cn := next(t, deferred.Changes())
+	require.Nil(cn.State)
+	require.Equal([]watchman.File{{Name: "during"}}, cn.Files)
+
+	srv.WriteFile("/src", protocol.File{Name: "after"})
+	for _, s := range []*watchman.Subscription{deferred, dropped} {
+		cn := next(t, s.Changes())
+		require.Equal([]watchman.File{{Name: "after"}}, cn.Files)
+	}
+
+	// the state is left even if fn fails
+	err = watch.WithState(ctx, "build.codegen", nil, func(ctx context.Context) error {
+		return context.Canceled
+	})
+	require.ErrorIs(err, context.Canceled)
+	cn = next(t, deferred.Changes())
+	require.Equal("build.codegen", cn.State.Name)
+	require.True(cn.State.Entered)
+	cn = next(t, deferred.Changes())
+	require.Equal("build.codegen", cn.State.Name)
+	require.False(cn.State.Entered)
+
+	require.Error(state.Leave(nil))
+
+	// leaving the state waits for at most as long as ctx allows
+	release := make(chan struct{})
+	defer close(release)
+	srv.Handle("state-leave", func(args []interface{}) (map[string]interface{}, error) {
+		<-release
+		return nil, nil
+	})
+	shortCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	err = watch.WithState(shortCtx, "build.codegen", nil, func(ctx context.Context) error {
+		return nil
+	})
+	require.ErrorIs(err, context.DeadlineExceeded)
+}
+
+func TestFakeSubscribeOptions(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+	srv.WriteFile("/src", protocol.File{Name: "old"})
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+	clock, err := watch.Clock(0)
+	require.NoError(err)
+	srv.WriteFile("/src", protocol.File{Name: "new"})
+
+	ctx := context.Background()
+	q := &query.Query{Fields: query.Fields{query.FName}}
+	empty, err := watch.SubscribeWithOptions(ctx, "empty", q, watchman.SubscribeOptions{
+		DisableDeferVCS:      true,
+		SettlePeriod:         20 * time.Millisecond,
+		SettleTimeout:        time.Second,
+		EmptyOnFreshInstance: true,
+	})
+	require.NoError(err)
+	cn := next(t, empty.Changes())
+	require.True(cn.IsFreshInstance)
+	require.Empty(cn.Files)
+
+	since, err := watch.SubscribeWithOptions(ctx, "since", q, watchman.SubscribeOptions{
+		Since: clock,
+	})
+	require.NoError(err)
+	cn = next(t, since.Changes())
+	require.False(cn.IsFreshInstance)
+	require.Equal([]watchman.File{{Name: "new"}}, cn.Files)
+
+	reqs := srv.Requests()
+	var specs []interface{}
+	for _, req := range reqs {
+		if req.Command == "subscribe" {
+			specs = append(specs, req.Args[2])
+		}
+	}
+	require.Len(specs, 2)
+	spec := specs[0].(map[string]interface{})
+	require.Equal(false, spec["defer_vcs"])
+	require.EqualValues(20, spec["settle_period"])
+	require.EqualValues(1000, spec["settle_timeout"])
+
+	_, err = watch.SubscribeWithOptions(ctx, "dedup", &query.Query{DedupResults: true}, watchman.SubscribeOptions{})
+	require.ErrorIs(err, watchman.ErrUnsupported)
+	require.Contains(err.Error(), "dedup_results")
+}
+
+func TestFakeSubscribeCanceled(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+
+	received, release := make(chan struct{}), make(chan struct{})
+	srv.Handle("subscribe", func(args []interface{}) (map[string]interface{}, error) {
+		close(received)
+		<-release
+		return map[string]interface{}{"clock": "c:0:1", "subscribe": args[1]}, nil
+	})
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+
+	// the server answers after the caller gave up
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-received
+		cancel()
+	}()
+	s, err := watch.SubscribeContext(ctx, "late", nil)
+	require.ErrorIs(err, context.Canceled)
+	require.Nil(s)
+	close(release)
+
+	unsubscribed := func() bool {
+		for _, req := range srv.Requests() {
+			if req.Command == "unsubscribe" {
+				return req.Args[1] == "late"
+			}
+		}
+		return false
+	}
+	require.Eventually(unsubscribed, timeout, time.Millisecond)
+}
+
+func TestFakeConcurrentRequests(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	// the clock of a root identifies it, up to the trailing tick
+	var watches []*watchman.Watch
+	var prefixes []string
+	for _, dir := range []string{"/a", "/b", "/c", "/d"} {
+		watch, err := c.AddWatch(dir)
+		require.NoError(err)
+		clock, err := watch.Clock(0)
+		require.NoError(err)
+		watches = append(watches, watch)
+		prefixes = append(prefixes, clock[:strings.LastIndex(clock, ":")+1])
+	}
+
+	sub, err := watches[0].Subscribe("sub", &query.Query{Fields: query.Fields{query.FName}})
+	require.NoError(err)
+	next(t, sub.Changes())
+
+	const n = 50
+	errs := make(chan error, 2*n*len(watches))
+	var wg sync.WaitGroup
+	for i, watch := range watches {
+		for j := 0; j < n; j++ {
+			wg.Add(2)
+			go func(watch *watchman.Watch, prefix string) {
+				defer wg.Done()
+				clock, err := watch.Clock(0)
+				if err == nil && !strings.HasPrefix(clock, prefix) {
+					err = fmt.Errorf("clock %s of %s, want prefix %s", clock, watch.Root(), prefix)
+				}
+				errs <- err
+			}(watch, prefixes[i])
+			go func() {
+				defer wg.Done()
+				roots, err := c.ListWatches()
+				if err == nil && len(roots) != len(watches) {
+					err = fmt.Errorf("listed %d roots, want %d", len(roots), len(watches))
+				}
+				errs <- err
+			}()
+		}
+	}
+
+	// unilateral PDUs are delivered while requests are in flight
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("f%d", i)
+		srv.WriteFile("/a", protocol.File{Name: name})
+		cn := next(t, sub.Changes())
+		require.Equal([]watchman.File{{Name: name}}, cn.Files)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(err)
+	}
+}
+
+func TestFakeSubscriptionConnections(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := watchman.ConnectWithOptions(ctx, watchman.Options{
+		Reconnect:               true,
+		ReconnectInterval:       10 * time.Millisecond,
+		SubscriptionConnections: 2,
+	})
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+
+	// stall the command connection
+	release := make(chan struct{})
+	srv.Handle("clock", func(args []interface{}) (map[string]interface{}, error) {
+		<-release
+		return map[string]interface{}{"clock": "c:0:0:1:1"}, nil
+	})
+	clocked := make(chan error, 1)
+	go func() {
+		_, err := watch.Clock(0)
+		clocked <- err
+	}()
+
+	// subscriptions do not wait for it
+	s, err := watch.SubscribeContext(ctx, "sub1", &query.Query{
+		Fields: query.Fields{query.FName},
+	})
+	require.NoError(err)
+	next(t, s.Changes())
+	srv.WriteFile("/src", protocol.File{Name: "main.go"})
+	cn := next(t, s.Changes())
+	require.Equal([]watchman.File{{Name: "main.go"}}, cn.Files)
+
+	close(release)
+	require.NoError(next(t, clocked))
+
+	// every connection reconnects, and the subscription is restored on
+	// its own connection
+	srv.CloseConnections()
+	var restored []string
+	for i := 0; i < 3; i++ {
+		n := next(t, c.Notifications()).(*watchman.ReconnectNotification)
+		require.Empty(n.RestoreErrors)
+		restored = append(restored, n.Subscriptions...)
+	}
+	require.Equal([]string{"sub1"}, restored)
+	cn = next(t, s.Changes())
+	require.False(cn.IsFreshInstance)
+	require.Empty(cn.Files)
+
+	srv.WriteFile("/src", protocol.File{Name: "after.go"})
+	cn = next(t, s.Changes())
+	require.Equal([]watchman.File{{Name: "after.go"}}, cn.Files)
+	require.NoError(s.Unsubscribe())
+}
+
+func TestFakeSince(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+	srv.WriteFile("/src", protocol.File{Name: "old.go", Size: 1})
+	srv.WriteFile("/src", protocol.File{Name: "web/old.css"})
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+	ctx := context.Background()
+
+	// without a clock, every existing file is listed
+	result, err := watch.Since(ctx, "")
+	require.NoError(err)
+	require.True(result.IsFreshInstance)
+	require.Len(result.Files, 2)
+
+	clock := result.Clock
+	srv.WriteFile("/src", protocol.File{Name: "old.go", Size: 2})
+	srv.WriteFile("/src", protocol.File{Name: "web/new.css"})
+	srv.RemoveFile("/src", "web/old.css")
+
+	result, err = watch.Since(ctx, clock)
+	require.NoError(err)
+	require.False(result.IsFreshInstance)
+	require.NotEqual(clock, result.Clock)
+	require.Equal([]watchman.File{
+		{Name: "old.go", Exists: true, Size: 2},
+		{Name: "web/new.css", Exists: true, New: true},
+		{Name: "web/old.css"},
+	}, result.Files)
+
+	result, err = watch.Since(ctx, clock, query.FName)
+	require.NoError(err)
+	require.Equal([]watchman.File{
+		{Name: "old.go"}, {Name: "web/new.css"}, {Name: "web/old.css"},
+	}, result.Files)
+
+	// a clock of another server instance is not recognized
+	result, err = watch.Since(ctx, "c:1:2:3:4")
+	require.NoError(err)
+	require.True(result.IsFreshInstance)
+	require.Equal([]watchman.File{
+		{Name: "old.go", Exists: true, Size: 2},
+		{Name: "web/new.css", Exists: true},
+	}, result.Files)
+
+	// a Watch of a subdirectory reports names relative to it
+	web, err := c.AddWatch("/src/web")
+	require.NoError(err)
+	result, err = web.Since(ctx, clock)
+	require.NoError(err)
+	require.Equal([]watchman.File{
+		{Name: "new.css", Exists: true, New: true},
+		{Name: "old.css"},
+	}, result.Files)
+}
+
+func TestFakeFind(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+	srv.WriteFile("/src", protocol.File{Name: "main.go"})
+	srv.WriteFile("/src", protocol.File{Name: "web/app.js"})
+	srv.WriteFile("/src", protocol.File{Name: "web/lib/util.js"})
+	srv.WriteFile("/src", protocol.File{Name: "web/gone.js"})
+	srv.RemoveFile("/src", "web/gone.js")
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+	ctx := context.Background()
+
+	result, err := watch.Find(ctx)
+	require.NoError(err)
+	require.NotEmpty(result.Clock)
+	require.Equal([]watchman.File{
+		{Name: "main.go", Exists: true},
+		{Name: "web/app.js", Exists: true},
+		{Name: "web/lib/util.js", Exists: true},
+	}, result.Files)
+
+	result, err = watch.Find(ctx, "*.go", "web/*.js")
+	require.NoError(err)
+	require.Equal([]watchman.File{
+		{Name: "main.go", Exists: true},
+		{Name: "web/app.js", Exists: true},
+	}, result.Files)
+
+	// a Watch of a subdirectory matches names relative to it
+	web, err := c.AddWatch("/src/web")
+	require.NoError(err)
+	result, err = web.Find(ctx, "**/*.js")
+	require.NoError(err)
+	require.Equal([]watchman.File{
+		{Name: "app.js", Exists: true},
+		{Name: "lib/util.js", Exists: true},
+	}, result.Files)
+}
+
+func TestFakeRemoveWatch(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := watchman.ConnectWithOptions(ctx, watchman.Options{
+		Reconnect:         true,
+		ReconnectInterval: 10 * time.Millisecond,
+	})
+	require.NoError(err)
+	defer c.Close()
+
+	q := &query.Query{Fields: query.Fields{query.FName}}
+	a, err := c.AddWatch("/a")
+	require.NoError(err)
+	subA, err := a.Subscribe("sub", q)
+	require.NoError(err)
+	next(t, subA.Changes())
+	b, err := c.AddWatch("/b")
+	require.NoError(err)
+	subB, err := b.Subscribe("sub", q)
+	require.NoError(err)
+	next(t, subB.Changes())
+
+	require.NoError(a.Remove(ctx))
+	<-subA.Done()
+	require.ErrorIs(subA.Err(), watchman.ErrSubscriptionCanceled)
+	require.NoError(subB.Err())
+	require.Equal([]string{"/b"}, srv.Roots())
+
+	err = a.Remove(ctx)
+	require.IsType(&protocol.WatchmanError{}, err)
+	require.ErrorIs(err, protocol.ErrRootNotWatched)
+
+	// the removed root is not restored after reconnecting
+	srv.CloseConnections()
+	n := next(t, c.Notifications()).(*watchman.ReconnectNotification)
+	require.Empty(n.RestoreErrors)
+	require.Equal([]string{"sub"}, n.Subscriptions)
+	require.Equal([]string{"/b"}, srv.Roots())
+	next(t, subB.Changes())
+
+	roots, err := c.RemoveAllWatches(ctx)
+	require.NoError(err)
+	require.Equal([]string{"/b"}, roots)
+	<-subB.Done()
+	require.ErrorIs(subB.Err(), watchman.ErrSubscriptionCanceled)
+	require.Empty(srv.Roots())
+
+	roots, err = c.ListWatches()
+	require.NoError(err)
+	require.Empty(roots)
+}
+
+func TestFakeAdmin(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := watchman.ConnectWithOptions(ctx, watchman.Options{
+		Reconnect:         true,
+		ReconnectInterval: 10 * time.Millisecond,
+	})
+	require.NoError(err)
+	defer c.Close()
+
+	pid, err := c.ServerPID(ctx)
+	require.NoError(err)
+	require.Equal(os.Getpid(), pid)
+
+	version, err := c.ServerVersion(ctx, []string{"cmd-subscribe"}, []string{"grant-three-wishes"})
+	require.NoError(err)
+	require.Equal(watchmantest.Version, version.Version)
+	require.Equal(map[string]bool{
+		"cmd-subscribe":      true,
+		"grant-three-wishes": false,
+	}, version.Capabilities)
+
+	_, err = c.ServerVersion(ctx, []string{"grant-three-wishes"}, nil)
+	require.IsType(&protocol.WatchmanError{}, err)
+
+	// log entries are emitted by Notifications
+	require.NoError(c.SetLogLevel(ctx, protocol.LogError))
+	require.NoError(c.Log(ctx, protocol.LogDebug, "hidden"))
+	require.NoError(c.Log(ctx, protocol.LogError, "shown"))
+	srv.Log(protocol.LogError, "from the server")
+	require.Equal(&watchman.LogEntry{Level: protocol.LogError, Message: "shown"}, next(t, c.Notifications()))
+	require.Equal(&watchman.LogEntry{Level: protocol.LogError, Message: "from the server"}, next(t, c.Notifications()))
+
+	// the server restarts, and watches, subscriptions and the log
+	// level are restored
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+	s, err := watch.Subscribe("sub", &query.Query{Fields: query.Fields{query.FName}})
+	require.NoError(err)
+	next(t, s.Changes())
+
+	require.NoError(c.ShutdownServer(ctx))
+	n := next(t, c.Notifications()).(*watchman.ReconnectNotification)
+	require.Empty(n.RestoreErrors)
+	require.Equal([]string{"sub"}, n.Subscriptions)
+	require.Equal([]string{"/src"}, srv.Roots())
+
+	cn := next(t, s.Changes())
+	require.True(cn.IsFreshInstance)
+
+	srv.Log(protocol.LogError, "after restart")
+	require.Equal(&watchman.LogEntry{Level: protocol.LogError, Message: "after restart"}, next(t, c.Notifications()))
+
+	require.NoError(c.SetLogLevel(ctx, protocol.LogOff))
+	require.NoError(c.Log(ctx, protocol.LogError, "not emitted"))
+}
+
+func TestFakeConfig(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := watchman.ConnectContext(ctx)
+	require.NoError(err)
+	defer c.Close()
+
+	// the configuration is written, read back, and read by the server
+	dir := t.TempDir()
+	config := &watchman.Config{
+		IgnoreDirs:      []string{"node_modules"},
+		IgnoreVCS:       []string{},
+		Settle:          20 * time.Millisecond,
+		FSEventsLatency: 10 * time.Millisecond,
+		IdleReapAge:     -1,
+		GCAge:           time.Hour,
+		Extra:           map[string]interface{}{"spoon": "Spoon!"},
+	}
+	require.NoError(watchman.WriteConfig(dir, config))
+	b, err := os.ReadFile(filepath.Join(dir, watchman.ConfigFile))
+	require.NoError(err)
+	require.JSONEq(`{
+		"ignore_dirs": ["node_modules"],
+		"ignore_vcs": [],
+		"settle": 20,
+		"fsevents_latency": 0.01,
+		"idle_reap_age_seconds": 0,
+		"gc_age_seconds": 3600,
+		"spoon": "Spoon!"
+	}`, string(b))
+
+	read, err := watchman.ReadConfig(dir)
+	require.NoError(err)
+	require.Equal(config, read)
+
+	watch, err := c.AddWatch(dir)
+	require.NoError(err)
+	read, err = watch.Config(ctx)
+	require.NoError(err)
+	require.Equal(config, read)
+
+	// a root without a configuration file
+	watch, err = c.AddWatch("/src")
+	require.NoError(err)
+	read, err = watch.Config(ctx)
+	require.NoError(err)
+	require.Equal(&watchman.Config{}, read)
+
+	srv.SetConfig("/src", map[string]interface{}{"settle": "soon"})
+	_, err = watch.Config(ctx)
+	require.EqualError(err, "watchman: config: settle must be a number")
+
+	_, err = watchman.ReadConfig(t.TempDir())
+	require.ErrorIs(err, fs.ErrNotExist)
+}
+
+func TestFakeFlushSubscriptions(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	srv.Watch("/src")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := watchman.ConnectWithOptions(ctx, watchman.Options{SubscriptionConnections: 2})
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+
+	q := &query.Query{Fields: query.Fields{query.FName}}
+	deferred, err := watch.SubscribeWithOptions(ctx, "deferred", q, watchman.SubscribeOptions{
+		Defer: []string{"hg.update"},
+	})
+	require.NoError(err)
+	dropped, err := watch.SubscribeWithOptions(ctx, "dropped", q, watchman.SubscribeOptions{
+		Drop: []string{"hg.update"},
+	})
+	require.NoError(err)
+	next(t, deferred.Changes())
+	next(t, dropped.Changes())
+
+	res, err := watch.FlushSubscriptions(ctx, time.Second)
+	require.NoError(err)
+	require.ElementsMatch([]string{"deferred", "dropped"}, res.NoSyncNeeded)
+	require.Empty(res.Synced)
+	require.Empty(res.Dropped)
+
+	// changes are flushed even while deferred
+	state, err := watch.EnterState(ctx, "hg.update", nil)
+	require.NoError(err)
+	next(t, deferred.Changes())
+	next(t, dropped.Changes())
+
+	srv.WriteFile("/src", protocol.File{Name: "during"})
+	res, err = watch.FlushSubscriptions(ctx, time.Second)
+	require.NoError(err)
+	require.Equal(&watchman.FlushResult{
+		Synced:       []string{"deferred"},
+		NoSyncNeeded: []string{"dropped"},
+	}, res)
+	cn := next(t, deferred.Changes())
+	require.Equal([]watchman.File{{Name: "during"}}, cn.Files)
+
+	res, err = watch.FlushSubscriptions(ctx, time.Second, "deferred")
+	require.NoError(err)
+	require.Equal(&watchman.FlushResult{NoSyncNeeded: []string{"deferred"}}, res)
+
+	_, err = watch.FlushSubscriptions(ctx, time.Second, "unknown")
+	require.IsType(&protocol.WatchmanError{}, err)
+
+	// flushed changes are not notified again after the state-leave
+	require.NoError(state.Leave(nil))
+	next(t, deferred.Changes())
+	next(t, dropped.Changes())
+	srv.WriteFile("/src", protocol.File{Name: "after"})
+	for _, s := range []*watchman.Subscription{deferred, dropped} {
+		cn := next(t, s.Changes())
+		require.Equal([]watchman.File{{Name: "after"}}, cn.Files)
+	}
+}
+
+func TestFakeConnectOptions(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	// the server has no socket, so connections, including reconnections,
+	// can only be made by the dialer
+	srv := watchmantest.NewUnstartedServer()
+	defer srv.Close()
+	t.Setenv("WATCHMAN_SOCK", "")
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	c, err := watchman.ConnectWithOptions(ctx, watchman.Options{
+		Reconnect:         true,
+		ReconnectInterval: 10 * time.Millisecond,
+		Connection:        srv.ConnectOptions(),
+	})
+	require.NoError(err)
+	defer c.Close()
+	require.Equal("watchmantest", c.SockName())
+
+	_, err = c.AddWatch("/src")
+	require.NoError(err)
+
+	srv.CloseConnections()
+	n := next(t, c.Notifications()).(*watchman.ReconnectNotification)
+	require.Empty(n.RestoreErrors)
+
+	roots, err := c.ListWatches()
+	require.NoError(err)
+	require.Equal([]string{"/src"}, roots)
+}
+
+func TestFakeIgnore(t *testing.T) {
+	require := require.New(t)
+	defer leaktest.Check(t)()
+
+	srv := fake(t)
+	defer srv.Close()
+	for _, name := range []string{"main.go", "debug.log", "keep.log", "lib/lib.go", "lib/.cache/x", "build/out/a.o"} {
+		srv.WriteFile("/src", protocol.File{Name: name})
+	}
+	srv.WriteFile("/src", protocol.File{Name: "build", Type: "d"})
+
+	c, err := watchman.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	watch, err := c.AddWatch("/src")
+	require.NoError(err)
+
+	patterns, err := ignore.ParseGitignore(strings.NewReader("*.log\n!keep.log\nbuild/\n.cache\n"), "")
+	require.NoError(err)
+	q := query.New().Not(ignore.Compile(patterns)).Fields(query.FName).Query()
+	require.NoError(q.Validate(c.HasCapability))
+
+	result, err := watch.Query(context.Background(), q)
+	require.NoError(err)
+	names := []string{}
+	for _, f := range result.Files {
+		names = append(names, f.Name)
+	}
+	require.ElementsMatch([]string{"main.go", "keep.log", "lib/lib.go"}, names)
+}
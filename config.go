@@ -0,0 +1,270 @@
+package watchman
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cdmistman/watchman/protocol"
+)
+
+// ConfigFile is the name of the configuration file of a watched root.
+const ConfigFile = ".watchmanconfig"
+
+// Config is the configuration of a watched root, as found in its
+// .watchmanconfig file.
+//
+// Options that are unset keep the default of the Watchman server. Zero
+// values are not encoded, except that a non-nil empty slice is, for
+// example to disable the default IgnoreVCS.
+//
+// See also: https://facebook.github.io/watchman/docs/config.html
+type Config struct {
+	// IgnoreDirs lists directories, relative to the root, that are not
+	// watched.
+	IgnoreDirs []string
+	// IgnoreVCS lists version control directories whose contents are
+	// not reported. The default is .git, .hg and .svn.
+	IgnoreVCS []string
+	// Settle is how long the root must be idle before notifications
+	// are sent.
+	Settle time.Duration
+	// FSEventsLatency is the latency of the FSEvents watcher on macOS.
+	FSEventsLatency time.Duration
+	// IdleReapAge is how long a root may go without queries before it
+	// is no longer watched. A negative value, encoded as zero, means
+	// that the root is never reaped.
+	IdleReapAge time.Duration
+	// GCAge is how long deleted files are remembered, and GCInterval
+	// how often they are forgotten.
+	GCAge      time.Duration
+	GCInterval time.Duration
+	// RootFiles lists files that mark the root of a project, and
+	// EnforceRootFiles restricts watches to such roots. They are only
+	// honored in the global configuration file.
+	RootFiles        []string
+	EnforceRootFiles bool
+	// HintNumFilesPerDir and HintNumDirs size the internal tables of
+	// the Watchman server.
+	HintNumFilesPerDir int
+	HintNumDirs        int
+	// SuppressRecrawlWarnings omits the warning reported by queries
+	// after the root was recrawled.
+	SuppressRecrawlWarnings bool
+	// Extra holds the options not modeled by Config, so that they are
+	// preserved.
+	Extra map[string]interface{}
+}
+
+// MarshalJSON encodes the configuration in the format of the
+// .watchmanconfig file.
+func (cfg Config) MarshalJSON() ([]byte, error) {
+	res := map[string]interface{}{}
+	for k, v := range cfg.Extra {
+		res[k] = v
+	}
+
+	if cfg.IgnoreDirs != nil {
+		res["ignore_dirs"] = cfg.IgnoreDirs
+	}
+
+	if cfg.IgnoreVCS != nil {
+		res["ignore_vcs"] = cfg.IgnoreVCS
+	}
+
+	if cfg.Settle != 0 {
+		res["settle"] = cfg.Settle.Milliseconds()
+	}
+
+	if cfg.FSEventsLatency != 0 {
+		res["fsevents_latency"] = cfg.FSEventsLatency.Seconds()
+	}
+
+	if cfg.IdleReapAge > 0 {
+		res["idle_reap_age_seconds"] = int64(cfg.IdleReapAge / time.Second)
+	} else if cfg.IdleReapAge < 0 {
+		res["idle_reap_age_seconds"] = 0
+	}
+
+	if cfg.GCAge != 0 {
+		res["gc_age_seconds"] = int64(cfg.GCAge / time.Second)
+	}
+
+	if cfg.GCInterval != 0 {
+		res["gc_interval_seconds"] = int64(cfg.GCInterval / time.Second)
+	}
+
+	if cfg.RootFiles != nil {
+		res["root_files"] = cfg.RootFiles
+	}
+
+	if cfg.EnforceRootFiles {
+		res["enforce_root_files"] = true
+	}
+
+	if cfg.HintNumFilesPerDir != 0 {
+		res["hint_num_files_per_dir"] = cfg.HintNumFilesPerDir
+	}
+
+	if cfg.HintNumDirs != 0 {
+		res["hint_num_dirs"] = cfg.HintNumDirs
+	}
+
+	if cfg.SuppressRecrawlWarnings {
+		res["suppress_recrawl_warnings"] = true
+	}
+
+	return json.Marshal(res)
+}
+
+// UnmarshalJSON decodes the configuration from the format of the
+// .watchmanconfig file. It fails if a known option has the wrong type.
+func (cfg *Config) UnmarshalJSON(b []byte) error {
+	var x map[string]interface{}
+	if err := json.Unmarshal(b, &x); err != nil {
+		return err
+	}
+	c, err := newConfig(x)
+	if err != nil {
+		return err
+	}
+	*cfg = *c
+	return nil
+}
+
+// newConfig converts the configuration, decoded to primitive Go values
+// from JSON or BSER, to a Config.
+func newConfig(x map[string]interface{}) (*Config, error) {
+	cfg := &Config{}
+	for k, v := range x {
+		var err error
+		switch k {
+		case "ignore_dirs":
+			cfg.IgnoreDirs, err = configStrings(k, v)
+		case "ignore_vcs":
+			cfg.IgnoreVCS, err = configStrings(k, v)
+		case "settle":
+			cfg.Settle, err = configDuration(k, v, time.Millisecond)
+		case "fsevents_latency":
+			cfg.FSEventsLatency, err = configDuration(k, v, time.Second)
+		case "idle_reap_age_seconds":
+			cfg.IdleReapAge, err = configDuration(k, v, time.Second)
+			if err == nil && cfg.IdleReapAge == 0 {
+				cfg.IdleReapAge = -1
+			}
+		case "gc_age_seconds":
+			cfg.GCAge, err = configDuration(k, v, time.Second)
+		case "gc_interval_seconds":
+			cfg.GCInterval, err = configDuration(k, v, time.Second)
+		case "root_files":
+			cfg.RootFiles, err = configStrings(k, v)
+		case "enforce_root_files":
+			cfg.EnforceRootFiles, err = configBool(k, v)
+		case "hint_num_files_per_dir":
+			cfg.HintNumFilesPerDir, err = configInt(k, v)
+		case "hint_num_dirs":
+			cfg.HintNumDirs, err = configInt(k, v)
+		case "suppress_recrawl_warnings":
+			cfg.SuppressRecrawlWarnings, err = configBool(k, v)
+		default:
+			if cfg.Extra == nil {
+				cfg.Extra = map[string]interface{}{}
+			}
+			cfg.Extra[k] = v
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+func configStrings(k string, v interface{}) ([]string, error) {
+	list, ok := v.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("watchman: config: %s must be an array of strings", k)
+	}
+	res := make([]string, len(list))
+	for i, x := range list {
+		if res[i], ok = x.(string); !ok {
+			return nil, fmt.Errorf("watchman: config: %s must be an array of strings", k)
+		}
+	}
+	return res, nil
+}
+
+func configBool(k string, v interface{}) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("watchman: config: %s must be a boolean", k)
+	}
+	return b, nil
+}
+
+func configNumber(k string, v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int64:
+		return float64(n), nil
+	case int32:
+		return float64(n), nil
+	case int16:
+		return float64(n), nil
+	case int8:
+		return float64(n), nil
+	}
+	return 0, fmt.Errorf("watchman: config: %s must be a number", k)
+}
+
+func configInt(k string, v interface{}) (int, error) {
+	n, err := configNumber(k, v)
+	return int(n), err
+}
+
+func configDuration(k string, v interface{}, unit time.Duration) (time.Duration, error) {
+	n, err := configNumber(k, v)
+	return time.Duration(n * float64(unit)), err
+}
+
+// ReadConfig reads the .watchmanconfig file of a directory. If the
+// file does not exist, the error satisfies errors.Is(err,
+// fs.ErrNotExist).
+func ReadConfig(dir string) (*Config, error) {
+	b, err := os.ReadFile(filepath.Join(dir, ConfigFile))
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err = json.Unmarshal(b, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// WriteConfig writes the .watchmanconfig file of a directory, replacing
+// any existing file. The Watchman server only reads it when the
+// directory starts being watched.
+func WriteConfig(dir string, cfg *Config) error {
+	b, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, ConfigFile), append(b, '\n'), 0o644)
+}
+
+// Config returns the configuration of the watched root, as read by the
+// Watchman server from its .watchmanconfig file.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/get-config.html
+func (w *Watch) Config(ctx context.Context) (*Config, error) {
+	req := &protocol.GetConfigRequest{Root: w.root}
+	pdu, err := w.client.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return newConfig(protocol.NewGetConfigResponse(pdu).Config())
+}
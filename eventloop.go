@@ -1,27 +1,82 @@
 package watchman
 
 import (
-	"runtime"
+	"context"
+	"errors"
 
 	"github.com/cdmistman/watchman/protocol"
 )
 
-type eventloop struct {
-	requests  chan<- protocol.Request
-	responses <-chan result
-	updates   <-chan interface{}
-}
+// errUnexpectedResponse is reported when the Watchman server sends a
+// response while no request is pending. The connection can no longer
+// be trusted to pair requests with responses, so it is dropped.
+var errUnexpectedResponse = errors.New("watchman: response without a pending request")
 
 type result struct {
 	err *protocol.WatchmanError
 	pdu protocol.ResponsePDU
+	// lost is set when the connection can no longer be used
+	lost error
+}
+
+// A call pairs a request with a future that receives its response.
+//
+// The event loop always completes a call it has sent, even if the
+// caller stopped waiting, so that abandoned calls cannot desynchronize
+// later requests from their responses. The results channel is buffered
+// so completion never blocks, and is closed without a result if the
+// connection is lost.
+type call struct {
+	req     protocol.Request
+	results chan result
+	// onResponse, if set, is run by the event loop when a successful
+	// response arrives, before any later PDU is dispatched.
+	onResponse func(protocol.ResponsePDU)
+}
+
+func newCall(req protocol.Request) *call {
+	return &call{
+		req:     req,
+		results: make(chan result, 1),
+	}
+}
+
+// complete delivers the response to the call.
+func (c *call) complete(result result) {
+	if result.err == nil && c.onResponse != nil {
+		c.onResponse(result.pdu)
+	}
+	c.results <- result
+}
+
+// abandon completes the call without a response.
+func (c *call) abandon() {
+	close(c.results)
 }
 
-func reader(conn *protocol.Connection) <-chan result {
+// wait blocks until the call completes or ctx is done, and returns the
+// response. It returns ErrClosed if the call was abandoned.
+func (c *call) wait(ctx context.Context) (protocol.ResponsePDU, error) {
+	var result result
+	var ok bool
+	select {
+	case result, ok = <-c.results:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if !ok {
+		return nil, ErrClosed
+	}
+
+	if result.err != nil {
+		return nil, result.err
+	}
+	return result.pdu, nil
+}
+
+func reader(conn *protocol.Connection, done <-chan struct{}) <-chan result {
 	ch := make(chan result)
 	go func() {
-		defer close(ch)
-
 		for {
 			pdu, err := conn.Recv()
 			result := result{}
@@ -30,102 +85,118 @@ func reader(conn *protocol.Connection) <-chan result {
 			} else if e, ok := err.(*protocol.WatchmanError); ok {
 				result.err = e
 			} else {
+				result.lost = err
+			}
+
+			select {
+			case ch <- result:
+			case <-done:
+				return
+			}
+			if result.lost != nil {
 				return
 			}
-			ch <- result
 		}
 	}()
 	return ch
 }
 
-func startEventLoop(conn *protocol.Connection) (l *eventloop, stop func(bool)) {
-	/* SHUTDOWN
-	requests:    closed by caller/stop()
-	responses:   closed locally
-	updates:     closed locally
-	*/
-
-	recv := reader(conn)
-	requests := make(chan protocol.Request)
-	responses := make(chan result)
-	updates := make(chan interface{})
-	l = &eventloop{
-		requests:  requests,
-		responses: responses,
-		updates:   updates,
-	}
+// runEventLoop sends requests over conn and delivers their responses
+// until ctx is done or the connection is lost. Unilateral PDUs
+// are passed to dispatch as they arrive.
+//
+// Requests are pipelined: each is sent as soon as it is received, and
+// queued until its response arrives, so concurrent callers do not wait
+// for each other's responses. Watchman answers the requests of a
+// connection in the order it receives them, so each response completes
+// the oldest pending call. This gives the following ordering guarantees:
+//
+//   - requests are sent in the order they are received from requests;
+//   - calls complete in the order their requests were sent, and the
+//     onResponse hook of a call runs before any later PDU is dispatched;
+//   - unilateral PDUs are dispatched in the order they arrive,
+//     interleaved with the completion of calls exactly as the server
+//     sent them.
+//
+// runEventLoop closes conn before returning, and abandons every pending
+// call. It returns nil if ctx is done, or the error that caused the
+// connection to be lost. Sending a request is interrupted when ctx is
+// done, so a stalled server cannot keep runEventLoop from returning.
+func runEventLoop(
+	ctx context.Context,
+	conn *protocol.Connection,
+	requests <-chan *call,
+	dispatch func(protocol.ResponsePDU),
+) error {
+	stop := make(chan struct{})
+	recv := reader(conn, stop)
 
-	expectRequest := func() (ok bool) {
-		for {
-			select {
-			case req, ok := <-requests:
-				if ok {
-					err := conn.Send(req)
-					ok = err == nil
-				}
-				return ok
-			case result, ok := <-recv:
-				if ok {
-					updates <- translateUnilateralPDU(result.pdu)
-				} else {
-					return false
+	// pending holds the calls awaiting a response, oldest first
+	var pending []*call
+	defer func() {
+		close(stop)
+		conn.Close()
+		for _, c := range pending {
+			c.abandon()
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case c := <-requests:
+			if err := conn.SendContext(ctx, c.req); err != nil {
+				c.abandon()
+				if ctx.Err() != nil {
+					return nil
 				}
+				return err
 			}
-		}
-	}
+			pending = append(pending, c)
 
-	expectResponse := func() (ok bool) {
-		for result := range recv {
+		case result := <-recv:
+			if result.lost != nil {
+				return result.lost
+			}
 			if result.err == nil && result.pdu.IsUnilateral() {
-				updates <- translateUnilateralPDU(result.pdu)
-			} else {
-				responses <- result
-				return true
+				dispatch(result.pdu)
+				continue
+			}
+			if len(pending) == 0 {
+				return errUnexpectedResponse
 			}
+			c := pending[0]
+			pending[0] = nil
+			pending = pending[1:]
+			c.complete(result)
 		}
-		return false
 	}
+}
 
-	// Close and empty channels so that other goroutines can shutdown.
-	// IMPORTANT: delayClose should normally be false. It is useful in
-	// tests that would be invalidated by closing `requests` too early.
-	stop = func(delayClose bool) {
-		if !delayClose {
-			close(requests)
-		}
-		for range responses {
-			continue
-		}
-		for range updates {
-			continue
-		}
-		if delayClose {
-			close(requests)
-		}
-		// allow other goroutines to run their shutdown logic;
-		// avoid false positives in tests to detect leaks
-		runtime.Gosched()
+// roundTrip sends a single request directly over conn, outside of the
+// event loop, dispatching any unilateral PDUs received before the
+// response. It fails with ctx.Err() if ctx is done first.
+func roundTrip(
+	ctx context.Context,
+	conn *protocol.Connection,
+	req protocol.Request,
+	dispatch func(protocol.ResponsePDU),
+) (protocol.ResponsePDU, error) {
+	if err := conn.SendContext(ctx, req); err != nil {
+		return nil, err
 	}
-
-	go func() {
-		for {
-			if ok := expectRequest(); !ok {
-				break
-			}
-			if ok := expectResponse(); !ok {
-				break
-			}
+	for {
+		pdu, err := conn.RecvContext(ctx)
+		if err != nil {
+			return nil, err
 		}
-
-		conn.Close()
-		close(responses)
-		close(updates)
-		for range requests {
-			continue
+		if !pdu.IsUnilateral() {
+			return pdu, nil
 		}
-	}()
-
-	return
+		dispatch(pdu)
+	}
 }
 
 func translateUnilateralPDU(pdu protocol.ResponsePDU) interface{} {
@@ -133,5 +204,9 @@ func translateUnilateralPDU(pdu protocol.ResponsePDU) interface{} {
 		sub := protocol.NewSubscription(pdu)
 		return newChangeNotification(sub)
 	}
+	if protocol.IsLogMessage(pdu) {
+		m := protocol.NewLogMessage(pdu)
+		return &LogEntry{Level: m.Level(), Message: m.Message()}
+	}
 	return pdu
 }
@@ -0,0 +1,44 @@
+package watchman
+
+import (
+	"context"
+
+	"github.com/cdmistman/watchman/protocol"
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+// Find returns the existing files under the watched root whose path
+// matches one of the wildmatch patterns, or every file if there are no
+// patterns. The files are looked up in the view of the Watchman server,
+// without walking the filesystem. If the Watch has a relative path,
+// patterns and names are relative to it.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/find.html
+func (w *Watch) Find(ctx context.Context, patterns ...string) (*QueryResult, error) {
+	if w.rel != "" {
+		// the find command cannot select a relative root, but a query
+		// with the equivalent expression can
+		q := &query.Query{}
+		if len(patterns) > 0 {
+			terms := make(query.TAnyof, 0, len(patterns))
+			for _, pattern := range patterns {
+				terms = append(terms, query.TMatch{Glob: pattern, MatchType: query.MatchWholeName})
+			}
+			q.Expression = terms
+		}
+		return w.Query(ctx, q)
+	}
+
+	req := &protocol.FindRequest{Root: w.root, Patterns: patterns}
+	pdu, err := w.client.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := protocol.NewFindResponse(pdu)
+	return &QueryResult{
+		Clock:   res.Clock(),
+		Files:   res.Files(),
+		Warning: res.Warning(),
+	}, nil
+}
@@ -0,0 +1,74 @@
+package watchman
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cdmistman/watchman/protocol"
+)
+
+// A FlushResult reports the outcome of Watch.FlushSubscriptions for each
+// flushed subscription, by name.
+type FlushResult struct {
+	// Synced lists the subscriptions whose pending changes were
+	// notified.
+	Synced []string
+	// NoSyncNeeded lists the subscriptions that had no pending changes.
+	NoSyncNeeded []string
+	// Dropped lists the subscriptions whose pending changes were
+	// dropped, because of a state asserted with SubscribeOptions.Drop.
+	Dropped []string
+}
+
+// FlushSubscriptions waits up to syncTimeout for the Watchman server to
+// observe the changes made so far to the watched root, and then
+// notifies the subscriptions of this Client with the changes they have
+// not been notified of yet. If names are given, only the subscriptions
+// with these names are flushed.
+//
+// When FlushSubscriptions returns, the flushed notifications have been
+// queued by Subscription.Changes, ahead of any later notification, so
+// it is a barrier for tests and tools that make changes and expect to
+// observe them.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/flush-subscriptions.html
+func (w *Watch) FlushSubscriptions(ctx context.Context, syncTimeout time.Duration, names ...string) (*FlushResult, error) {
+	if !w.client.HasCapability("cmd-flush-subscriptions") {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupported, "cmd-flush-subscriptions")
+	}
+
+	// the server only flushes the subscriptions of the connection the
+	// request is sent over, so the request is split by lane
+	var lanes []*lane
+	byLane := map[*lane][]string{}
+	if len(names) == 0 {
+		lanes = w.client.lanes()
+	} else {
+		for _, name := range names {
+			l := w.client.subscriptionLane(subscriptionKey{root: w.root, name: name})
+			if _, ok := byLane[l]; !ok {
+				lanes = append(lanes, l)
+			}
+			byLane[l] = append(byLane[l], name)
+		}
+	}
+
+	result := &FlushResult{}
+	for _, l := range lanes {
+		req := &protocol.FlushSubscriptionsRequest{
+			Root:          w.root,
+			SyncTimeout:   int(syncTimeout / time.Millisecond),
+			Subscriptions: byLane[l],
+		}
+		pdu, err := l.send(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		res := protocol.NewFlushSubscriptionsResponse(pdu)
+		result.Synced = append(result.Synced, res.Synced()...)
+		result.NoSyncNeeded = append(result.NoSyncNeeded, res.NoSyncNeeded()...)
+		result.Dropped = append(result.Dropped, res.Dropped()...)
+	}
+	return result, nil
+}
@@ -0,0 +1,130 @@
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+// ParseGitignore parses the patterns of a .gitignore file in dir, a
+// directory relative to the root using "/" as the separator, or "" for
+// the root.
+//
+// See also: https://git-scm.com/docs/gitignore#_pattern_format
+func ParseGitignore(r io.Reader, dir string) ([]Pattern, error) {
+	dir = strings.Trim(dir, "/")
+	var patterns []Pattern
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := trimSpaces(strings.TrimSuffix(scanner.Text(), "\r"))
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		p := Pattern{Dir: dir}
+		if line[0] == '!' {
+			p.Negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.DirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		// a slash at the beginning or in the middle anchors the pattern
+		if strings.Contains(line, "/") {
+			p.Anchored = true
+			line = strings.TrimLeft(line, "/")
+		}
+		p.Glob = line
+		patterns = append(patterns, p)
+	}
+	return patterns, scanner.Err()
+}
+
+// trimSpaces removes the trailing spaces of a line that are not escaped
+// with a backslash.
+func trimSpaces(line string) string {
+	end := len(line)
+	for end > 0 && line[end-1] == ' ' {
+		// count the backslashes before the space
+		n := 0
+		for i := end - 2; i >= 0 && line[i] == '\\'; i-- {
+			n++
+		}
+		if n%2 == 1 {
+			break
+		}
+		end--
+	}
+	return line[:end]
+}
+
+// ReadGitignore reads the patterns of the .gitignore files in the
+// working tree at root and of its .git/info/exclude file, in increasing
+// order of precedence. It does not descend into the .git directory or
+// into ignored directories, and does not read the file configured by
+// core.excludesFile.
+func ReadGitignore(root string) ([]Pattern, error) {
+	patterns, err := readGitignore(filepath.Join(root, ".git", "info", "exclude"), "")
+	if err != nil {
+		return nil, err
+	}
+
+	// WalkDir visits directories before their subdirectories, whose
+	// .gitignore files take precedence
+	ignored := Compile(patterns)
+	err = filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if !d.IsDir() {
+			return nil
+		}
+		if rel == "." {
+			rel = ""
+		} else if d.Name() == ".git" || query.Evaluate(ignored, query.File{Name: rel, Exists: true, Type: query.TFileDir}) {
+			return filepath.SkipDir
+		}
+
+		dir, err := readGitignore(filepath.Join(name, ".gitignore"), rel)
+		if err != nil {
+			return err
+		}
+		if len(dir) > 0 {
+			patterns = append(patterns, dir...)
+			ignored = Compile(patterns)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return patterns, nil
+}
+
+// readGitignore reads the patterns of a .gitignore file, if it exists.
+func readGitignore(name, dir string) ([]Pattern, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseGitignore(f, dir)
+}
@@ -0,0 +1,144 @@
+package ignore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// hgSyntaxes maps the syntaxes of .hgignore files to whether they use
+// globs, and whether the globs are anchored at the root.
+var hgSyntaxes = map[string]struct{ glob, anchored bool }{
+	"glob":     {true, false},
+	"relglob":  {true, false},
+	"rootglob": {true, true},
+	"path":     {true, true},
+	"re":       {false, false},
+	"regexp":   {false, false},
+	"relre":    {false, false},
+}
+
+// ParseHgignore parses the patterns of an .hgignore file at the root.
+// Patterns use regular expression syntax until a "syntax:" line selects
+// another syntax, or if they have a "glob:", "rootglob:", "path:" or
+// "re:" prefix. Glob patterns with "{a,b}" alternatives are expanded to
+// one pattern per alternative.
+//
+// See also: https://www.mercurial-scm.org/doc/hgignore.5.html
+func ParseHgignore(r io.Reader) ([]Pattern, error) {
+	var patterns []Pattern
+	syntax := "regexp"
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(hgComment(scanner.Text()), " \t\r")
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "syntax:") {
+			s := strings.TrimSpace(strings.TrimPrefix(line, "syntax:"))
+			if _, ok := hgSyntaxes[s]; !ok {
+				return nil, fmt.Errorf("ignore: line %d: unknown syntax %q", n, s)
+			}
+			syntax = s
+			continue
+		}
+
+		kind := syntax
+		if k, pattern, ok := strings.Cut(line, ":"); ok {
+			if _, ok := hgSyntaxes[k]; ok {
+				kind, line = k, pattern
+			}
+		}
+		if line == "" {
+			continue
+		}
+
+		s := hgSyntaxes[kind]
+		switch {
+		case !s.glob:
+			patterns = append(patterns, Pattern{Regexp: line})
+		case kind == "path":
+			patterns = append(patterns, Pattern{Glob: escape(strings.Trim(line, "/")), Anchored: true})
+		default:
+			for _, glob := range expandBraces(line) {
+				patterns = append(patterns, Pattern{Glob: glob, Anchored: s.anchored})
+			}
+		}
+	}
+	return patterns, scanner.Err()
+}
+
+// hgComment removes a comment, which starts at an unescaped "#", from a
+// line, and unescapes "\#".
+func hgComment(line string) string {
+	if !strings.Contains(line, "#") {
+		return line
+	}
+	var b strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '#':
+			return b.String()
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == '#':
+			i++
+		}
+		b.WriteByte(line[i])
+	}
+	return b.String()
+}
+
+// expandBraces expands the "{a,b}" alternatives of a glob.
+func expandBraces(glob string) []string {
+	start := strings.IndexByte(glob, '{')
+	if start < 0 {
+		return []string{glob}
+	}
+
+	// find the matching brace and the commas at its depth
+	depth, commas := 0, []int{}
+	for i := start; i < len(glob); i++ {
+		switch glob[i] {
+		case '{':
+			depth++
+		case ',':
+			if depth == 1 {
+				commas = append(commas, i)
+			}
+		case '}':
+			if depth--; depth > 0 {
+				continue
+			}
+			var res []string
+			prev := start
+			for _, end := range append(commas, i) {
+				for _, alt := range expandBraces(glob[prev+1:end] + glob[i+1:]) {
+					res = append(res, glob[:start]+alt)
+				}
+				prev = end
+			}
+			return res
+		}
+	}
+	// an unterminated brace is a literal
+	return []string{glob}
+}
+
+// ReadHgignore reads the patterns of the .hgignore file at root, if it
+// exists.
+func ReadHgignore(root string) ([]Pattern, error) {
+	f, err := os.Open(filepath.Join(root, ".hgignore"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHgignore(f)
+}
@@ -0,0 +1,268 @@
+// Package ignore compiles the rules of version control ignore files,
+// such as .gitignore and .hgignore, into Watchman expression terms, so
+// that queries and subscriptions can exclude ignored files on the
+// server.
+//
+// A query for the files that git does not ignore looks like:
+//
+//	patterns, err := ignore.ReadGitignore(root)
+//	if err != nil {
+//		return err
+//	}
+//	q := query.New().
+//		Not(ignore.Compile(patterns)).
+//		Fields(query.FName, query.FExists).
+//		Query()
+//
+// Watchman evaluates the compiled terms against the path of each file
+// relative to the root, so the root must be the top of the repository.
+package ignore
+
+import (
+	"strings"
+
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+// A Pattern is one rule of an ignore file.
+type Pattern struct {
+	// Dir is the directory of the ignore file relative to the root,
+	// using "/" as the separator, or "" for the root.
+	Dir string
+
+	// Glob is a wildmatch pattern, as in .gitignore files, without a
+	// leading "!" or a trailing "/". It matches paths relative to Dir
+	// if Anchored is set, and the basename of paths below Dir at any
+	// depth otherwise.
+	Glob     string
+	Anchored bool
+
+	// Regexp is a regular expression that paths relative to the root
+	// contain, as in .hgignore files. It is used when Glob is empty.
+	Regexp string
+
+	// Negate re-includes the paths matched by the pattern, as a leading
+	// "!" does in .gitignore files.
+	Negate bool
+
+	// DirOnly restricts the pattern to directories, as a trailing "/"
+	// does in .gitignore files.
+	DirOnly bool
+}
+
+// Compile returns an expression term that matches the paths ignored by
+// patterns, following the rules of .gitignore files: the last pattern
+// matching a path decides whether it is ignored, and everything below an
+// ignored directory is ignored. As in git, a negated pattern cannot
+// re-include a path below an ignored directory. This is exact when the
+// parent directory of the negated pattern is a literal path, as in
+// "!build/keep"; otherwise, such as for "!keep", the paths two or more
+// levels below an ignored directory stay ignored, even if the directory
+// matched by the negated pattern is the ignored one. Unlike git, a Regexp
+// pattern does not ignore the paths below the directories it matches.
+//
+// Globs compile to TMatch terms with MatchWholeName and
+// MatchIncludeDotFiles, and regular expressions to TPCRE terms with
+// MatchWholeName. If no pattern ignores anything, Compile returns
+// query.FalseT.
+func Compile(patterns []Pattern) query.Term {
+	var self, below query.Term
+	for _, p := range patterns {
+		if !p.Negate {
+			self = fold(self, p.self(), false)
+			if t := p.below(); t != nil {
+				below = fold(below, t, false)
+			}
+			continue
+		}
+		if self == nil && below == nil {
+			continue
+		}
+
+		t := p.below()
+		if parent, ok := p.parent(); ok {
+			// the parent directory is known, so check whether it is
+			// ignored, in which case the pattern has no effect
+			dir := query.File{Name: parent, Exists: true, Type: query.TFileDir}
+			if parent != "" && query.Evaluate(combine(self, below), dir) {
+				continue
+			}
+		} else if t != nil && below != nil {
+			// only re-include the paths below the matched directories
+			// that are not also below an ignored ancestor
+			t = query.TAllof{t, query.TNot{Not: deeper(below)}}
+		}
+		self = fold(self, p.self(), true)
+		if t != nil {
+			below = fold(below, t, true)
+		}
+	}
+	return combine(self, below)
+}
+
+// combine returns a term matching the paths matched by either self or
+// below, which may be nil.
+func combine(self, below query.Term) query.Term {
+	switch {
+	case self == nil && below == nil:
+		return query.FalseT
+	case below == nil:
+		return self
+	case self == nil:
+		return below
+	}
+	return anyof(self, below)
+}
+
+// deeper rewrites a term built from the below terms of patterns to match
+// the paths at least two levels below the matched directories, rather
+// than one.
+func deeper(t query.Term) query.Term {
+	switch t := t.(type) {
+	case query.TMatch:
+		t.Glob = strings.TrimSuffix(t.Glob, "/**") + "/*/**"
+		return t
+	case query.TNot:
+		return query.TNot{Not: deeper(t.Not)}
+	case query.TAllof:
+		res := make(query.TAllof, len(t))
+		for i, t := range t {
+			res[i] = deeper(t)
+		}
+		return res
+	case query.TAnyof:
+		res := make(query.TAnyof, len(t))
+		for i, t := range t {
+			res[i] = deeper(t)
+		}
+		return res
+	}
+	return t
+}
+
+// fold combines the term matching the paths ignored by the previous
+// patterns, nil if none, with the term of the next pattern.
+func fold(ignored, t query.Term, negate bool) query.Term {
+	switch {
+	case negate && ignored == nil:
+		return nil
+	case negate:
+		if all, ok := ignored.(query.TAllof); ok {
+			return append(all[:len(all):len(all)], query.TNot{Not: t})
+		}
+		return query.TAllof{ignored, query.TNot{Not: t}}
+	case ignored == nil:
+		return t
+	}
+	return anyof(ignored, t)
+}
+
+// anyof returns a TAnyof of a and b, flattening TAnyof operands.
+func anyof(a, b query.Term) query.TAnyof {
+	var res query.TAnyof
+	for _, t := range []query.Term{a, b} {
+		if any, ok := t.(query.TAnyof); ok {
+			res = append(res, any...)
+		} else {
+			res = append(res, t)
+		}
+	}
+	return res
+}
+
+// wholename returns the wildmatch pattern for paths relative to the
+// root matched by p.
+func (p Pattern) wholename() string {
+	dir := escape(p.Dir)
+	switch {
+	case p.Anchored && dir == "":
+		return p.Glob
+	case p.Anchored:
+		return dir + "/" + p.Glob
+	case dir == "":
+		return "**/" + p.Glob
+	}
+	return dir + "/**/" + p.Glob
+}
+
+// parent returns the path of the parent directory of the paths matched
+// by p relative to the root, or false if it is not a literal path.
+func (p Pattern) parent() (string, bool) {
+	if p.Glob == "" || !p.Anchored {
+		return "", false
+	}
+	glob := p.wholename()
+	i := strings.LastIndexByte(glob, '/')
+	if i < 0 {
+		return "", true
+	}
+	return unescape(glob[:i])
+}
+
+// self returns a term matching the paths matched by p.
+func (p Pattern) self() query.Term {
+	if p.Glob == "" {
+		return query.TPCRE{Regexp: p.Regexp, MatchType: query.MatchWholeName}
+	}
+	t := query.Term(match(p.wholename()))
+	if p.DirOnly {
+		t = query.TAllof{t, query.TFileDir}
+	}
+	return t
+}
+
+// below returns a term matching the paths below the directories matched
+// by p, or nil if there is none.
+func (p Pattern) below() query.Term {
+	if p.Glob == "" {
+		return nil
+	}
+	glob := p.wholename()
+	if glob == "**" || strings.HasSuffix(glob, "/**") {
+		// a trailing "**" would also match the empty name, as in "a/"
+		// for "a/**", so require one more path component
+		return match(glob[:len(glob)-2] + "*/**")
+	}
+	return match(glob + "/**")
+}
+
+func match(glob string) query.TMatch {
+	return query.TMatch{
+		Glob:      glob,
+		MatchType: query.MatchWholeName,
+		Flags:     query.MatchIncludeDotFiles,
+	}
+}
+
+// escape escapes the wildmatch special characters of a path.
+func escape(path string) string {
+	if !strings.ContainsAny(path, `*?[\`) {
+		return path
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if strings.IndexByte(`*?[\`, path[i]) >= 0 {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// unescape returns the path matched by a glob without wildcards, or
+// false if the glob has wildcards.
+func unescape(glob string) (string, bool) {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch glob[i] {
+		case '*', '?', '[':
+			return "", false
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+		}
+		b.WriteByte(glob[i])
+	}
+	return b.String(), true
+}
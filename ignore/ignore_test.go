@@ -0,0 +1,230 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+// ignored reports the paths of names ignored by patterns, according to
+// query.Evaluate. Names ending with "/" are directories.
+func ignored(patterns []Pattern, names []string) []string {
+	t := Compile(patterns)
+	res := []string{}
+	for _, name := range names {
+		f := query.File{Name: strings.TrimSuffix(name, "/"), Exists: true, Type: query.TFileRegular}
+		if strings.HasSuffix(name, "/") {
+			f.Type = query.TFileDir
+		}
+		if query.Evaluate(t, f) {
+			res = append(res, name)
+		}
+	}
+	return res
+}
+
+func TestGitignore(t *testing.T) {
+	require := require.New(t)
+
+	root, err := ParseGitignore(strings.NewReader(strings.Join([]string{
+		"# build outputs",
+		"*.log",
+		"!important.log",
+		"build/",
+		"/vendor",
+		"docs/**/*.pdf",
+		"node_modules/",
+		`\#hash`,
+		`trailing\ `,
+		"/*.tmp  ",
+		"lib/**",
+		"!lib/keep.go",
+		"",
+	}, "\n")), "")
+	require.NoError(err)
+	require.Equal([]Pattern{
+		{Glob: "*.log"},
+		{Glob: "important.log", Negate: true},
+		{Glob: "build", DirOnly: true},
+		{Glob: "vendor", Anchored: true},
+		{Glob: "docs/**/*.pdf", Anchored: true},
+		{Glob: "node_modules", DirOnly: true},
+		{Glob: `\#hash`},
+		{Glob: `trailing\ `},
+		{Glob: "*.tmp", Anchored: true},
+		{Glob: "lib/**", Anchored: true},
+		{Glob: "lib/keep.go", Anchored: true, Negate: true},
+	}, root)
+
+	sub, err := ParseGitignore(strings.NewReader("*.go\r\n!main.go\r\n/local\r\n"), "sub/")
+	require.NoError(err)
+	require.Equal([]Pattern{
+		{Dir: "sub", Glob: "*.go"},
+		{Dir: "sub", Glob: "main.go", Negate: true},
+		{Dir: "sub", Glob: "local", Anchored: true},
+	}, sub)
+
+	// the results of git check-ignore for the same files
+	names := []string{
+		"a.log", "important.log", "dir/x.log", "dir/important.log", ".hidden.log",
+		"build/", "src/build/", "build/out.o", "src/build/x", "other/build",
+		"vendor/", "vendor/a.go", "src/vendor/",
+		"docs/a.pdf", "docs/x/y/a.pdf", "a.pdf",
+		"node_modules/", "node_modules/.bin/x",
+		"#hash", "trailing ", "x.tmp", "src/x.tmp",
+		"lib/", "lib/a.go", "lib/keep.go", "lib/sub/", "lib/sub/b.go", "lib/sub/keep.go",
+		"sub/a.go", "sub/main.go", "sub/deep/a.go", "sub/local", "sub/deep/local", "a.go",
+	}
+	require.Equal([]string{
+		"a.log", "dir/x.log", ".hidden.log",
+		"build/", "src/build/", "build/out.o", "src/build/x",
+		"vendor/", "vendor/a.go",
+		"docs/a.pdf", "docs/x/y/a.pdf",
+		"node_modules/", "node_modules/.bin/x",
+		"#hash", "trailing ", "x.tmp",
+		"lib/a.go", "lib/sub/", "lib/sub/b.go", "lib/sub/keep.go",
+		"sub/a.go", "sub/deep/a.go", "sub/local",
+	}, ignored(append(root, sub...), names))
+
+	// negated patterns cannot re-include a path below an ignored
+	// directory, with or without a trailing "/"
+	negated, err := ParseGitignore(strings.NewReader("build/\n!build/keep.txt\nout\n!out/keep.txt\n"), "")
+	require.NoError(err)
+	require.Equal([]string{
+		"build/", "build/keep.txt", "build/x", "out/", "out/keep.txt", "out/x",
+	}, ignored(negated, []string{
+		"build/", "build/keep.txt", "build/x", "out/", "out/keep.txt", "out/x", "keep.txt",
+	}))
+
+	// nor can they re-include the paths below a directory they match, if
+	// its parent is ignored
+	negated, err = ParseGitignore(strings.NewReader("build/\n!build/keep\n*.o\n!keep.o\n"), "")
+	require.NoError(err)
+	require.Equal([]string{
+		"build/keep/", "build/keep/x", "a.o/keep.o/x",
+	}, ignored(negated, []string{
+		"build/keep/", "build/keep/x", "a.o/keep.o/x", "keep.o/", "keep.o/x", "src/keep.o/x",
+	}))
+}
+
+func TestCompile(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(query.FalseT, Compile(nil))
+	require.Equal(query.FalseT, Compile([]Pattern{{Glob: "a", Negate: true}}))
+
+	m := func(glob string) query.TMatch {
+		return query.TMatch{Glob: glob, MatchType: query.MatchWholeName, Flags: query.MatchIncludeDotFiles}
+	}
+	require.Equal(query.TAnyof{
+		m("**/*.o"),
+		query.TAllof{m("src/out"), query.TFileDir},
+		m("**/*.o/**"),
+		m("src/out/**"),
+	}, Compile([]Pattern{
+		{Glob: "*.o"},
+		{Dir: "src", Glob: "out", Anchored: true, DirOnly: true},
+	}))
+
+	require.Equal(query.TAnyof{
+		query.TAllof{m("**/*.o"), query.TNot{Not: m("**/keep.o")}},
+		query.TAllof{m("**/*.o/**"), query.TNot{Not: query.TAllof{m("**/keep.o/**"), query.TNot{Not: m("**/*.o/*/**")}}}},
+	}, Compile([]Pattern{{Glob: "*.o"}, {Glob: "keep.o", Negate: true}}))
+
+	// the directory of the ignore file is a literal path
+	require.Equal(m(`a\*\[b]/**/x`), Compile([]Pattern{{Dir: "a*[b]", Glob: "x"}}).(query.TAnyof)[0])
+	require.Equal([]string{"a*[b]/c/x"}, ignored([]Pattern{{Dir: "a*[b]", Glob: "x"}}, []string{"ab]/x", "a*[b]/c/x"}))
+
+	// the compiled terms are valid queries
+	q := query.New().Not(Compile([]Pattern{{Glob: "*.o"}, {Regexp: `\.pyc$`}})).Query()
+	require.NoError(q.Validate(nil))
+}
+
+func TestHgignore(t *testing.T) {
+	require := require.New(t)
+
+	patterns, err := ParseHgignore(strings.NewReader(strings.Join([]string{
+		"# regular expressions by default",
+		`\.pyc$`,
+		"glob:*.o # object files",
+		"syntax: glob",
+		"*.{c,h}~",
+		"build/*.tmp",
+		`a\#b`,
+		"rootglob:dist",
+		"path:out/",
+		"re:^tmp/",
+	}, "\n")))
+	require.NoError(err)
+	require.Equal([]Pattern{
+		{Regexp: `\.pyc$`},
+		{Glob: "*.o"},
+		{Glob: "*.c~"},
+		{Glob: "*.h~"},
+		{Glob: "build/*.tmp"},
+		{Glob: "a#b"},
+		{Glob: "dist", Anchored: true},
+		{Glob: "out", Anchored: true},
+		{Regexp: "^tmp/"},
+	}, patterns)
+
+	require.Equal([]string{
+		"x.pyc", "lib/x.o", "x.c~", "src/build/a.tmp", "a#b", "dist/", "dist/x", "out/x", "tmp/x",
+	}, ignored(patterns, []string{
+		"x.pyc", "x.py", "lib/x.o", "x.c~", "x.go~", "src/build/a.tmp", "build/x/a.tmp",
+		"a#b", "dist/", "dist/x", "src/dist", "out/x", "tmp/x", "src/tmp/x",
+	}))
+
+	_, err = ParseHgignore(strings.NewReader("syntax: glob\nsyntax: spoon\n"))
+	require.EqualError(err, `ignore: line 2: unknown syntax "spoon"`)
+
+	require.Equal([]string{"a{b", "x1y", "x2y", "xa", "xbc", "xbd"}, append(
+		expandBraces("a{b"),
+		append(expandBraces("x{1,2}y"), expandBraces("x{a,b{c,d}}")...)...,
+	))
+}
+
+func TestReadIgnoreFiles(t *testing.T) {
+	require := require.New(t)
+
+	root := t.TempDir()
+	write := func(name, content string) {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(os.MkdirAll(filepath.Dir(name), 0o755))
+		require.NoError(os.WriteFile(name, []byte(content), 0o644))
+	}
+
+	patterns, err := ReadGitignore(root)
+	require.NoError(err)
+	require.Empty(patterns)
+	patterns, err = ReadHgignore(root)
+	require.NoError(err)
+	require.Empty(patterns)
+
+	write(".git/info/exclude", "*.swp\n")
+	write(".git/.gitignore", "never read\n")
+	write(".gitignore", "out/\n")
+	write("src/.gitignore", "!*.swp\n")
+	write("src/lib/.gitignore", "/gen\n")
+	// ignored directories are not searched
+	write("out/.gitignore", "never read\n")
+	write(".hgignore", "syntax: glob\n*.orig\n")
+
+	patterns, err = ReadGitignore(root)
+	require.NoError(err)
+	require.Equal([]Pattern{
+		{Glob: "*.swp"},
+		{Glob: "out", DirOnly: true},
+		{Dir: "src", Glob: "*.swp", Negate: true},
+		{Dir: "src/lib", Glob: "gen", Anchored: true},
+	}, patterns)
+
+	patterns, err = ReadHgignore(root)
+	require.NoError(err)
+	require.Equal([]Pattern{{Glob: "*.orig"}}, patterns)
+}
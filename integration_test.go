@@ -1,6 +1,7 @@
 package watchman_test
 
 import (
+	"context"
 	"io"
 	"os"
 	"path/filepath"
@@ -13,13 +14,14 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"github.com/cdmistman/watchman"
+	"github.com/cdmistman/watchman/protocol"
 	"github.com/cdmistman/watchman/protocol/query"
 )
 
 const pause = 250 * time.Millisecond
 
-func collect(updates <-chan interface{}) []interface{} {
-	messages := make([]interface{}, 0, 3)
+func collect[T any](updates <-chan T) []T {
+	messages := make([]T, 0, 3)
 	var wg sync.WaitGroup
 
 	wg.Add(1)
@@ -49,8 +51,7 @@ func tmpdir(t *testing.T) (string, error) {
 		return "", err
 	}
 
-	path := filepath.Join(dir, ".watchmanconfig")
-	err = os.WriteFile(path, []byte(`{"idle_reap_age_seconds": 300}`+"\n"), os.ModePerm)
+	err = watchman.WriteConfig(dir, &watchman.Config{IdleReapAge: 300 * time.Second})
 	return dir, err
 }
 
@@ -115,6 +116,8 @@ func TestClient(t *testing.T) {
 	require.NoError(err)
 	defer os.RemoveAll(dir)
 
+	ctx := context.Background()
+
 	// connect
 	c, err := watchman.Connect()
 	require.NoError(err)
@@ -147,8 +150,11 @@ func TestClient(t *testing.T) {
 
 	require.NoError(err)
 
-	n = len(collect(updates))
+	changes := s.Changes()
+	n = len(collect(changes))
 	require.NotEqual(0, n)
+	n = len(collect(updates))
+	require.Equal(0, n)
 
 	// clock
 	clock1, err := watch.Clock(0)
@@ -161,7 +167,11 @@ func TestClient(t *testing.T) {
 	err = touch(dir, "foo", "bar", "baz")
 	require.NoError(err)
 
-	n = len(collect(updates))
+	// flush-subscriptions
+	flushed, err := watch.FlushSubscriptions(ctx, 10*time.Second)
+	require.NoError(err)
+	require.Empty(flushed.Dropped)
+	n = len(collect(changes))
 	require.NotEqual(0, n)
 
 	clock2, err := watch.Clock(pause)
@@ -169,6 +179,34 @@ func TestClient(t *testing.T) {
 	require.NotEmpty(clock2)
 	require.NotEqual(clock1, clock2)
 
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = watch.ClockContext(canceled, 0)
+	require.ErrorIs(err, context.Canceled)
+
+	// query
+	result, err := watch.Query(context.Background(), &query.Query{
+		Expression: query.TFileType("f"),
+		Fields:     query.Fields{query.FName},
+	})
+	require.NoError(err)
+	require.NotEmpty(result.Clock)
+	require.True(result.IsFreshInstance)
+	require.NotEmpty(result.Files)
+
+	// since
+	result, err = watch.Since(context.Background(), clock1)
+	require.NoError(err)
+	require.NotEmpty(result.Clock)
+	require.False(result.IsFreshInstance)
+	require.NotEmpty(result.Files)
+
+	// find
+	result, err = watch.Find(context.Background(), "ba*")
+	require.NoError(err)
+	require.NotEmpty(result.Clock)
+	require.Len(result.Files, 2)
+
 	// state changes
 	err = touch(dir, "baz", "qux", "quux")
 	require.NoError(err)
@@ -185,111 +223,48 @@ func TestClient(t *testing.T) {
 		require.NoError(err)
 	}
 
-	messages := collect(updates)
-	for _, msg := range messages {
-		cn, ok := msg.(*watchman.ChangeNotification)
-		if !ok || cn.IsFreshInstance {
+	_, err = watch.FlushSubscriptions(ctx, 10*time.Second, "Spoon!")
+	require.NoError(err)
+	messages := collect(changes)
+	for _, cn := range messages {
+		if cn.IsFreshInstance {
 			continue
 		}
 
-		for _, f := range cn.Files {
-			file, ok := f.(map[string]interface{})
-			require.True(ok)
-			n, ok := file["name"]
-			require.True(ok)
-			name, ok := n.(string)
-			require.True(ok)
-			switch name {
+		for _, file := range cn.Files {
+			switch file.Name {
 			case "foo", "bar":
-				tyVal, ok := file["type"]
-				require.True(ok)
-				ty, ok := tyVal.(string)
-				require.True(ok)
-				require.Equal("f", ty)
-
-				// changeVal, ok := file["change"]
-				// require.True(ok)
-				// change, ok := changeVal.(watchman.StateChange)
-				// require.True(ok)
-				// require.Equal(watchman.Removed, change)
+				require.Equal("f", file.Type)
+				// require.Equal(watchman.Removed, file.Change)
 
 			case "baz":
-				tyVal, ok := file["type"]
-				require.True(ok)
-				ty, ok := tyVal.(string)
-				require.True(ok)
-				require.Equal("f", ty)
-
-				// changeVal, ok := file["change"]
-				// require.True(ok)
-				// change, ok := changeVal.(watchman.StateChange)
-				// require.True(ok)
-				// require.Equal(watchman.Updated, change)
+				require.Equal("f", file.Type)
+				// require.Equal(watchman.Updated, file.Change)
 
 			case "qux":
-				tyVal, ok := file["type"]
-				require.True(ok)
-				ty, ok := tyVal.(string)
-				require.True(ok)
-				require.Equal("f", ty)
-
-				// changeVal, ok := file["change"]
-				// require.True(ok)
-				// change, ok := changeVal.([]watchman.StateChange)
-				// require.True(ok)
-				// // require.Equal(watchman.Created, file.Change)
+				require.Equal("f", file.Type)
 				// require.Contains(
 				// 	[]watchman.StateChange{watchman.Created, watchman.Updated},
-				// 	change,
+				// 	file.Change,
 				// )
 
 			case "quux":
-				// require.Equal("?", file.Type)
-				// require.Equal(watchman.Ephemeral, file.Change)
-				tyVal, ok := file["type"]
-				require.True(ok)
-				ty, ok := tyVal.(string)
-				require.True(ok)
-				require.Contains("f?", ty)
-
-				// changeVal, ok := file["change"]
-				// require.True(ok)
-				// change, ok := changeVal.([]watchman.StateChange)
-				// require.True(ok)
+				require.Contains("f?", file.Type)
 				// require.Contains(
 				// 	[]watchman.StateChange{watchman.Ephemeral, watchman.Removed},
-				// 	change,
+				// 	file.Change,
 				// )
 
 			case "corge", "grault":
-				tyVal, ok := file["type"]
-				require.True(ok)
-				ty, ok := tyVal.(string)
-				require.True(ok)
-				require.Equal("d", ty)
-
-				// changeVal, ok := file["change"]
-				// require.True(ok)
-				// change, ok := changeVal.([]watchman.StateChange)
-				// require.True(ok)
-				// // require.Equal(watchman.Created, file.Change)
+				require.Equal("d", file.Type)
 				// require.Contains(
 				// 	[]watchman.StateChange{watchman.Created, watchman.Updated},
-				// 	change,
+				// 	file.Change,
 				// )
 
 			case "garply":
-				tyVal, ok := file["type"]
-				require.True(ok)
-				ty, ok := tyVal.(string)
-				require.True(ok)
-				require.Equal("l", ty)
-
-				// changeVal, ok := file["change"]
-				// require.True(ok)
-				// change, ok := changeVal.([]watchman.StateChange)
-				// require.True(ok)
-				// require.Equal(watchman.Created, change)
+				require.Equal("l", file.Type)
+				// require.Equal(watchman.Created, file.Change)
 			}
 		}
 	}
@@ -297,6 +272,55 @@ func TestClient(t *testing.T) {
 	// unsubscribe
 	err = s.Unsubscribe()
 	require.NoError(err)
+	<-s.Done()
+	require.ErrorIs(s.Err(), watchman.ErrUnsubscribed)
+
+	// trigger
+	err = watch.AddTrigger(ctx, &watchman.TriggerSpec{
+		Name:       "Spoon!",
+		Command:    []string{"true"},
+		Expression: query.TSuffix{"go"},
+	})
+	require.NoError(err)
+
+	triggers, err := watch.ListTriggers(ctx)
+	require.NoError(err)
+	require.Len(triggers, 1)
+	require.Equal("Spoon!", triggers[0].Name)
+
+	err = watch.RemoveTrigger(ctx, "Spoon!")
+	require.NoError(err)
+
+	// state-enter and state-leave
+	state, err := watch.EnterState(ctx, "Spoon!", map[string]string{"x": "y"})
+	require.NoError(err)
+	require.NotEmpty(state.Clock())
+	err = state.Leave(nil)
+	require.NoError(err)
+
+	// get-config
+	config, err := watch.Config(ctx)
+	require.NoError(err)
+	require.Equal(300*time.Second, config.IdleReapAge)
+
+	// watch-del
+	err = watch.Remove(ctx)
+	require.NoError(err)
+	roots, err = c.ListWatches()
+	require.NoError(err)
+	require.NotContains(roots, watch.Root())
+
+	// get-pid, version and log
+	pid, err := c.ServerPID(ctx)
+	require.NoError(err)
+	require.NotZero(pid)
+	version, err := c.ServerVersion(ctx, []string{"cmd-subscribe"}, []string{"grant-three-wishes"})
+	require.NoError(err)
+	require.Equal(c.Version(), version.Version)
+	require.True(version.Capabilities["cmd-subscribe"])
+	require.False(version.Capabilities["grant-three-wishes"])
+	err = c.Log(ctx, protocol.LogDebug, "Spoon!")
+	require.NoError(err)
 
 	// close
 	err = c.Close()
@@ -0,0 +1,113 @@
+package watchman
+
+import (
+	"context"
+	"hash/fnv"
+
+	"github.com/cdmistman/watchman/protocol"
+)
+
+// A lane is a connection to the Watchman server and the event loop that
+// drives it.
+//
+// Every Client has a lane for commands. If Options.SubscriptionConnections
+// is set, subscriptions are spread over further lanes, so that large
+// notifications do not delay the responses to commands.
+type lane struct {
+	client   *Client
+	requests chan *call
+	// stopped is closed once run returns, as nothing receives from
+	// requests afterwards
+	stopped chan struct{}
+	// conn is guarded by client.mu
+	conn *protocol.Connection
+}
+
+func newLane(c *Client, conn *protocol.Connection) *lane {
+	return &lane{
+		client:   c,
+		requests: make(chan *call),
+		stopped:  make(chan struct{}),
+		conn:     conn,
+	}
+}
+
+// run drives the event loop until the Client is closed, reconnecting
+// if enabled.
+func (l *lane) run() {
+	/* SHUTDOWN
+	requests:    never closed; senders give up when stopped is closed
+	stopped:     closed locally
+	results:     closed locally if unanswered
+	*/
+	defer close(l.stopped)
+
+	conn := l.connection()
+	for conn != nil {
+		err := runEventLoop(l.client.closed, conn, l.requests, l.client.dispatch)
+		if err == nil {
+			l.client.endSubscriptions(l, ErrClosed)
+			return
+		}
+		if !l.client.opts.Reconnect {
+			l.client.endSubscriptions(l, err)
+			return
+		}
+		conn = l.reconnect(err)
+	}
+	l.client.endSubscriptions(l, ErrClosed)
+}
+
+// send issues a request over the lane and waits for its response. If
+// ctx is done first, send returns ctx.Err() and the response is
+// discarded when it arrives.
+func (l *lane) send(ctx context.Context, req protocol.Request) (protocol.ResponsePDU, error) {
+	return l.do(ctx, newCall(req))
+}
+
+// do is like send, but accepts a prepared call. It returns ErrClosed
+// if the Client is closed, or the lane stopped, before the call is
+// sent.
+func (l *lane) do(ctx context.Context, call *call) (protocol.ResponsePDU, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case l.requests <- call:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-l.client.closed.Done():
+		return nil, ErrClosed
+	case <-l.stopped:
+		return nil, ErrClosed
+	}
+	return call.wait(ctx)
+}
+
+func (l *lane) connection() *protocol.Connection {
+	l.client.mu.Lock()
+	defer l.client.mu.Unlock()
+	return l.conn
+}
+
+// subscriptionLane returns the lane that carries the subscription
+// identified by key. The choice is stable, so that a subscription that
+// replaces another with the same name is made on the same connection,
+// as the Watchman server scopes subscription names to a connection.
+func (c *Client) subscriptionLane(key subscriptionKey) *lane {
+	if len(c.subscriptions) == 0 {
+		return c.commands
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key.root))
+	h.Write([]byte{0})
+	h.Write([]byte(key.name))
+	return c.subscriptions[h.Sum32()%uint32(len(c.subscriptions))]
+}
+
+// lanes returns every lane of the Client, starting with the command
+// lane.
+func (c *Client) lanes() []*lane {
+	return append([]*lane{c.commands}, c.subscriptions...)
+}
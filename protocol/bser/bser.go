@@ -0,0 +1,59 @@
+// Package bser implements the BSER binary serialization format used
+// by the Watchman service.
+//
+// Both version 1 and version 2 of the PDU framing are supported. Values
+// are decoded to the same primitive Go types used by encoding/json when
+// decoding into an interface{}, except that integers are decoded as
+// int64 and strings preserve their bytes exactly, even when they are
+// not valid UTF-8.
+//
+// See also: https://facebook.github.io/watchman/docs/bser.html
+package bser
+
+import "errors"
+
+// Version identifies the BSER PDU framing.
+type Version int
+
+// Supported BSER versions.
+const (
+	V1 Version = 1
+	V2 Version = 2
+)
+
+// Capabilities that may be advertised in a BSER version 2 PDU header.
+const (
+	CapDisableUnicode          uint32 = 0x1
+	CapDisableUnicodeForErrors uint32 = 0x2
+)
+
+const (
+	typeArray      byte = 0x00
+	typeObject     byte = 0x01
+	typeBytes      byte = 0x02
+	typeInt8       byte = 0x03
+	typeInt16      byte = 0x04
+	typeInt32      byte = 0x05
+	typeInt64      byte = 0x06
+	typeReal       byte = 0x07
+	typeTrue       byte = 0x08
+	typeFalse      byte = 0x09
+	typeNull       byte = 0x0a
+	typeTemplate   byte = 0x0b
+	typeSkip       byte = 0x0c
+	typeUTF8String byte = 0x0d
+)
+
+var (
+	magicV1 = []byte{0x00, 0x01}
+	magicV2 = []byte{0x00, 0x02}
+)
+
+// ErrBadMagic is returned when data does not start with a BSER PDU header.
+var ErrBadMagic = errors.New("bser: invalid PDU header")
+
+// IsPDU reports whether b starts with a BSER PDU header. It can be used
+// to distinguish BSER from JSON encoded data.
+func IsPDU(b []byte) bool {
+	return len(b) >= 2 && b[0] == 0x00 && (b[1] == 0x01 || b[1] == 0x02)
+}
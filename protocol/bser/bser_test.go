@@ -0,0 +1,254 @@
+package bser
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type marshaler struct{}
+
+func (m marshaler) MarshalJSON() ([]byte, error) {
+	return []byte(`["allof",["type","f"],{"size":1024}]`), nil
+}
+
+func TestMarshal(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		version Version
+		value   interface{}
+		encoded []byte
+	}{
+		{
+			version: V1,
+			value:   []interface{}{"abc"},
+			encoded: []byte{
+				0x00, 0x01, 0x03, 0x09,
+				0x00, 0x03, 0x01,
+				0x02, 0x03, 0x03, 'a', 'b', 'c',
+			},
+		},
+		{
+			version: V2,
+			value:   []interface{}{"abc"},
+			encoded: []byte{
+				0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x03, 0x09,
+				0x00, 0x03, 0x01,
+				0x0d, 0x03, 0x03, 'a', 'b', 'c',
+			},
+		},
+		{
+			version: V2,
+			value:   map[string]interface{}{"b": false, "a": nil},
+			encoded: []byte{
+				0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x03, 0x0d,
+				0x01, 0x03, 0x02,
+				0x0d, 0x03, 0x01, 'a', 0x0a,
+				0x0d, 0x03, 0x01, 'b', 0x09,
+			},
+		},
+		{
+			version: V2,
+			value:   []int{1, 300, 70000, 5000000000},
+			encoded: []byte{
+				0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x03, 0x16,
+				0x00, 0x03, 0x04,
+				0x03, 0x01,
+				0x04, 0x2c, 0x01,
+				0x05, 0x70, 0x11, 0x01, 0x00,
+				0x06, 0x00, 0xf2, 0x05, 0x2a, 0x01, 0x00, 0x00, 0x00,
+			},
+		},
+		{
+			version: V2,
+			value:   []interface{}{"\xff", 1.5, true},
+			encoded: []byte{
+				0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x03, 0x11,
+				0x00, 0x03, 0x03,
+				0x02, 0x03, 0x01, 0xff,
+				0x07, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf8, 0x3f,
+				0x08,
+			},
+		},
+	} {
+		buf := &bytes.Buffer{}
+		enc := NewEncoder(buf)
+		enc.SetVersion(tc.version, 0)
+		require.NoError(enc.Encode(tc.value))
+		require.Equal(tc.encoded, buf.Bytes())
+	}
+}
+
+func TestMarshalJSONMarshaler(t *testing.T) {
+	require := require.New(t)
+
+	b, err := Marshal([]interface{}{"query", "/tmp", marshaler{}})
+	require.NoError(err)
+
+	var actual interface{}
+	require.NoError(Unmarshal(b, &actual))
+	require.Equal([]interface{}{
+		"query",
+		"/tmp",
+		[]interface{}{
+			"allof",
+			[]interface{}{"type", "f"},
+			map[string]interface{}{"size": int64(1024)},
+		},
+	}, actual)
+}
+
+func TestUnmarshalTemplate(t *testing.T) {
+	require := require.New(t)
+
+	// Example from https://facebook.github.io/watchman/docs/bser.html
+	body := []byte{
+		0x0b,
+		0x00, 0x03, 0x02,
+		0x02, 0x03, 0x04, 'n', 'a', 'm', 'e',
+		0x02, 0x03, 0x03, 'a', 'g', 'e',
+		0x03, 0x03,
+		0x02, 0x03, 0x04, 'f', 'r', 'e', 'd',
+		0x03, 0x14,
+		0x02, 0x03, 0x04, 'p', 'e', 't', 'e',
+		0x03, 0x1e,
+		0x0c,
+		0x03, 0x19,
+	}
+	pdu := append([]byte{0x00, 0x01, 0x03, byte(len(body))}, body...)
+
+	var actual interface{}
+	require.NoError(Unmarshal(pdu, &actual))
+	require.Equal([]interface{}{
+		map[string]interface{}{"name": "fred", "age": int64(20)},
+		map[string]interface{}{"name": "pete", "age": int64(30)},
+		map[string]interface{}{"age": int64(25)},
+	}, actual)
+}
+
+func TestRoundTrip(t *testing.T) {
+	require := require.New(t)
+
+	type pdu map[string]interface{}
+	expected := pdu{
+		"version":           "2022.01.31.00",
+		"clock":             "c:1531594843:978:9:826",
+		"is_fresh_instance": true,
+		"files": []interface{}{
+			map[string]interface{}{"name": "foo/main.go", "size": int64(-1)},
+			map[string]interface{}{"name": "bar/\xfe.go", "mtime_f": 1.25},
+		},
+	}
+
+	for _, version := range []Version{V1, V2} {
+		buf := &bytes.Buffer{}
+		enc := NewEncoder(buf)
+		enc.SetVersion(version, CapDisableUnicode)
+		require.NoError(enc.Encode(expected))
+		require.NoError(enc.Encode([]string{"second"}))
+
+		dec := NewDecoder(buf)
+		var actual pdu
+		require.NoError(dec.Decode(&actual))
+		require.Equal(expected, actual)
+
+		v, caps := dec.Version()
+		require.Equal(version, v)
+		if version == V2 {
+			require.Equal(CapDisableUnicode, caps)
+		}
+
+		var second interface{}
+		require.NoError(dec.Decode(&second))
+		require.Equal([]interface{}{"second"}, second)
+
+		require.Equal(io.EOF, dec.Decode(&second))
+	}
+}
+
+func TestUnmarshalErrors(t *testing.T) {
+	require := require.New(t)
+
+	var x interface{}
+	require.Equal(ErrBadMagic, Unmarshal([]byte(`{"a":1}`), &x))
+	require.Equal(io.ErrUnexpectedEOF, Unmarshal([]byte{0x00, 0x01, 0x03, 0x05, 0x00}, &x))
+	require.Error(Unmarshal([]byte{0x00, 0x01, 0x03, 0x01, 0x42}, &x))
+	require.Error(Unmarshal([]byte{0x00, 0x01, 0x03, 0x02, 0x08, 0x08}, &x))
+
+	var m map[string]interface{}
+	require.Error(Unmarshal([]byte{0x00, 0x01, 0x03, 0x01, 0x08}, &m))
+	require.Error(Unmarshal([]byte{0x00, 0x01, 0x03, 0x01, 0x08}, x))
+}
+
+func TestUnmarshalLimits(t *testing.T) {
+	require := require.New(t)
+
+	// the length of a PDU is bounded, and a truncated PDU is detected
+	// without allocating its length
+	var x interface{}
+	huge := []byte{0x05, 0xff, 0xff, 0xff, 0x7f}
+	require.EqualError(Unmarshal(append([]byte{0x00, 0x01}, huge...), &x),
+		"bser: PDU length 2147483647 exceeds the maximum of 1073741824")
+	require.Equal(io.ErrUnexpectedEOF, Unmarshal([]byte{0x00, 0x01, 0x05, 0x00, 0x00, 0x00, 0x20}, &x))
+
+	dec := NewDecoder(bytes.NewReader([]byte{0x00, 0x01, 0x03, 0x02, 0x03, 0x01}))
+	dec.SetMaxPDUSize(1)
+	require.Error(dec.Decode(&x))
+
+	// the counts of containers are bounded by the rest of the PDU
+	for _, body := range [][]byte{
+		append([]byte{0x00}, huge...),
+		append([]byte{0x01}, huge...),
+		append([]byte{0x0b, 0x00}, huge...),
+		append([]byte{0x0b, 0x00, 0x03, 0x01, 0x02, 0x03, 0x01, 'a'}, huge...),
+		// zero keys would let any number of rows take no space at all
+		append([]byte{0x0b, 0x00, 0x03, 0x00}, huge...),
+		{0x0b, 0x00, 0x03, 0x00, 0x03, 0x02},
+	} {
+		pdu := append([]byte{0x00, 0x01, 0x03, byte(len(body))}, body...)
+		err := Unmarshal(pdu, &x)
+		require.Error(err)
+		require.Contains(err.Error(), "exceed the remaining")
+	}
+}
+
+func TestIsPDU(t *testing.T) {
+	require := require.New(t)
+
+	require.True(IsPDU([]byte{0x00, 0x01}))
+	require.True(IsPDU([]byte{0x00, 0x02, 0x00}))
+	require.False(IsPDU([]byte{0x00}))
+	require.False(IsPDU([]byte(`["clock"]`)))
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	files := make([]interface{}, 1000)
+	for i := range files {
+		files[i] = map[string]interface{}{
+			"name":   "some/deeply/nested/path/file.go",
+			"exists": true,
+			"size":   int64(i),
+		}
+	}
+	data, err := Marshal(map[string]interface{}{"files": files})
+	require.NoError(b, err)
+	jsonData, err := json.Marshal(map[string]interface{}{"files": files})
+	require.NoError(b, err)
+
+	b.Run("bser", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var x interface{}
+			_ = Unmarshal(data, &x)
+		}
+	})
+	b.Run("json", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			var x interface{}
+			_ = json.Unmarshal(jsonData, &x)
+		}
+	})
+}
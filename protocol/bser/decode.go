@@ -0,0 +1,428 @@
+package bser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+)
+
+// Unmarshal decodes a BSER version 1 or version 2 PDU and stores the
+// result in the value pointed to by v.
+//
+// See Decoder.Decode for the types that v may point to.
+func Unmarshal(data []byte, v interface{}) error {
+	dec := NewDecoder(bytes.NewReader(data))
+	return dec.Decode(v)
+}
+
+// DefaultMaxPDUSize is the default limit on the length of the PDUs read
+// by a Decoder.
+const DefaultMaxPDUSize = 1 << 30
+
+// readChunk is the size of the buffer first allocated to read a PDU
+// larger than it, which grows as the PDU arrives.
+const readChunk = 1 << 20
+
+// A Decoder reads and decodes BSER PDUs from an input stream.
+//
+// A Decoder reads exactly one PDU per call to Decode, so the stream may
+// be shared with other readers between calls.
+type Decoder struct {
+	r            io.Reader
+	version      Version
+	capabilities uint32
+	maxSize      int
+}
+
+// NewDecoder returns a new Decoder that reads from r.
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r, maxSize: DefaultMaxPDUSize}
+}
+
+// SetMaxPDUSize limits the length of the PDUs that Decode reads, after
+// their header, to n bytes. Decode fails on longer PDUs without reading
+// them. The default is DefaultMaxPDUSize.
+func (dec *Decoder) SetMaxPDUSize(n int) {
+	dec.maxSize = n
+}
+
+// Version returns the version and capabilities of the most recently
+// decoded PDU.
+func (dec *Decoder) Version() (Version, uint32) {
+	return dec.version, dec.capabilities
+}
+
+// Decode reads the next PDU from the stream and stores the result in
+// the value pointed to by v. The decoded value is stored using the same
+// rules encoding/json uses for an interface{}, so v should point to an
+// interface{}, or to a map[string]interface{} (or a named type with
+// that underlying type) when the PDU is known to hold an object.
+func (dec *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return errors.New("bser: Decode requires a non-nil pointer")
+	}
+
+	var magic [2]byte
+	if _, err := io.ReadFull(dec.r, magic[:]); err != nil {
+		return err
+	}
+	if !IsPDU(magic[:]) {
+		return ErrBadMagic
+	}
+
+	dec.version = Version(magic[1])
+	dec.capabilities = 0
+	if dec.version == V2 {
+		var caps [4]byte
+		if _, err := io.ReadFull(dec.r, caps[:]); err != nil {
+			return unexpectedEOF(err)
+		}
+		dec.capabilities = binary.LittleEndian.Uint32(caps[:])
+	}
+
+	length, err := dec.readLength()
+	if err != nil {
+		return err
+	}
+	if length > dec.maxSize {
+		return fmt.Errorf("bser: PDU length %d exceeds the maximum of %d", length, dec.maxSize)
+	}
+
+	buf, err := readN(dec.r, length)
+	if err != nil {
+		return unexpectedEOF(err)
+	}
+
+	d := &decodeState{data: buf}
+	x, err := d.value()
+	if err != nil {
+		return err
+	}
+	if d.off != len(d.data) {
+		return fmt.Errorf("bser: %d unexpected trailing bytes", len(d.data)-d.off)
+	}
+
+	return store(rv.Elem(), x)
+}
+
+// readLength reads the integer that follows the PDU header directly
+// from the stream, since the PDU has not been buffered yet.
+func (dec *Decoder) readLength() (int, error) {
+	var typ [1]byte
+	if _, err := io.ReadFull(dec.r, typ[:]); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	size, err := intSize(typ[0])
+	if err != nil {
+		return 0, err
+	}
+	buf := make([]byte, size+1)
+	buf[0] = typ[0]
+	if _, err = io.ReadFull(dec.r, buf[1:]); err != nil {
+		return 0, unexpectedEOF(err)
+	}
+	d := &decodeState{data: buf}
+	return d.length()
+}
+
+// readN reads n bytes from r. The buffer grows as the bytes arrive, so
+// that a corrupt length cannot trigger a huge allocation.
+func readN(r io.Reader, n int) ([]byte, error) {
+	if n <= readChunk {
+		buf := make([]byte, n)
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	var buf bytes.Buffer
+	buf.Grow(readChunk)
+	_, err := io.CopyN(&buf, r, int64(n))
+	return buf.Bytes(), err
+}
+
+func store(dst reflect.Value, x interface{}) error {
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		if x == nil {
+			dst.Set(reflect.Zero(dst.Type()))
+		} else {
+			dst.Set(reflect.ValueOf(x))
+		}
+		return nil
+	}
+	if x == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+	src := reflect.ValueOf(x)
+	if !src.Type().ConvertibleTo(dst.Type()) {
+		return fmt.Errorf("bser: cannot decode %s into %s", src.Type(), dst.Type())
+	}
+	dst.Set(src.Convert(dst.Type()))
+	return nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// decodeState holds the buffered contents of a single PDU.
+type decodeState struct {
+	data []byte
+	off  int
+}
+
+func (d *decodeState) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("bser: offset %d: %s", d.off, fmt.Sprintf(format, args...))
+}
+
+// remaining returns the number of bytes left to decode.
+func (d *decodeState) remaining() int {
+	return len(d.data) - d.off
+}
+
+// count reads the number of elements of a container, failing if the
+// elements, which take at least size bytes each, cannot fit in the
+// rest of the PDU.
+func (d *decodeState) count(size int) (int, error) {
+	n, err := d.length()
+	if err != nil {
+		return 0, err
+	}
+	if int64(n)*int64(size) > int64(d.remaining()) {
+		return 0, d.errorf("%d elements exceed the remaining %d bytes", n, d.remaining())
+	}
+	return n, nil
+}
+
+func (d *decodeState) next(n int) ([]byte, error) {
+	if n < 0 || len(d.data)-d.off < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.data[d.off : d.off+n]
+	d.off += n
+	return b, nil
+}
+
+func (d *decodeState) typ() (byte, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+func intSize(typ byte) (int, error) {
+	switch typ {
+	case typeInt8:
+		return 1, nil
+	case typeInt16:
+		return 2, nil
+	case typeInt32:
+		return 4, nil
+	case typeInt64:
+		return 8, nil
+	}
+	return 0, fmt.Errorf("bser: expected integer, found type 0x%02x", typ)
+}
+
+func (d *decodeState) int(typ byte) (int64, error) {
+	size, err := intSize(typ)
+	if err != nil {
+		return 0, err
+	}
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	switch size {
+	case 1:
+		return int64(int8(b[0])), nil
+	case 2:
+		return int64(int16(binary.LittleEndian.Uint16(b))), nil
+	case 4:
+		return int64(int32(binary.LittleEndian.Uint32(b))), nil
+	default:
+		return int64(binary.LittleEndian.Uint64(b)), nil
+	}
+}
+
+// length reads an integer that is used as a count or size.
+func (d *decodeState) length() (int, error) {
+	typ, err := d.typ()
+	if err != nil {
+		return 0, err
+	}
+	n, err := d.int(typ)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n > int64(math.MaxInt32) {
+		return 0, d.errorf("invalid length %d", n)
+	}
+	return int(n), nil
+}
+
+func (d *decodeState) value() (interface{}, error) {
+	typ, err := d.typ()
+	if err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case typeArray:
+		return d.array()
+	case typeObject:
+		return d.object()
+	case typeBytes, typeUTF8String:
+		return d.string()
+	case typeInt8, typeInt16, typeInt32, typeInt64:
+		return d.int(typ)
+	case typeReal:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case typeTrue:
+		return true, nil
+	case typeFalse:
+		return false, nil
+	case typeNull:
+		return nil, nil
+	case typeTemplate:
+		return d.template()
+	}
+	d.off--
+	return nil, d.errorf("unexpected type 0x%02x", typ)
+}
+
+func (d *decodeState) string() (string, error) {
+	n, err := d.length()
+	if err != nil {
+		return "", err
+	}
+	b, err := d.next(n)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (d *decodeState) key() (string, error) {
+	typ, err := d.typ()
+	if err != nil {
+		return "", err
+	}
+	if typ != typeBytes && typ != typeUTF8String {
+		d.off--
+		return "", d.errorf("expected string key, found type 0x%02x", typ)
+	}
+	return d.string()
+}
+
+func (d *decodeState) array() ([]interface{}, error) {
+	n, err := d.count(1)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]interface{}, 0, capacity(n))
+	for i := 0; i < n; i++ {
+		x, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, x)
+	}
+	return res, nil
+}
+
+func (d *decodeState) object() (map[string]interface{}, error) {
+	// each element is a key of at least three bytes and a value
+	n, err := d.count(4)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]interface{}, capacity(n))
+	for i := 0; i < n; i++ {
+		key, err := d.key()
+		if err != nil {
+			return nil, err
+		}
+		x, err := d.value()
+		if err != nil {
+			return nil, err
+		}
+		res[key] = x
+	}
+	return res, nil
+}
+
+// template decodes a templated array of objects. Keys holding the skip
+// marker are omitted from the corresponding object.
+//
+// Each row holds a value or skip marker of at least one byte per key,
+// and the row count is bounded by the rest of the PDU even if there
+// are no keys, which would otherwise let a few bytes decode to any
+// number of empty objects.
+func (d *decodeState) template() ([]interface{}, error) {
+	typ, err := d.typ()
+	if err != nil {
+		return nil, err
+	}
+	if typ != typeArray {
+		d.off--
+		return nil, d.errorf("expected template keys, found type 0x%02x", typ)
+	}
+	nkeys, err := d.count(3)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, nkeys)
+	for i := range keys {
+		if keys[i], err = d.key(); err != nil {
+			return nil, err
+		}
+	}
+
+	size := nkeys
+	if size == 0 {
+		size = 1
+	}
+	n, err := d.count(size)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]interface{}, 0, capacity(n))
+	for i := 0; i < n; i++ {
+		obj := make(map[string]interface{}, nkeys)
+		for _, key := range keys {
+			if d.off < len(d.data) && d.data[d.off] == typeSkip {
+				d.off++
+				continue
+			}
+			x, err := d.value()
+			if err != nil {
+				return nil, err
+			}
+			obj[key] = x
+		}
+		res = append(res, obj)
+	}
+	return res, nil
+}
+
+// capacity limits preallocation so that a corrupt count cannot
+// trigger a huge allocation; each element needs at least one byte.
+func capacity(n int) int {
+	if n > 1024 {
+		return 1024
+	}
+	return n
+}
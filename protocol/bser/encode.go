@@ -0,0 +1,300 @@
+package bser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"sort"
+	"unicode/utf8"
+)
+
+// Marshal returns the BSER version 2 PDU encoding of v.
+//
+// Values implementing json.Marshaler, and structs, are first converted
+// to JSON and then re-encoded, so they are encoded exactly as they
+// would be by encoding/json.
+func Marshal(v interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// An Encoder writes BSER PDUs to an output stream.
+type Encoder struct {
+	w            io.Writer
+	version      Version
+	capabilities uint32
+}
+
+// NewEncoder returns a new Encoder that writes version 2 PDUs to w.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w, version: V2}
+}
+
+// SetVersion changes the PDU framing, and for version 2, the
+// capabilities advertised in the PDU header.
+func (enc *Encoder) SetVersion(version Version, capabilities uint32) {
+	enc.version = version
+	enc.capabilities = capabilities
+}
+
+// Encode writes the BSER PDU encoding of v to the stream.
+func (enc *Encoder) Encode(v interface{}) error {
+	body := &encodeState{version: enc.version}
+	if err := body.encode(v); err != nil {
+		return err
+	}
+
+	pdu := &encodeState{version: enc.version}
+	switch enc.version {
+	case V1:
+		pdu.Write(magicV1)
+	case V2:
+		pdu.Write(magicV2)
+		var caps [4]byte
+		binary.LittleEndian.PutUint32(caps[:], enc.capabilities)
+		pdu.Write(caps[:])
+	default:
+		return fmt.Errorf("bser: unsupported version %d", enc.version)
+	}
+	pdu.encodeInt(int64(body.Len()))
+	pdu.Write(body.Bytes())
+
+	// write the PDU at once so it is never interleaved on a socket
+	_, err := enc.w.Write(pdu.Bytes())
+	return err
+}
+
+// encodeState accumulates the encoding of a single value.
+//
+// BSER uses the host byte order. Watchman only runs on little-endian
+// hosts, so little-endian is used unconditionally.
+type encodeState struct {
+	bytes.Buffer
+	version Version
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+func (e *encodeState) encode(v interface{}) error {
+	switch x := v.(type) {
+	case nil:
+		e.WriteByte(typeNull)
+	case json.Marshaler:
+		return e.encodeValue(reflect.ValueOf(x))
+	case bool:
+		e.encodeBool(x)
+	case string:
+		e.encodeString(x)
+	case []byte:
+		e.encodeBytes(x)
+	case int:
+		e.encodeInt(int64(x))
+	case int64:
+		e.encodeInt(x)
+	case float64:
+		e.encodeReal(x)
+	case []interface{}:
+		e.WriteByte(typeArray)
+		e.encodeInt(int64(len(x)))
+		for _, elem := range x {
+			if err := e.encode(elem); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(x))
+		for key := range x {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		e.WriteByte(typeObject)
+		e.encodeInt(int64(len(keys)))
+		for _, key := range keys {
+			e.encodeString(key)
+			if err := e.encode(x[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return e.encodeValue(reflect.ValueOf(v))
+	}
+	return nil
+}
+
+func (e *encodeState) encodeValue(v reflect.Value) error {
+	if v.Type().Implements(jsonMarshalerType) && v.CanInterface() {
+		if v.Kind() == reflect.Ptr && v.IsNil() {
+			e.WriteByte(typeNull)
+			return nil
+		}
+		return e.encodeJSON(v.Interface().(json.Marshaler))
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		e.encodeBool(v.Bool())
+	case reflect.String:
+		e.encodeString(v.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.encodeInt(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u := v.Uint()
+		if u > math.MaxInt64 {
+			return fmt.Errorf("bser: integer overflow: %d", u)
+		}
+		e.encodeInt(int64(u))
+	case reflect.Float32, reflect.Float64:
+		e.encodeReal(v.Float())
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			e.WriteByte(typeNull)
+			return nil
+		}
+		return e.encode(v.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.WriteByte(typeNull)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.encodeBytes(b)
+			return nil
+		}
+		e.WriteByte(typeArray)
+		e.encodeInt(int64(v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			if err := e.encode(v.Index(i).Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("bser: unsupported map key type %s", v.Type().Key())
+		}
+		if v.IsNil() {
+			e.WriteByte(typeNull)
+			return nil
+		}
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return keys[i].String() < keys[j].String()
+		})
+		e.WriteByte(typeObject)
+		e.encodeInt(int64(len(keys)))
+		for _, key := range keys {
+			e.encodeString(key.String())
+			if err := e.encode(v.MapIndex(key).Interface()); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		b, err := json.Marshal(v.Interface())
+		if err != nil {
+			return err
+		}
+		return e.encodeRawJSON(b)
+	default:
+		return fmt.Errorf("bser: unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func (e *encodeState) encodeJSON(m json.Marshaler) error {
+	b, err := m.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return e.encodeRawJSON(b)
+}
+
+func (e *encodeState) encodeRawJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var x interface{}
+	if err := dec.Decode(&x); err != nil {
+		return err
+	}
+	return e.encode(fromJSON(x))
+}
+
+// fromJSON replaces json.Number values with int64 or float64.
+func fromJSON(v interface{}) interface{} {
+	switch x := v.(type) {
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			return i
+		}
+		f, _ := x.Float64()
+		return f
+	case []interface{}:
+		for i, elem := range x {
+			x[i] = fromJSON(elem)
+		}
+	case map[string]interface{}:
+		for key, elem := range x {
+			x[key] = fromJSON(elem)
+		}
+	}
+	return v
+}
+
+func (e *encodeState) encodeBool(b bool) {
+	if b {
+		e.WriteByte(typeTrue)
+	} else {
+		e.WriteByte(typeFalse)
+	}
+}
+
+func (e *encodeState) encodeString(s string) {
+	if e.version == V2 && utf8.ValidString(s) {
+		e.WriteByte(typeUTF8String)
+	} else {
+		e.WriteByte(typeBytes)
+	}
+	e.encodeInt(int64(len(s)))
+	e.WriteString(s)
+}
+
+func (e *encodeState) encodeBytes(b []byte) {
+	e.WriteByte(typeBytes)
+	e.encodeInt(int64(len(b)))
+	e.Write(b)
+}
+
+func (e *encodeState) encodeInt(i int64) {
+	var buf [8]byte
+	switch {
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		e.WriteByte(typeInt8)
+		e.WriteByte(byte(int8(i)))
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		e.WriteByte(typeInt16)
+		binary.LittleEndian.PutUint16(buf[:], uint16(int16(i)))
+		e.Write(buf[:2])
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		e.WriteByte(typeInt32)
+		binary.LittleEndian.PutUint32(buf[:], uint32(int32(i)))
+		e.Write(buf[:4])
+	default:
+		e.WriteByte(typeInt64)
+		binary.LittleEndian.PutUint64(buf[:], uint64(i))
+		e.Write(buf[:8])
+	}
+}
+
+func (e *encodeState) encodeReal(f float64) {
+	var buf [8]byte
+	e.WriteByte(typeReal)
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+	e.Write(buf[:])
+}
@@ -3,48 +3,168 @@ package protocol
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"net"
 	"os"
 	"os/exec"
+	"sync"
 	"time"
+
+	"github.com/cdmistman/watchman/protocol/bser"
+)
+
+// Encoding identifies the format used to serialize PDUs.
+type Encoding int
+
+// Encodings supported by Connection.
+const (
+	EncodingJSON Encoding = iota
+	EncodingBSERv1
+	EncodingBSERv2
 )
 
+func (e Encoding) String() string {
+	switch e {
+	case EncodingJSON:
+		return "json"
+	case EncodingBSERv1:
+		return "bser"
+	case EncodingBSERv2:
+		return "bser-v2"
+	}
+	return fmt.Sprintf("Encoding(%d)", int(e))
+}
+
 // Connection provides a low-level interface to the Watchman service.
 type Connection struct {
-	reader *bufio.Reader
-	socket io.Writer
+	reader   *bufio.Reader
+	socket   io.Writer
+	encoding Encoding
 	// metadata
 	capabilities map[string]struct{}
 	sockname     string
 	version      string
+
+	// commands holds the names of the commands sent and not yet
+	// answered, oldest first, so that errors can name their command
+	mu       sync.Mutex
+	commands []string
+}
+
+// A Dialer connects to the socket of the Watchman server, named by
+// sockname.
+type Dialer func(ctx context.Context, sockname string) (net.Conn, error)
+
+// ConnectOptions configures ConnectWithOptions.
+type ConnectOptions struct {
+	// SockName is the location of the socket of the Watchman server. By
+	// default, it is read from the WATCHMAN_SOCK environment variable
+	// or, if that is not set, from the watchman get-sockname command.
+	SockName string
+
+	// BinaryPath is the path of the watchman executable that runs the
+	// get-sockname command. By default, watchman is looked up in PATH.
+	BinaryPath string
+
+	// Env lists environment variables, in the form "key=value", added to
+	// the environment of the watchman executable, and so of the server
+	// if it is started.
+	Env []string
+
+	// DialTimeout limits how long to wait for the socket to accept a
+	// connection. The default is 30 seconds.
+	DialTimeout time.Duration
+
+	// NoSpawn prevents the watchman executable from starting the server
+	// if it is not running, in which case connecting fails.
+	NoSpawn bool
+
+	// Dialer connects to the socket. By default, the socket is a UNIX
+	// domain socket, or a named pipe on Windows.
+	Dialer Dialer
+
+	// Encodings lists the encodings to negotiate, most preferred first.
+	// Each is tried over a new connection until the server accepts one.
+	// By default, BSER version 2, BSER version 1 and JSON are tried.
+	Encodings []Encoding
 }
 
+// defaultDialTimeout limits how long Connect waits for the socket to
+// accept a connection.
+const defaultDialTimeout = 30 * time.Second
+
+// defaultEncodings are the encodings negotiated by default, most
+// preferred first.
+var defaultEncodings = []Encoding{EncodingBSERv2, EncodingBSERv1, EncodingJSON}
+
 // Connect connects to or starts the Watchman server and returns a new Connection.
+//
+// BSER encoding is preferred, version 2 over version 1. If the server
+// does not understand BSER, Connect falls back to JSON encoding.
 func Connect() (*Connection, error) {
-	sockname, err := sockname()
+	return ConnectContext(context.Background())
+}
+
+// ConnectContext is like Connect, but ctx limits how long it waits to
+// locate, connect to, and initialize the Watchman server.
+func ConnectContext(ctx context.Context) (*Connection, error) {
+	return ConnectWithOptions(ctx, ConnectOptions{})
+}
+
+// ConnectWithOptions is like ConnectContext, but locates and connects to
+// the Watchman server as configured by opts.
+func ConnectWithOptions(ctx context.Context, opts ConnectOptions) (*Connection, error) {
+	sockname, err := opts.sockname(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	socket, err := dial(sockname, 30*time.Second)
-	if err != nil {
-		return nil, err
+	encodings := opts.Encodings
+	if len(encodings) == 0 {
+		encodings = defaultEncodings
 	}
+	for _, encoding := range encodings {
+		var socket net.Conn
+		socket, err = opts.dial(ctx, sockname)
+		if err != nil {
+			return nil, err
+		}
 
-	c := &Connection{
-		reader:   bufio.NewReader(socket),
-		socket:   socket,
-		sockname: sockname,
+		c := &Connection{
+			reader:   bufio.NewReader(socket),
+			socket:   socket,
+			encoding: encoding,
+			sockname: sockname,
+		}
+		if err = c.init(ctx); err == nil {
+			return c, nil
+		}
+
+		c.Close()
+		if _, ok := err.(*WatchmanError); ok || ctx.Err() != nil {
+			break
+		}
 	}
-	err = c.init()
-	if err != nil {
-		return nil, err
+
+	return nil, err
+}
+
+func (opts *ConnectOptions) dial(ctx context.Context, sockname string) (net.Conn, error) {
+	timeout := opts.DialTimeout
+	if timeout <= 0 {
+		timeout = defaultDialTimeout
 	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
-	return c, nil
+	if opts.Dialer != nil {
+		return opts.Dialer(ctx, sockname)
+	}
+	return dial(ctx, sockname)
 }
 
 // Close closes the connection to the Watchman server.
@@ -61,6 +181,11 @@ func (c *Connection) HasCapability(capability string) bool {
 	return ok
 }
 
+// Encoding returns the format used to serialize PDUs.
+func (c *Connection) Encoding() Encoding {
+	return c.encoding
+}
+
 // SockName returns the UNIX domain socket used to communicate with the Watchman server.
 func (c *Connection) SockName() string {
 	return c.sockname
@@ -71,12 +196,12 @@ func (c *Connection) Version() string {
 	return c.version
 }
 
-func (c *Connection) init() (err error) {
-	if err = c.Send(&ListCapabilitiesRequest{}); err != nil {
+func (c *Connection) init(ctx context.Context) (err error) {
+	if err = c.SendContext(ctx, &ListCapabilitiesRequest{}); err != nil {
 		return
 	}
 
-	pdu, err := c.Recv()
+	pdu, err := c.RecvContext(ctx)
 	if err != nil {
 		return
 	}
@@ -93,32 +218,121 @@ func (c *Connection) init() (err error) {
 }
 
 // Recv reads and decodes a response PDU from the Watchman server.
+//
+// The encoding of each PDU is detected automatically.
 func (c *Connection) Recv() (ResponsePDU, error) {
-	line, err := c.reader.ReadBytes('\n')
+	return c.RecvContext(context.Background())
+}
+
+// RecvContext is like Recv, but gives up when ctx is done.
+//
+// If ctx is done after a PDU has been partially read, the Connection
+// can no longer be used and should be closed.
+func (c *Connection) RecvContext(ctx context.Context) (ResponsePDU, error) {
+	var pdu ResponsePDU
+	err := c.withContext(ctx, c.setReadDeadline, func() (err error) {
+		pdu, err = c.decode()
+		return
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var pdu ResponsePDU
-	if err = json.Unmarshal(line, &pdu); err != nil {
-		return nil, err
-	} else if msg, ok := pdu["error"]; ok {
+	var command string
+	if !pdu.IsUnilateral() {
+		command = c.answered()
+	}
+	if _, ok := pdu["error"]; ok {
+		return nil, newWatchmanError(pdu, command)
+	}
 
-		errMsg, ok := msg.(string)
-		if !ok {
-			errMsg = fmt.Sprintf("%v", msg)
-		}
+	return pdu, nil
+}
 
-		err = &WatchmanError{msg: errMsg}
-		return nil, err
+func (c *Connection) decode() (pdu ResponsePDU, err error) {
+	magic, err := c.reader.Peek(2)
+	if err == nil && bser.IsPDU(magic) {
+		err = bser.NewDecoder(c.reader).Decode(&pdu)
+		return
 	}
 
-	return pdu, nil
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return
+	}
+	err = json.Unmarshal(line, &pdu)
+	return
 }
 
 // Send encodes and sends a request PDU to the Watchman server.
-func (c *Connection) Send(req Request) (err error) {
+func (c *Connection) Send(req Request) error {
+	return c.SendContext(context.Background(), req)
+}
+
+// SendContext is like Send, but gives up when ctx is done.
+//
+// If ctx is done after a PDU has been partially written, the Connection
+// can no longer be used and should be closed.
+func (c *Connection) SendContext(ctx context.Context, req Request) error {
+	return c.withContext(ctx, c.setWriteDeadline, func() error {
+		return c.encode(req)
+	})
+}
+
+// sent records that a command is awaiting a response.
+func (c *Connection) sent(args []interface{}) {
+	var command string
+	if len(args) > 0 {
+		command, _ = args[0].(string)
+	}
+	c.mu.Lock()
+	c.commands = append(c.commands, command)
+	c.mu.Unlock()
+}
+
+// unsent forgets the most recently recorded command, which failed to be
+// sent.
+func (c *Connection) unsent() {
+	c.mu.Lock()
+	if n := len(c.commands); n > 0 {
+		c.commands = c.commands[:n-1]
+	}
+	c.mu.Unlock()
+}
+
+// answered returns the name of the oldest command awaiting a response,
+// which the response being received answers.
+func (c *Connection) answered() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.commands) == 0 {
+		return ""
+	}
+	command := c.commands[0]
+	c.commands = c.commands[1:]
+	return command
+}
+
+func (c *Connection) encode(req Request) (err error) {
 	args := req.Args()
+	// the command is recorded before it is written, as the response may
+	// be read concurrently, and forgotten if it could not be written
+	c.sent(args)
+	defer func() {
+		if err != nil {
+			c.unsent()
+		}
+	}()
+
+	switch c.encoding {
+	case EncodingBSERv1, EncodingBSERv2:
+		enc := bser.NewEncoder(c.socket)
+		if c.encoding == EncodingBSERv1 {
+			enc.SetVersion(bser.V1, 0)
+		}
+		return enc.Encode(args)
+	}
+
 	b, err := json.Marshal(args)
 	if err != nil {
 		return
@@ -133,15 +347,96 @@ func (c *Connection) Send(req Request) (err error) {
 	return
 }
 
-func sockname() (string, error) {
+// aLongTimeAgo is a deadline used to interrupt blocked socket I/O.
+var aLongTimeAgo = time.Unix(1, 0)
+
+// withContext runs fn, which performs socket I/O, with the deadline of
+// ctx applied by setDeadline, and interrupts fn if ctx is canceled.
+func (c *Connection) withContext(
+	ctx context.Context,
+	setDeadline func(time.Time) bool,
+	fn func() error,
+) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if ctx.Done() == nil {
+		return fn()
+	}
+
+	deadline, hasDeadline := ctx.Deadline()
+	if !setDeadline(deadline) {
+		// the socket does not support deadlines
+		return fn()
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		select {
+		case <-ctx.Done():
+			setDeadline(aLongTimeAgo)
+		case <-stop:
+		}
+	}()
+
+	err := fn()
+	close(stop)
+	<-stopped
+	setDeadline(time.Time{})
+
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		if hasDeadline && errors.Is(err, os.ErrDeadlineExceeded) {
+			return context.DeadlineExceeded
+		}
+	}
+	return err
+}
+
+func (c *Connection) setReadDeadline(t time.Time) bool {
+	if x, ok := c.socket.(interface{ SetReadDeadline(time.Time) error }); ok {
+		return x.SetReadDeadline(t) == nil
+	}
+	return false
+}
+
+func (c *Connection) setWriteDeadline(t time.Time) bool {
+	if x, ok := c.socket.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		return x.SetWriteDeadline(t) == nil
+	}
+	return false
+}
+
+func (opts *ConnectOptions) sockname(ctx context.Context) (string, error) {
+	if opts.SockName != "" {
+		return opts.SockName, nil
+	}
 	sockname := os.Getenv("WATCHMAN_SOCK")
 	if sockname != "" {
 		return sockname, nil
 	}
 
+	binary := opts.BinaryPath
+	if binary == "" {
+		binary = "watchman"
+	}
+	var args []string
+	if opts.NoSpawn {
+		args = append(args, "--no-spawn")
+	}
+	args = append(args, "get-sockname")
+
 	buffer := &bytes.Buffer{}
-	cmd := exec.Command("watchman", "get-sockname")
+	cmd := exec.CommandContext(ctx, binary, args...)
 	cmd.Stdout = buffer
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
 	if err := cmd.Run(); err != nil {
 		return "", err
 	}
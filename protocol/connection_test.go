@@ -0,0 +1,254 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cdmistman/watchman/protocol/bser"
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+func TestBSER(t *testing.T) {
+	require := require.New(t)
+
+	for _, encoding := range []Encoding{EncodingBSERv1, EncodingBSERv2} {
+		response := &bytes.Buffer{}
+		enc := bser.NewEncoder(response)
+		if encoding == EncodingBSERv1 {
+			enc.SetVersion(bser.V1, 0)
+		}
+		require.NoError(enc.Encode(map[string]interface{}{
+			"version":   "4.9.0",
+			"clock":     "c:1531594843:978:9:345",
+			"subscribe": "sub1",
+		}))
+		require.NoError(enc.Encode(map[string]interface{}{
+			"version": "4.9.0",
+			"error":   "unable to resolve root /nope",
+		}))
+
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader:   bufio.NewReader(response),
+			socket:   requested,
+			encoding: encoding,
+		}
+		require.Equal(encoding, c.Encoding())
+
+		err := c.Send(&SubscribeRequest{
+			Root:  "/tmp",
+			Name:  "sub1",
+			Query: &query.Query{Fields: query.Fields{query.FName}},
+		})
+		require.NoError(err)
+
+		var args interface{}
+		err = bser.Unmarshal(requested.Bytes(), &args)
+		require.NoError(err)
+		require.Equal([]interface{}{
+			"subscribe",
+			"/tmp",
+			"sub1",
+			map[string]interface{}{"fields": []interface{}{"name"}},
+		}, args)
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		res := NewSubscribeResponse(pdu)
+		require.Equal("4.9.0", res.Version())
+		require.Equal("c:1531594843:978:9:345", res.Clock())
+		require.Equal("sub1", res.Subscription())
+
+		pdu, err = c.Recv()
+		require.Nil(pdu)
+		require.IsType(&WatchmanError{}, err)
+		require.Equal("unable to resolve root /nope", err.Error())
+	}
+}
+
+func TestMixedEncodings(t *testing.T) {
+	require := require.New(t)
+
+	response := &bytes.Buffer{}
+	response.WriteString(`{"version":"4.9.0","clock":"c:1"}` + "\n")
+	require.NoError(bser.NewEncoder(response).Encode(map[string]interface{}{
+		"version": "4.9.0",
+		"clock":   "c:2",
+	}))
+	response.WriteString(`{"version":"4.9.0","clock":"c:3"}` + "\n")
+
+	c := &Connection{reader: bufio.NewReader(response)}
+	for _, clock := range []string{"c:1", "c:2", "c:3"} {
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.Equal(clock, NewClockResponse(pdu).Clock())
+	}
+}
+
+func TestContext(t *testing.T) {
+	require := require.New(t)
+
+	client, server := net.Pipe()
+	defer server.Close()
+	c := &Connection{
+		reader: bufio.NewReader(client),
+		socket: client,
+	}
+	defer c.Close()
+
+	// nothing reads the request
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	err := c.SendContext(ctx, &ClockRequest{Path: "/tmp"})
+	require.Equal(context.Canceled, err)
+
+	// nothing writes a response
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	pdu, err := c.RecvContext(ctx)
+	require.Nil(pdu)
+	require.Equal(context.DeadlineExceeded, err)
+
+	// already done
+	pdu, err = c.RecvContext(ctx)
+	require.Nil(pdu)
+	require.Equal(context.DeadlineExceeded, err)
+
+	// deadlines are cleared after use
+	go func() {
+		reader := bufio.NewReader(server)
+		_, _ = reader.ReadBytes('\n')
+		time.Sleep(20 * time.Millisecond)
+		_, _ = server.Write([]byte(`{"clock":"c:1","version":"4.9.0"}` + "\n"))
+	}()
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = c.SendContext(ctx, &ClockRequest{Path: "/tmp"})
+	require.NoError(err)
+	pdu, err = c.Recv()
+	require.NoError(err)
+	require.Equal("c:1", NewClockResponse(pdu).Clock())
+}
+
+func TestConnectOptionsSockName(t *testing.T) {
+	require := require.New(t)
+	ctx := context.Background()
+
+	t.Setenv("WATCHMAN_SOCK", "/tmp/env-sock")
+	opts := &ConnectOptions{SockName: "/tmp/sock"}
+	sockname, err := opts.sockname(ctx)
+	require.NoError(err)
+	require.Equal("/tmp/sock", sockname)
+
+	opts = &ConnectOptions{}
+	sockname, err = opts.sockname(ctx)
+	require.NoError(err)
+	require.Equal("/tmp/env-sock", sockname)
+
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake watchman executable is a shell script")
+	}
+	t.Setenv("WATCHMAN_SOCK", "")
+	binary := filepath.Join(t.TempDir(), "watchman")
+	script := "#!/bin/sh\n" + `echo "{\"sockname\": \"$SPOON:$*\"}"` + "\n"
+	require.NoError(os.WriteFile(binary, []byte(script), 0o755))
+	opts = &ConnectOptions{
+		BinaryPath: binary,
+		Env:        []string{"SPOON=Spoon!"},
+		NoSpawn:    true,
+	}
+	sockname, err = opts.sockname(ctx)
+	require.NoError(err)
+	require.Equal("Spoon!:--no-spawn get-sockname", sockname)
+}
+
+func TestConnectOptionsDialer(t *testing.T) {
+	require := require.New(t)
+
+	errDial := errors.New("dial failed")
+	var deadline time.Time
+	_, err := ConnectWithOptions(context.Background(), ConnectOptions{
+		SockName:    "/tmp/sock",
+		DialTimeout: time.Minute,
+		Dialer: func(ctx context.Context, sockname string) (net.Conn, error) {
+			require.Equal("/tmp/sock", sockname)
+			deadline, _ = ctx.Deadline()
+			return nil, errDial
+		},
+	})
+	require.Equal(errDial, err)
+	require.WithinDuration(time.Now().Add(time.Minute), deadline, 10*time.Second)
+}
+
+func TestConnectOptionsEncodings(t *testing.T) {
+	require := require.New(t)
+
+	// the server only understands BSER version 1 and JSON, and hangs up
+	// on other PDUs
+	var tried []Encoding
+	dialer := func(ctx context.Context, sockname string) (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			r := bufio.NewReader(server)
+			magic, err := r.Peek(2)
+			if err != nil {
+				return
+			}
+			res := map[string]interface{}{"version": "4.9.0", "capabilities": []interface{}{}}
+			switch {
+			case bytes.Equal(magic, []byte{0, 1}):
+				tried = append(tried, EncodingBSERv1)
+				var req interface{}
+				if bser.NewDecoder(r).Decode(&req) != nil {
+					return
+				}
+				enc := bser.NewEncoder(server)
+				enc.SetVersion(bser.V1, 0)
+				_ = enc.Encode(res)
+			case bytes.Equal(magic, []byte{0, 2}):
+				tried = append(tried, EncodingBSERv2)
+			default:
+				tried = append(tried, EncodingJSON)
+				if _, err := r.ReadBytes('\n'); err != nil {
+					return
+				}
+				b, _ := json.Marshal(res)
+				_, _ = server.Write(append(b, '\n'))
+			}
+		}()
+		return client, nil
+	}
+
+	c, err := ConnectWithOptions(context.Background(), ConnectOptions{SockName: "/tmp/sock", Dialer: dialer})
+	require.NoError(err)
+	require.Equal(EncodingBSERv1, c.Encoding())
+	require.Equal("4.9.0", c.Version())
+	require.NoError(c.Close())
+	require.Equal([]Encoding{EncodingBSERv2, EncodingBSERv1}, tried)
+
+	tried = nil
+	c, err = ConnectWithOptions(context.Background(), ConnectOptions{
+		SockName:  "/tmp/sock",
+		Dialer:    dialer,
+		Encodings: []Encoding{EncodingJSON},
+	})
+	require.NoError(err)
+	require.Equal(EncodingJSON, c.Encoding())
+	require.NoError(c.Close())
+	require.Equal([]Encoding{EncodingJSON}, tried)
+}
@@ -4,10 +4,11 @@
 package protocol
 
 import (
+	"context"
 	"net"
-	"time"
 )
 
-func dial(sockname string, timeout time.Duration) (net.Conn, error) {
-	return net.DialTimeout("unix", sockname, timeout)
+func dial(ctx context.Context, sockname string) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, "unix", sockname)
 }
@@ -1,12 +1,12 @@
 package protocol
 
 import (
+	"context"
 	"net"
-	"time"
 
 	winio "github.com/Microsoft/go-winio"
 )
 
-func dial(sockname string, timeout time.Duration) (net.Conn, error) {
-	return winio.DialPipe(sockname, &timeout)
+func dial(ctx context.Context, sockname string) (net.Conn, error) {
+	return winio.DialPipeContext(ctx, sockname)
 }
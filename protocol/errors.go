@@ -1,11 +1,103 @@
 package protocol
 
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Classes of WatchmanError, to be tested with errors.Is.
+var (
+	// ErrRootNotWatched is the class of errors for a request about a
+	// directory that is not watched.
+	ErrRootNotWatched = errors.New("watchman: root is not watched")
+	// ErrSyncTimeout is the class of errors for a request that timed out
+	// waiting for the server to observe the latest changes.
+	ErrSyncTimeout = errors.New("watchman: sync timeout expired")
+	// ErrUnknownCommand is the class of errors for a command that the
+	// server does not implement.
+	ErrUnknownCommand = errors.New("watchman: unknown command")
+	// ErrInvalidQuery is the class of errors for a query, or a query
+	// expression, that the server cannot parse.
+	ErrInvalidQuery = errors.New("watchman: invalid query")
+	// ErrPermissionDenied is the class of errors for a directory that
+	// the server is not permitted to watch.
+	ErrPermissionDenied = errors.New("watchman: permission denied")
+	// ErrTooManyFiles is the class of errors for a root that exceeds a
+	// resource limit of the system, such as the number of open files or
+	// of inotify watches.
+	ErrTooManyFiles = errors.New("watchman: too many files")
+)
+
+// errorClasses maps substrings of the error messages of the Watchman
+// server to their class. A message belongs to the first class with a
+// matching substring.
+var errorClasses = []struct {
+	class      error
+	substrings []string
+}{
+	{ErrRootNotWatched, []string{"not watched"}},
+	{ErrSyncTimeout, []string{"sync_timeout expired", "timed out waiting for cookie"}},
+	{ErrUnknownCommand, []string{"unknown command"}},
+	{ErrInvalidQuery, []string{"failed to parse query", "unknown expression term", "invalid expression term", "invalid field name"}},
+	{ErrPermissionDenied, []string{"permission denied"}},
+	{ErrTooManyFiles, []string{"too many open files", "inotify watches", "max_user_watches", "fd limit"}},
+}
+
 // WatchmanError is returned when the Watchman server responds to a
 // request with an error instead of a normal response.
+//
+// The class of the error, if known, is tested with errors.Is, for
+// example errors.Is(err, ErrRootNotWatched).
 type WatchmanError struct {
-	msg string
+	response
+	msg     string
+	command string
+	class   error
+}
+
+func newWatchmanError(pdu ResponsePDU, command string) *WatchmanError {
+	e := &WatchmanError{command: command}
+	e.response.init(pdu)
+
+	if x, ok := pdu["error"]; ok {
+		if msg, ok := x.(string); ok {
+			e.msg = msg
+		} else {
+			e.msg = fmt.Sprintf("%v", x)
+		}
+	}
+
+	lower := strings.ToLower(e.msg)
+	for _, c := range errorClasses {
+		for _, s := range c.substrings {
+			if strings.Contains(lower, s) {
+				e.class = c.class
+				return e
+			}
+		}
+	}
+	return e
 }
 
 func (e *WatchmanError) Error() string {
 	return e.msg
 }
+
+// Message returns the error message of the Watchman server.
+func (e *WatchmanError) Message() string {
+	return e.msg
+}
+
+// Command returns the name of the command that failed, or an empty
+// string if it is not known.
+func (e *WatchmanError) Command() string {
+	return e.command
+}
+
+// Is reports whether the error belongs to the class target, one of
+// ErrRootNotWatched, ErrSyncTimeout, ErrUnknownCommand, ErrInvalidQuery,
+// ErrPermissionDenied and ErrTooManyFiles.
+func (e *WatchmanError) Is(target error) bool {
+	return e.class != nil && e.class == target
+}
@@ -0,0 +1,84 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+func TestWatchmanError(t *testing.T) {
+	require := require.New(t)
+
+	response := `{"version":"4.9.0","unilateral":true,"subscription":"sub1","root":"/tmp"}` + "\n" +
+		`{"version":"4.9.0","error":"unable to resolve root /nope: directory /nope is not watched"}` + "\n" +
+		`{"version":"4.9.0","error":"failed to parse query: unknown expression term 'spoon'","hint":"Spoon!"}` + "\n"
+	requested := &bytes.Buffer{}
+	c := &Connection{
+		reader: bufio.NewReader(bytes.NewReader([]byte(response))),
+		socket: requested,
+	}
+	// a command that could not be sent is not answered
+	require.Error(c.Send(&SubscribeRequest{Root: "/tmp", Name: "sub1", Query: &query.Query{
+		Generators: query.Generators{query.GSince: make(chan int)},
+	}}))
+	require.NoError(c.Send(&ClockRequest{Path: "/nope"}))
+	require.NoError(c.Send(&QueryRequest{Root: "/tmp"}))
+
+	// unilateral PDUs do not answer a command
+	pdu, err := c.Recv()
+	require.NoError(err)
+	require.True(pdu.IsUnilateral())
+
+	_, err = c.Recv()
+	var e *WatchmanError
+	require.True(errors.As(err, &e))
+	require.Equal("unable to resolve root /nope: directory /nope is not watched", e.Error())
+	require.Equal(e.Error(), e.Message())
+	require.Equal("clock", e.Command())
+	require.Equal("4.9.0", e.Version())
+	require.ErrorIs(err, ErrRootNotWatched)
+	require.NotErrorIs(err, ErrInvalidQuery)
+
+	_, err = c.Recv()
+	require.True(errors.As(err, &e))
+	require.Equal("query", e.Command())
+	require.Equal("Spoon!", e.PDU()["hint"])
+	require.ErrorIs(err, ErrInvalidQuery)
+}
+
+func TestWatchmanErrorClass(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		msg   string
+		class error
+	}{
+		{"unable to resolve root /nope: directory /nope is not watched", ErrRootNotWatched},
+		{"synchronization failed: sync_timeout expired", ErrSyncTimeout},
+		{"timed out waiting for cookie file to be observed by watcher", ErrSyncTimeout},
+		{"unknown command spoon", ErrUnknownCommand},
+		{"failed to parse query: must be an object", ErrInvalidQuery},
+		{"unknown expression term 'spoon'", ErrInvalidQuery},
+		{"unable to resolve root /root: open(/root): Permission denied", ErrPermissionDenied},
+		{"inotify-add-watch(/src) -> The user limit on the total number of inotify watches was reached", ErrTooManyFiles},
+		{"opendir(/src): Too many open files", ErrTooManyFiles},
+		{"Spoon!", nil},
+	} {
+		err := newWatchmanError(ResponsePDU{"error": tc.msg}, "")
+		for _, class := range []error{
+			ErrRootNotWatched,
+			ErrSyncTimeout,
+			ErrUnknownCommand,
+			ErrInvalidQuery,
+			ErrPermissionDenied,
+			ErrTooManyFiles,
+		} {
+			require.Equal(class == tc.class, errors.Is(err, class), "%s is %v", tc.msg, class)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package protocol
+
+import (
+	"encoding/json"
+
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+/*
+{"name": "foo/main.go", "exists": true, "type": "f", "size": 1024,
+ "mtime_ms": 1531594843123, "content.sha1hex": "da39a3ee..."}
+{"name": "big.bin", "content.sha1hex": {"error": "file too big"}}
+"foo/main.go"
+*/
+
+// A File represents one entry of the files list returned by the
+// query, since, and find commands, or by a subscription.
+//
+// Only the fields requested by the query are populated.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/query.html#available-fields
+type File struct {
+	Name   string
+	Exists bool
+	New    bool
+	Type   string
+	Size   int64
+	Mode   uint32
+	UID    uint32
+	GID    uint32
+	Ino    uint64
+	Dev    uint64
+	Nlink  uint64
+	CClock string
+	OClock string
+
+	Ctime   int64
+	CtimeMs int64
+	CtimeUs int64
+	CtimeNs int64
+	CtimeF  float64
+	Mtime   int64
+	MtimeMs int64
+	MtimeUs int64
+	MtimeNs int64
+	MtimeF  float64
+
+	SymlinkTarget string
+
+	// ContentSHA1Hex is empty when ContentSHA1HexError is set, for
+	// example because the file is a directory or could not be read.
+	ContentSHA1Hex      string
+	ContentSHA1HexError string
+}
+
+// NewFiles converts the files list of a ResponsePDU to a list of File.
+//
+// When a query requests a single field, Watchman returns a list of bare
+// values instead of a list of objects. fields identifies the requested
+// field in that case; if fields is empty, bare values are assumed to be
+// names, since name is the default field.
+func NewFiles(files []interface{}, fields query.Fields) []File {
+	if files == nil {
+		return nil
+	}
+
+	field := query.FName
+	if len(fields) == 1 {
+		field = fields[0]
+	}
+
+	res := make([]File, len(files))
+	for i, x := range files {
+		if m, ok := x.(map[string]interface{}); ok {
+			for k, v := range m {
+				res[i].set(query.Field(k), v)
+			}
+		} else {
+			res[i].set(field, x)
+		}
+	}
+	return res
+}
+
+// QueryFile returns the metadata of f that query.Evaluate matches
+// expression terms against.
+func (f *File) QueryFile() query.File {
+	return query.File{
+		Name:   f.Name,
+		Exists: f.Exists,
+		Type:   query.TFileType(f.Type),
+		Size:   f.Size,
+		Mtime:  f.Mtime,
+		Ctime:  f.Ctime,
+		OClock: f.OClock,
+		CClock: f.CClock,
+	}
+}
+
+func (f *File) set(field query.Field, x interface{}) {
+	switch field {
+	case query.FName:
+		setString(&f.Name, x)
+	case query.FExists:
+		setBool(&f.Exists, x)
+	case query.FNew:
+		setBool(&f.New, x)
+	case query.FType:
+		setString(&f.Type, x)
+	case query.FSize:
+		setInt(&f.Size, x)
+	case query.FMode:
+		f.Mode = uint32(toInt(x))
+	case query.FUid:
+		f.UID = uint32(toInt(x))
+	case query.FGid:
+		f.GID = uint32(toInt(x))
+	case query.FIno:
+		f.Ino = uint64(toInt(x))
+	case query.FDev:
+		f.Dev = uint64(toInt(x))
+	case query.FNlink:
+		f.Nlink = uint64(toInt(x))
+	case query.FCclock:
+		setString(&f.CClock, x)
+	case query.FOclock:
+		setString(&f.OClock, x)
+	case query.FCtime:
+		setInt(&f.Ctime, x)
+	case query.FCtimeMs:
+		setInt(&f.CtimeMs, x)
+	case query.FCtimeUs:
+		setInt(&f.CtimeUs, x)
+	case query.FCtimeNs:
+		setInt(&f.CtimeNs, x)
+	case query.FCtimeF:
+		setFloat(&f.CtimeF, x)
+	case query.FMtime:
+		setInt(&f.Mtime, x)
+	case query.FMtimeMs:
+		setInt(&f.MtimeMs, x)
+	case query.FMtimeUs:
+		setInt(&f.MtimeUs, x)
+	case query.FMtimeNs:
+		setInt(&f.MtimeNs, x)
+	case query.FMtimeF:
+		setFloat(&f.MtimeF, x)
+	case query.FSymlinkTarget:
+		setString(&f.SymlinkTarget, x)
+	case query.FContentSha1hex:
+		if m, ok := x.(map[string]interface{}); ok {
+			setString(&f.ContentSHA1HexError, m["error"])
+		} else {
+			setString(&f.ContentSHA1Hex, x)
+		}
+	}
+}
+
+func setBool(dst *bool, x interface{}) {
+	if b, ok := x.(bool); ok {
+		*dst = b
+	}
+}
+
+func setString(dst *string, x interface{}) {
+	if s, ok := x.(string); ok {
+		*dst = s
+	}
+}
+
+func setInt(dst *int64, x interface{}) {
+	*dst = toInt(x)
+}
+
+func setFloat(dst *float64, x interface{}) {
+	switch n := x.(type) {
+	case float64:
+		*dst = n
+	case int64:
+		*dst = float64(n)
+	case json.Number:
+		*dst, _ = n.Float64()
+	}
+}
+
+// toInt converts numbers decoded from either JSON (float64) or
+// BSER (int64) to int64.
+func toInt(x interface{}) int64 {
+	switch n := x.(type) {
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	case int:
+		return int64(n)
+	case json.Number:
+		i, _ := n.Int64()
+		return i
+	}
+	return 0
+}
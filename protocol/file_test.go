@@ -0,0 +1,135 @@
+package protocol
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+func TestNewFiles(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		files    []interface{}
+		fields   query.Fields
+		expected []File
+	}{
+		{
+			files:    nil,
+			expected: nil,
+		},
+		{
+			files:    []interface{}{"foo", "bar/baz"},
+			expected: []File{{Name: "foo"}, {Name: "bar/baz"}},
+		},
+		{
+			files:    []interface{}{"foo", "bar/baz"},
+			fields:   query.Fields{query.FName},
+			expected: []File{{Name: "foo"}, {Name: "bar/baz"}},
+		},
+		{
+			files:    []interface{}{float64(12), int64(34)},
+			fields:   query.Fields{query.FSize},
+			expected: []File{{Size: 12}, {Size: 34}},
+		},
+		{
+			// JSON decoded numbers
+			files: []interface{}{
+				map[string]interface{}{
+					"name":            "foo/main.go",
+					"exists":          true,
+					"new":             true,
+					"type":            "f",
+					"size":            float64(1024),
+					"mode":            float64(33188),
+					"uid":             float64(501),
+					"gid":             float64(20),
+					"ino":             float64(12345678),
+					"dev":             float64(16777220),
+					"nlink":           float64(1),
+					"cclock":          "c:1531594843:978:9:1",
+					"oclock":          "c:1531594843:978:9:2",
+					"ctime":           float64(1531594843),
+					"ctime_ms":        float64(1531594843123),
+					"ctime_us":        float64(1531594843123456),
+					"ctime_ns":        float64(1531594843123456000),
+					"ctime_f":         1531594843.123,
+					"mtime":           float64(1531594844),
+					"mtime_ms":        float64(1531594844123),
+					"mtime_us":        float64(1531594844123456),
+					"mtime_ns":        float64(1531594844123456000),
+					"mtime_f":         1531594844.123,
+					"symlink_target":  "../bar",
+					"content.sha1hex": "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+					"unknown":         "ignored",
+				},
+			},
+			expected: []File{{
+				Name:           "foo/main.go",
+				Exists:         true,
+				New:            true,
+				Type:           "f",
+				Size:           1024,
+				Mode:           33188,
+				UID:            501,
+				GID:            20,
+				Ino:            12345678,
+				Dev:            16777220,
+				Nlink:          1,
+				CClock:         "c:1531594843:978:9:1",
+				OClock:         "c:1531594843:978:9:2",
+				Ctime:          1531594843,
+				CtimeMs:        1531594843123,
+				CtimeUs:        1531594843123456,
+				CtimeNs:        1531594843123456000,
+				CtimeF:         1531594843.123,
+				Mtime:          1531594844,
+				MtimeMs:        1531594844123,
+				MtimeUs:        1531594844123456,
+				MtimeNs:        1531594844123456000,
+				MtimeF:         1531594844.123,
+				SymlinkTarget:  "../bar",
+				ContentSHA1Hex: "da39a3ee5e6b4b0d3255bfef95601890afd80709",
+			}},
+		},
+		{
+			// BSER decoded numbers
+			files: []interface{}{
+				map[string]interface{}{
+					"name":    "big.bin",
+					"size":    int64(1 << 40),
+					"mtime_f": int64(1531594844),
+					"content.sha1hex": map[string]interface{}{
+						"error": "file too big",
+					},
+				},
+			},
+			expected: []File{{
+				Name:                "big.bin",
+				Size:                1 << 40,
+				MtimeF:              1531594844,
+				ContentSHA1HexError: "file too big",
+			}},
+		},
+	} {
+		require.Equal(tc.expected, NewFiles(tc.files, tc.fields))
+	}
+}
+
+func TestFileQueryFile(t *testing.T) {
+	require := require.New(t)
+
+	f := File{Name: "lib/a.go", Exists: true, Type: "f", Size: 3, Mtime: 10, OClock: "c:1:2:3:4"}
+	qf := f.QueryFile()
+	require.Equal(query.File{
+		Name:   "lib/a.go",
+		Exists: true,
+		Type:   query.TFileRegular,
+		Size:   3,
+		Mtime:  10,
+		OClock: "c:1:2:3:4",
+	}, qf)
+	require.True(query.Evaluate(query.TAllof{query.TFileRegular, query.TDirname{Name: "lib"}}, qf))
+}
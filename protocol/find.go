@@ -0,0 +1,68 @@
+package protocol
+
+/*
+["find","/tmp","*.go"]
+{"version":"4.9.0",
+ "clock":"c:1531594843:978:9:826",
+ "files":[{
+  "name":"foo/main.go",
+  "exists":true,
+  "new":false,
+  "size":1024,
+  "mode":33188
+ }]}
+*/
+
+// A FindRequest represents the Watchman find command. Patterns
+// optionally restrict the results to files whose path, relative to the
+// root, matches one of the wildmatch patterns.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/find.html
+type FindRequest struct {
+	Root     string
+	Patterns []string
+}
+
+// Args returns values used to encode a request PDU.
+func (req *FindRequest) Args() []interface{} {
+	args := []interface{}{"find", req.Root}
+	for _, pattern := range req.Patterns {
+		args = append(args, pattern)
+	}
+	return args
+}
+
+// A FindResponse represents a response to the Watchman find command.
+type FindResponse struct {
+	response
+	clock string
+	files []File
+}
+
+// NewFindResponse converts a ResponsePDU to FindResponse
+func NewFindResponse(pdu ResponsePDU) (res *FindResponse) {
+	res = &FindResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["clock"]; ok {
+		if clock, ok := x.(string); ok {
+			res.clock = clock
+		}
+	}
+	if x, ok := pdu["files"]; ok {
+		if files, ok := x.([]interface{}); ok {
+			res.files = NewFiles(files, nil)
+		}
+	}
+	return
+}
+
+// Clock returns a value representing when the files were found.
+func (res *FindResponse) Clock() string {
+	return res.clock
+}
+
+// Files returns the existing files that match the patterns.
+func (res *FindResponse) Files() []File {
+	return res.files
+}
@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFind(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *FindRequest
+		res      *FindResponse
+	}{
+		{
+			request:  `["find","/tmp"]` + "\n",
+			response: `{"clock":"c:1531594843:978:9:826","files":[],"version":"4.9.0"}` + "\n",
+			req:      &FindRequest{Root: "/tmp"},
+			res: &FindResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version": "4.9.0",
+						"clock":   "c:1531594843:978:9:826",
+						"files":   []interface{}{},
+					},
+					version: "4.9.0",
+				},
+				clock: "c:1531594843:978:9:826",
+				files: []File{},
+			},
+		},
+		{
+			request: `["find","/tmp","*.go","lib/**"]` + "\n",
+			response: `{"clock":"c:1531594843:978:9:826",` +
+				`"files":[{"name":"foo/main.go","exists":true,"size":1024}],` +
+				`"version":"4.9.0"}` + "\n",
+			req: &FindRequest{
+				Root:     "/tmp",
+				Patterns: []string{"*.go", "lib/**"},
+			},
+			res: &FindResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version": "4.9.0",
+						"clock":   "c:1531594843:978:9:826",
+						"files": []interface{}{
+							map[string]interface{}{
+								"name":   "foo/main.go",
+								"exists": true,
+								"size":   float64(1024),
+							},
+						},
+					},
+					version: "4.9.0",
+				},
+				clock: "c:1531594843:978:9:826",
+				files: []File{
+					{Name: "foo/main.go", Exists: true, Size: 1024},
+				},
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewFindResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal("c:1531594843:978:9:826", actual.Clock())
+		require.Equal(tc.res.files, actual.Files())
+	}
+}
@@ -0,0 +1,81 @@
+package protocol
+
+/*
+["flush-subscriptions","/tmp",{"sync_timeout":1000,"subscriptions":["sub1","sub2"]}]
+{"version":"4.9.0","synced":["sub1"],"no_sync_needed":["sub2"],"dropped":[]}
+*/
+
+// A FlushSubscriptionsRequest represents the Watchman flush-subscriptions
+// command, which delivers the pending notifications of subscriptions on
+// the connection before responding. Subscriptions lists the names of the
+// subscriptions to flush; if it is nil, every subscription of the
+// connection to the root is flushed. SyncTimeout is in milliseconds.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/flush-subscriptions.html
+type FlushSubscriptionsRequest struct {
+	Root          string
+	SyncTimeout   int
+	Subscriptions []string
+}
+
+// Args returns values used to encode a request PDU.
+func (req *FlushSubscriptionsRequest) Args() []interface{} {
+	opts := map[string]interface{}{"sync_timeout": req.SyncTimeout}
+	if req.Subscriptions != nil {
+		opts["subscriptions"] = req.Subscriptions
+	}
+	return []interface{}{"flush-subscriptions", req.Root, opts}
+}
+
+// A FlushSubscriptionsResponse represents a response to the Watchman
+// flush-subscriptions command.
+type FlushSubscriptionsResponse struct {
+	response
+	synced       []string
+	noSyncNeeded []string
+	dropped      []string
+}
+
+// NewFlushSubscriptionsResponse converts a ResponsePDU to
+// FlushSubscriptionsResponse
+func NewFlushSubscriptionsResponse(pdu ResponsePDU) (res *FlushSubscriptionsResponse) {
+	res = &FlushSubscriptionsResponse{}
+	res.response.init(pdu)
+
+	res.synced = stringList(pdu["synced"])
+	res.noSyncNeeded = stringList(pdu["no_sync_needed"])
+	res.dropped = stringList(pdu["dropped"])
+	return
+}
+
+func stringList(x interface{}) []string {
+	list, ok := x.([]interface{})
+	if !ok {
+		return nil
+	}
+	res := make([]string, 0, len(list))
+	for _, s := range list {
+		if s, ok := s.(string); ok {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// Synced returns the subscriptions whose pending notifications were
+// sent before the response.
+func (res *FlushSubscriptionsResponse) Synced() []string {
+	return res.synced
+}
+
+// NoSyncNeeded returns the subscriptions that had no pending
+// notifications.
+func (res *FlushSubscriptionsResponse) NoSyncNeeded() []string {
+	return res.noSyncNeeded
+}
+
+// Dropped returns the subscriptions whose pending notifications were
+// dropped, because of a state asserted with the drop option.
+func (res *FlushSubscriptionsResponse) Dropped() []string {
+	return res.dropped
+}
@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlushSubscriptions(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *FlushSubscriptionsRequest
+		res      *FlushSubscriptionsResponse
+	}{
+		{
+			request:  `["flush-subscriptions","/tmp",{"subscriptions":["sub1","sub2","sub3"],"sync_timeout":1000}]` + "\n",
+			response: `{"version":"4.9.0","synced":["sub1"],"no_sync_needed":["sub2"],"dropped":["sub3"]}` + "\n",
+			req: &FlushSubscriptionsRequest{
+				Root:          "/tmp",
+				SyncTimeout:   1000,
+				Subscriptions: []string{"sub1", "sub2", "sub3"},
+			},
+			res: &FlushSubscriptionsResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":        "4.9.0",
+						"synced":         []interface{}{"sub1"},
+						"no_sync_needed": []interface{}{"sub2"},
+						"dropped":        []interface{}{"sub3"},
+					},
+					version: "4.9.0",
+				},
+				synced:       []string{"sub1"},
+				noSyncNeeded: []string{"sub2"},
+				dropped:      []string{"sub3"},
+			},
+		},
+		{
+			request:  `["flush-subscriptions","/tmp",{"sync_timeout":0}]` + "\n",
+			response: `{"version":"4.9.0","synced":[],"no_sync_needed":[],"dropped":[]}` + "\n",
+			req:      &FlushSubscriptionsRequest{Root: "/tmp"},
+			res: &FlushSubscriptionsResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":        "4.9.0",
+						"synced":         []interface{}{},
+						"no_sync_needed": []interface{}{},
+						"dropped":        []interface{}{},
+					},
+					version: "4.9.0",
+				},
+				synced:       []string{},
+				noSyncNeeded: []string{},
+				dropped:      []string{},
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewFlushSubscriptionsResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+	}
+}
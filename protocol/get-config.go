@@ -0,0 +1,45 @@
+package protocol
+
+/*
+["get-config","/tmp"]
+{"version":"4.9.0","config":{"ignore_dirs":["node_modules"],"settle":20}}
+*/
+
+// A GetConfigRequest represents the Watchman get-config command, which
+// returns the contents of the .watchmanconfig file of a watched root.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/get-config.html
+type GetConfigRequest struct {
+	Root string
+}
+
+// Args returns values used to encode a request PDU.
+func (req *GetConfigRequest) Args() []interface{} {
+	return []interface{}{"get-config", req.Root}
+}
+
+// A GetConfigResponse represents a response to the Watchman get-config
+// command.
+type GetConfigResponse struct {
+	response
+	config map[string]interface{}
+}
+
+// NewGetConfigResponse converts a ResponsePDU to GetConfigResponse
+func NewGetConfigResponse(pdu ResponsePDU) (res *GetConfigResponse) {
+	res = &GetConfigResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["config"]; ok {
+		if config, ok := x.(map[string]interface{}); ok {
+			res.config = config
+		}
+	}
+	return
+}
+
+// Config returns the configuration of the root, decoded to primitive Go
+// values. It is empty if the root has no .watchmanconfig file.
+func (res *GetConfigResponse) Config() map[string]interface{} {
+	return res.config
+}
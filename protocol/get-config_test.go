@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetConfig(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *GetConfigRequest
+		res      *GetConfigResponse
+	}{
+		{
+			request:  `["get-config","/tmp"]` + "\n",
+			response: `{"config":{},"version":"4.9.0"}` + "\n",
+			req:      &GetConfigRequest{Root: "/tmp"},
+			res: &GetConfigResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version": "4.9.0",
+						"config":  map[string]interface{}{},
+					},
+					version: "4.9.0",
+				},
+				config: map[string]interface{}{},
+			},
+		},
+		{
+			request: `["get-config","/tmp"]` + "\n",
+			response: `{"config":{"ignore_dirs":["node_modules"],"settle":20},` +
+				`"version":"4.9.0"}` + "\n",
+			req: &GetConfigRequest{Root: "/tmp"},
+			res: &GetConfigResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version": "4.9.0",
+						"config": map[string]interface{}{
+							"ignore_dirs": []interface{}{"node_modules"},
+							"settle":      float64(20),
+						},
+					},
+					version: "4.9.0",
+				},
+				config: map[string]interface{}{
+					"ignore_dirs": []interface{}{"node_modules"},
+					"settle":      float64(20),
+				},
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewGetConfigResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal(tc.res.config, actual.Config())
+	}
+}
@@ -0,0 +1,38 @@
+package protocol
+
+/*
+["get-pid"]
+{"pid":1234,"version":"4.9.0"}
+*/
+
+// A GetPidRequest represents the Watchman get-pid command.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/get-pid.html
+type GetPidRequest struct{}
+
+// Args returns values used to encode a request PDU.
+func (req *GetPidRequest) Args() []interface{} {
+	return []interface{}{"get-pid"}
+}
+
+// A GetPidResponse represents a response to the Watchman get-pid command.
+type GetPidResponse struct {
+	response
+	pid int
+}
+
+// NewGetPidResponse converts a ResponsePDU to GetPidResponse
+func NewGetPidResponse(pdu ResponsePDU) (res *GetPidResponse) {
+	res = &GetPidResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["pid"]; ok {
+		res.pid = int(toInt(x))
+	}
+	return
+}
+
+// Pid returns the process ID of the Watchman server.
+func (res *GetPidResponse) Pid() int {
+	return res.pid
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetPid(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *GetPidRequest
+		res      *GetPidResponse
+	}{
+		{
+			request:  `["get-pid"]` + "\n",
+			response: `{"pid":1234,"version":"4.9.0"}` + "\n",
+			req:      &GetPidRequest{},
+			res: &GetPidResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version": "4.9.0",
+						"pid":     float64(1234),
+					},
+					version: "4.9.0",
+				},
+				pid: 1234,
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewGetPidResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal(1234, actual.Pid())
+	}
+}
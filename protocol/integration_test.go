@@ -1,9 +1,13 @@
 package protocol_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 
@@ -77,6 +81,23 @@ func TestSendAndRecv(t *testing.T) {
 		require.NotEmpty(clock.Clock())
 	}
 
+	// query
+	err = c.Send(&protocol.QueryRequest{
+		Root: watchRoot,
+		Query: &query.Query{
+			Expression: query.ExistsT,
+			Fields:     query.Fields{query.FName},
+		},
+	})
+	require.NoError(err)
+
+	pdu, err = c.Recv()
+	require.NoError(err)
+	require.NotNil(pdu)
+	queryResult := protocol.NewQueryResponse(pdu)
+	require.NotEmpty(queryResult.Clock())
+	require.NotEmpty(queryResult.Files())
+
 	// subscribe
 	err = c.Send(&protocol.SubscribeRequest{
 		Root:  watchRoot,
@@ -139,7 +160,9 @@ func (req *VersionRequest) Args() []interface{} {
 func TestInvalidCommand(t *testing.T) {
 	require := require.New(t)
 
-	c, err := protocol.Connect()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	c, err := protocol.ConnectContext(ctx)
 	require.NoError(err)
 	require.NotEmpty(c.Version())
 
@@ -152,6 +175,7 @@ func TestInvalidCommand(t *testing.T) {
 	require.NotNil(err)
 	require.IsType(&protocol.WatchmanError{}, err)
 	require.NotEmpty(err.Error())
+	require.ErrorIs(err, protocol.ErrUnknownCommand)
 
 	// connection should still be valid
 	err = c.Send(&VersionRequest{})
@@ -164,3 +188,99 @@ func TestInvalidCommand(t *testing.T) {
 	err = c.Close()
 	require.NoError(err)
 }
+
+// TestQueryConformance checks the query.Evaluate conformance table
+// against the results of a real Watchman server.
+func TestQueryConformance(t *testing.T) {
+	require := require.New(t)
+
+	b, err := os.ReadFile("query/testdata/conformance.json")
+	require.NoError(err)
+	var table struct {
+		Files []struct {
+			Name   string
+			Type   string
+			Size   int
+			Mtime  int64
+			Target string
+		}
+		Tests []struct {
+			Term  json.RawMessage
+			Match []string
+		}
+	}
+	require.NoError(json.Unmarshal(b, &table))
+
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(err)
+	known := map[string]bool{}
+	for _, f := range table.Files {
+		known[f.Name] = true
+		name := filepath.Join(dir, filepath.FromSlash(f.Name))
+		switch f.Type {
+		case "d":
+			require.NoError(os.Mkdir(name, 0o755))
+		case "l":
+			require.NoError(os.Symlink(f.Target, name))
+		default:
+			require.NoError(os.WriteFile(name, bytes.Repeat([]byte("x"), f.Size), 0o644))
+			mtime := time.Unix(f.Mtime, 0)
+			require.NoError(os.Chtimes(name, mtime, mtime))
+		}
+	}
+
+	c, err := protocol.Connect()
+	require.NoError(err)
+	defer c.Close()
+
+	require.NoError(c.Send(&protocol.WatchProjectRequest{Path: dir}))
+	pdu, err := c.Recv()
+	require.NoError(err)
+	watch := protocol.NewWatchProjectResponse(pdu)
+	defer func() {
+		require.NoError(c.Send(&protocol.WatchDelRequest{Root: watch.Watch()}))
+		_, err := c.Recv()
+		require.NoError(err)
+	}()
+
+	for _, tc := range table.Tests {
+		term, err := query.ParseTerm(tc.Term)
+		require.NoError(err)
+		if name, ok := termName(tc.Term); ok && !c.HasCapability("term-"+name) {
+			continue
+		}
+
+		require.NoError(c.Send(&protocol.QueryRequest{
+			Root: watch.Watch(),
+			Query: &query.Query{
+				Expression:   term,
+				Fields:       query.Fields{query.FName},
+				RelativeRoot: watch.RelativePath(),
+			},
+		}))
+		pdu, err := c.Recv()
+		require.NoError(err, string(tc.Term))
+
+		match := []string{}
+		for _, f := range protocol.NewQueryResponse(pdu).Files() {
+			// skip cookie files
+			if known[f.Name] {
+				match = append(match, f.Name)
+			}
+		}
+		require.ElementsMatch(tc.Match, match, string(tc.Term))
+	}
+}
+
+// termName returns the name of an expression term in its JSON form.
+func termName(b json.RawMessage) (string, bool) {
+	var name string
+	if json.Unmarshal(b, &name) == nil {
+		return name, true
+	}
+	var term []json.RawMessage
+	if json.Unmarshal(b, &term) != nil || len(term) == 0 {
+		return "", false
+	}
+	return name, json.Unmarshal(term[0], &name) == nil
+}
@@ -0,0 +1,92 @@
+package protocol
+
+/*
+["log-level","debug"]
+{"log_level":"debug","version":"4.9.0"}
+{"unilateral":true,
+ "log":"2018-07-14T19:00:43,123: [client=0x7f] doctor: checking the server\n",
+ "level":"error",
+ "version":"4.9.0"}
+*/
+
+// A LogLevelRequest represents the Watchman log-level command. After it,
+// the Watchman server sends the log messages selected by Level to the
+// connection as unilateral PDUs; see NewLogMessage.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/log-level.html
+type LogLevelRequest struct {
+	Level LogLevel
+}
+
+// Args returns values used to encode a request PDU.
+func (req *LogLevelRequest) Args() []interface{} {
+	return []interface{}{"log-level", string(req.Level)}
+}
+
+// A LogLevelResponse represents a response to the Watchman log-level
+// command.
+type LogLevelResponse struct {
+	response
+	level LogLevel
+}
+
+// NewLogLevelResponse converts a ResponsePDU to LogLevelResponse
+func NewLogLevelResponse(pdu ResponsePDU) (res *LogLevelResponse) {
+	res = &LogLevelResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["log_level"]; ok {
+		if level, ok := x.(string); ok {
+			res.level = LogLevel(level)
+		}
+	}
+	return
+}
+
+// Level returns the log level of the connection.
+func (res *LogLevelResponse) Level() LogLevel {
+	return res.level
+}
+
+// A LogMessage represents a log message sent by the Watchman server
+// after the log-level command.
+type LogMessage struct {
+	response
+	level   LogLevel
+	message string
+}
+
+// IsLogMessage indicates if a unilateral PDU is a log message.
+func IsLogMessage(pdu ResponsePDU) bool {
+	_, ok := pdu["log"].(string)
+	return ok
+}
+
+// NewLogMessage converts a ResponsePDU to LogMessage
+func NewLogMessage(pdu ResponsePDU) (m *LogMessage) {
+	m = &LogMessage{}
+	m.response.init(pdu)
+
+	if x, ok := pdu["log"]; ok {
+		if message, ok := x.(string); ok {
+			m.message = message
+		}
+	}
+	if x, ok := pdu["level"]; ok {
+		if level, ok := x.(string); ok {
+			m.level = LogLevel(level)
+		}
+	}
+	return
+}
+
+// Level returns the level of the message, if the Watchman server
+// reports it.
+func (m *LogMessage) Level() LogLevel {
+	return m.level
+}
+
+// Message returns the log line, as formatted by the Watchman server.
+func (m *LogMessage) Message() string {
+	return m.message
+}
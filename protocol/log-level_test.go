@@ -0,0 +1,75 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogLevel(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *LogLevelRequest
+		res      *LogLevelResponse
+	}{
+		{
+			request:  `["log-level","debug"]` + "\n",
+			response: `{"log_level":"debug","version":"4.9.0"}` + "\n",
+			req:      &LogLevelRequest{Level: LogDebug},
+			res: &LogLevelResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":   "4.9.0",
+						"log_level": "debug",
+					},
+					version: "4.9.0",
+				},
+				level: LogDebug,
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewLogLevelResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal(LogDebug, actual.Level())
+	}
+}
+
+func TestNewLogMessage(t *testing.T) {
+	require := require.New(t)
+
+	pdu := ResponsePDU{
+		"unilateral": true,
+		"log":        "2018-07-14T19:00:43,123: [client=0x7f] checking\n",
+		"level":      "error",
+		"version":    "4.9.0",
+	}
+	require.True(IsLogMessage(pdu))
+	m := NewLogMessage(pdu)
+	require.Equal(LogError, m.Level())
+	require.Equal("2018-07-14T19:00:43,123: [client=0x7f] checking\n", m.Message())
+	require.Equal("4.9.0", m.Version())
+
+	require.False(IsLogMessage(ResponsePDU{"unilateral": true, "subscription": "sub1"}))
+}
@@ -0,0 +1,57 @@
+package protocol
+
+/*
+["log","error","doctor: checking the server"]
+{"logged":true,"version":"4.9.0"}
+*/
+
+// A LogLevel selects the messages written to the log of the Watchman
+// server, or sent to a client by the log-level command.
+type LogLevel string
+
+const (
+	// LogOff selects no messages.
+	LogOff LogLevel = "off"
+	// LogError selects error messages.
+	LogError LogLevel = "error"
+	// LogDebug selects error and debug messages.
+	LogDebug LogLevel = "debug"
+)
+
+// A LogRequest represents the Watchman log command, which writes a
+// message to the log of the Watchman server.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/log.html
+type LogRequest struct {
+	Level   LogLevel
+	Message string
+}
+
+// Args returns values used to encode a request PDU.
+func (req *LogRequest) Args() []interface{} {
+	return []interface{}{"log", string(req.Level), req.Message}
+}
+
+// A LogResponse represents a response to the Watchman log command.
+type LogResponse struct {
+	response
+	logged bool
+}
+
+// NewLogResponse converts a ResponsePDU to LogResponse
+func NewLogResponse(pdu ResponsePDU) (res *LogResponse) {
+	res = &LogResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["logged"]; ok {
+		if logged, ok := x.(bool); ok {
+			res.logged = logged
+		}
+	}
+	return
+}
+
+// Logged indicates if the message was written to the log.
+func (res *LogResponse) Logged() bool {
+	return res.logged
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLog(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *LogRequest
+		res      *LogResponse
+	}{
+		{
+			request:  `["log","error","doctor: checking the server"]` + "\n",
+			response: `{"logged":true,"version":"4.9.0"}` + "\n",
+			req:      &LogRequest{Level: LogError, Message: "doctor: checking the server"},
+			res: &LogResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version": "4.9.0",
+						"logged":  true,
+					},
+					version: "4.9.0",
+				},
+				logged: true,
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewLogResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.True(actual.Logged())
+	}
+}
@@ -0,0 +1,93 @@
+package protocol
+
+import "github.com/cdmistman/watchman/protocol/query"
+
+/*
+["query","/tmp",{"suffix":"go","fields":["name","exists"]}]
+{"version":"4.9.0",
+ "clock":"c:1531594843:978:9:826",
+ "is_fresh_instance":true,
+ "files":[{
+  "exists": true,
+  "name": "foo/main.go"
+ }]}
+*/
+
+// A QueryRequest represents the Watchman query command.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/query.html
+type QueryRequest struct {
+	Root  string
+	Query *query.Query
+}
+
+// Args returns values used to encode a request PDU.
+func (req *QueryRequest) Args() []interface{} {
+	if req.Query == nil {
+		return []interface{}{"query", req.Root, map[string]interface{}{}}
+	}
+	return []interface{}{"query", req.Root, req.Query}
+}
+
+// A QueryResponse represents a response to the Watchman query command.
+type QueryResponse struct {
+	response
+	clock           string
+	rawFiles        []interface{}
+	files           []File
+	isFreshInstance bool
+}
+
+// NewQueryResponse converts a ResponsePDU to QueryResponse
+func NewQueryResponse(pdu ResponsePDU) (res *QueryResponse) {
+	res = &QueryResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["clock"]; ok {
+		switch clock := x.(type) {
+		case string:
+			res.clock = clock
+		case map[string]interface{}:
+			// SCM aware queries return the clock inside an object
+			if clock, ok := clock["clock"].(string); ok {
+				res.clock = clock
+			}
+		}
+	}
+	if x, ok := pdu["files"]; ok {
+		if files, ok := x.([]interface{}); ok {
+			res.rawFiles = files
+			res.files = NewFiles(files, nil)
+		}
+	}
+	if x, ok := pdu["is_fresh_instance"]; ok {
+		if isFreshInstance, ok := x.(bool); ok {
+			res.isFreshInstance = isFreshInstance
+		}
+	}
+	return
+}
+
+// Clock returns a value representing when the query was evaluated.
+func (res *QueryResponse) Clock() string {
+	return res.clock
+}
+
+// Files returns the files matched by the query. If the query requested
+// a single field other than name, use DecodeFiles instead.
+func (res *QueryResponse) Files() []File {
+	return res.files
+}
+
+// DecodeFiles returns the files matched by the query, given the fields
+// it requested. Unlike Files, it decodes the bare values that Watchman
+// returns when a single field other than name is requested.
+func (res *QueryResponse) DecodeFiles(fields query.Fields) []File {
+	return NewFiles(res.rawFiles, fields)
+}
+
+// IsFreshInstance indicates if the results include every matching
+// file, rather than only the files changed since the requested clock.
+func (res *QueryResponse) IsFreshInstance() bool {
+	return res.isFreshInstance
+}
@@ -0,0 +1,156 @@
+package query
+
+import "time"
+
+// A Builder builds a Query one clause at a time:
+//
+//	q := query.New().
+//		Since(clock).
+//		Suffix("go").
+//		Not(query.TDirname{Name: "vendor"}).
+//		Fields(query.FName, query.FExists).
+//		Query()
+//
+// The terms added by Where and its shorthands are combined with TAllof.
+type Builder struct {
+	q     Query
+	terms []Term
+}
+
+// New returns an empty Builder, which builds a query for every file.
+func New() *Builder {
+	return &Builder{}
+}
+
+// Where restricts the query to files matching every one of terms.
+func (b *Builder) Where(terms ...Term) *Builder {
+	b.terms = append(b.terms, terms...)
+	return b
+}
+
+// Not excludes files matching any of terms from the query.
+func (b *Builder) Not(terms ...Term) *Builder {
+	if len(terms) == 1 {
+		return b.Where(TNot{terms[0]})
+	}
+	return b.Where(TNot{TAnyof(terms)})
+}
+
+// Suffix restricts the query to files with one of suffixes.
+func (b *Builder) Suffix(suffixes ...string) *Builder {
+	return b.Where(TSuffix(suffixes))
+}
+
+// Type restricts the query to files of type t.
+func (b *Builder) Type(t TFileType) *Builder {
+	return b.Where(t)
+}
+
+// Name restricts the query to files whose basename is one of names.
+func (b *Builder) Name(names ...string) *Builder {
+	return b.Where(TName{Names: names})
+}
+
+// Match restricts the query to files whose path relative to the root
+// matches glob, as with TMatch and MatchWholeName.
+func (b *Builder) Match(glob string) *Builder {
+	return b.Where(TMatch{Glob: glob, MatchType: MatchWholeName})
+}
+
+// Dirname restricts the query to files below dir, at any depth.
+func (b *Builder) Dirname(dir string) *Builder {
+	return b.Where(TDirname{Name: dir})
+}
+
+// Since generates the files changed since clock.
+func (b *Builder) Since(clock string) *Builder {
+	return b.Generator(GSince, clock)
+}
+
+// Glob generates the files matching patterns. Calls accumulate.
+func (b *Builder) Glob(patterns ...string) *Builder {
+	globs, _ := b.q.Generators[GGlob].([]string)
+	return b.Generator(GGlob, append(globs, patterns...))
+}
+
+// Path generates the files below paths, at any depth. Calls accumulate.
+func (b *Builder) Path(paths ...string) *Builder {
+	list, _ := b.q.Generators[GPath].([]any)
+	for _, p := range paths {
+		list = append(list, p)
+	}
+	return b.Generator(GPath, list)
+}
+
+// Generator sets the argument of generator g.
+func (b *Builder) Generator(g Generator, arg any) *Builder {
+	if b.q.Generators == nil {
+		b.q.Generators = Generators{}
+	}
+	b.q.Generators[g] = arg
+	return b
+}
+
+// Fields adds fields to the fields returned for each file.
+func (b *Builder) Fields(fields ...Field) *Builder {
+	b.q.Fields = append(b.q.Fields, fields...)
+	return b
+}
+
+// RelativeRoot evaluates the query relative to dir, a directory below
+// the root.
+func (b *Builder) RelativeRoot(dir string) *Builder {
+	b.q.RelativeRoot = dir
+	return b
+}
+
+// SyncTimeout sets how long the server waits to observe pending changes
+// before answering, with millisecond precision.
+func (b *Builder) SyncTimeout(d time.Duration) *Builder {
+	b.q.SyncTimeout = int(d.Milliseconds())
+	return b
+}
+
+// LockTimeout sets how long the server waits for the lock on the root,
+// with millisecond precision.
+func (b *Builder) LockTimeout(d time.Duration) *Builder {
+	b.q.LockTimeout = int(d.Milliseconds())
+	return b
+}
+
+// CaseInsensitive matches names case insensitively.
+func (b *Builder) CaseInsensitive() *Builder {
+	b.q.Case = CaseInsensitive
+	return b
+}
+
+// DedupResults removes duplicate files from the results of generators
+// that may produce a file more than once.
+func (b *Builder) DedupResults() *Builder {
+	b.q.DedupResults = true
+	return b
+}
+
+// Query returns the query built so far. Later calls to b do not modify
+// the returned query.
+func (b *Builder) Query() *Query {
+	q := b.q
+	if q.Generators != nil {
+		q.Generators = Generators{}
+		for g, arg := range b.q.Generators {
+			q.Generators[g] = arg
+		}
+	}
+	if q.Fields != nil {
+		q.Fields = append(Fields{}, b.q.Fields...)
+	}
+
+	switch len(b.terms) {
+	case 0:
+	case 1:
+		q.Expression = b.terms[0]
+	default:
+		q.Expression = append(TAllof(nil), b.terms...)
+	}
+	return &q
+}
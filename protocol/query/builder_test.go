@@ -0,0 +1,72 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder(t *testing.T) {
+	require := require.New(t)
+
+	require.Equal(&Query{}, New().Query())
+
+	b := New().
+		Since("c:1:2:3:4").
+		Suffix("go").
+		Not(TDirname{Name: "vendor"}).
+		Fields(FName, FExists)
+	q := b.Query()
+	require.Equal(&Query{
+		Generators: Generators{GSince: "c:1:2:3:4"},
+		Expression: TAllof{TSuffix{"go"}, TNot{TDirname{Name: "vendor"}}},
+		Fields:     Fields{FName, FExists},
+	}, q)
+
+	// the built query is not affected by later calls
+	b.Fields(FSize).Since("c:1:2:3:5")
+	require.Equal(Fields{FName, FExists}, q.Fields)
+	require.Equal("c:1:2:3:4", q.Generators[GSince])
+
+	q = New().
+		Glob("*.go").
+		Glob("*.c", "*.h").
+		Path("src").
+		Path("lib").
+		Type(TFileRegular).
+		Name("Makefile", "BUILD").
+		Match("src/**").
+		Dirname("src").
+		Where(ExistsT, TSize{Op: RelGt, Size: 0}).
+		Not(EmptyT, TSuffix{"o"}).
+		RelativeRoot("sub").
+		SyncTimeout(2 * time.Second).
+		LockTimeout(time.Second).
+		CaseInsensitive().
+		DedupResults().
+		Query()
+	require.Equal(&Query{
+		Generators: Generators{
+			GGlob: []string{"*.go", "*.c", "*.h"},
+			GPath: []any{"src", "lib"},
+		},
+		Expression: TAllof{
+			TFileRegular,
+			TName{Names: []string{"Makefile", "BUILD"}},
+			TMatch{Glob: "src/**", MatchType: MatchWholeName},
+			TDirname{Name: "src"},
+			ExistsT,
+			TSize{Op: RelGt, Size: 0},
+			TNot{TAnyof{EmptyT, TSuffix{"o"}}},
+		},
+		DedupResults: true,
+		RelativeRoot: "sub",
+		SyncTimeout:  2000,
+		LockTimeout:  1000,
+		Case:         CaseInsensitive,
+	}, q)
+	require.NoError(q.Validate(nil))
+
+	require.Equal(&Query{Expression: TrueT}, New().Where(TrueT).Query())
+}
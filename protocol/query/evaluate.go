@@ -0,0 +1,394 @@
+package query
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// A File holds the metadata of a file that Evaluate matches expression
+// terms against.
+type File struct {
+	// Name is the path of the file relative to the root, using "/" as
+	// the separator.
+	Name   string
+	Exists bool
+	Type   TFileType
+	Size   int64
+
+	// Mtime and Ctime are in seconds since the Unix epoch.
+	Mtime int64
+	Ctime int64
+
+	// OClock and CClock are the clocks at which the file last changed
+	// and was created.
+	OClock string
+	CClock string
+}
+
+// Evaluate reports whether f matches the expression term, as Watchman
+// would evaluate it against a root with case sensitive names.
+//
+// pcre and ipcre use Go regular expression syntax. since with an integer
+// Timestamp and OClock or CClock never matches, since File does not
+// record when the file was observed. Terms of unknown type, and terms
+// with an invalid glob or regular expression, never match.
+func Evaluate(term Term, f File) bool {
+	switch t := term.(type) {
+	case TTrue:
+		return true
+
+	case TFalse:
+		return false
+
+	case TExists:
+		return f.Exists
+
+	case TEmpty:
+		return f.Exists && (f.Type == TFileRegular || f.Type == TFileDir) && f.Size == 0
+
+	case TAllof:
+		for _, term := range t {
+			if !Evaluate(term, f) {
+				return false
+			}
+		}
+		return true
+
+	case TAnyof:
+		for _, term := range t {
+			if Evaluate(term, f) {
+				return true
+			}
+		}
+		return false
+
+	case TNot:
+		return !Evaluate(t.Not, f)
+
+	case TFileType:
+		return f.Type == t
+
+	case TSuffix:
+		ext := path.Ext(f.Name)
+		for _, suffix := range t {
+			if strings.EqualFold(ext, "."+suffix) {
+				return true
+			}
+		}
+		return false
+
+	case TName:
+		return matchName(t.Names, scoped(f.Name, t.MatchType), false)
+
+	case TIName:
+		return matchName(t.Names, scoped(f.Name, t.MatchType), true)
+
+	case TMatch:
+		return matchGlob(t.Glob, t.Flags, false, scoped(f.Name, t.MatchType))
+
+	case TIMatch:
+		return matchGlob(t.Glob, t.Flags, true, scoped(f.Name, t.MatchType))
+
+	case TPCRE:
+		return matchRegexp(t.Regexp, scoped(f.Name, t.MatchType))
+
+	case TIPCRE:
+		return matchRegexp("(?i)"+t.Regexp, scoped(f.Name, t.MatchType))
+
+	case TDirname:
+		return matchDirname(t.Name, f.Name, t.Op, t.Depth, false)
+
+	case TIDirname:
+		return matchDirname(t.Name, f.Name, t.Op, t.Depth, true)
+
+	case TSize:
+		return f.Exists && compare(f.Size, t.Op, int64(t.Size))
+
+	case TSince:
+		return since(t, f)
+	}
+	return false
+}
+
+// scoped returns the part of name that a term with the given match type
+// applies to.
+func scoped(name string, t TMatchType) string {
+	if t == MatchBaseName {
+		return path.Base(name)
+	}
+	return name
+}
+
+func matchName(names []string, name string, fold bool) bool {
+	for _, n := range names {
+		if n == name || fold && strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchDirname(dir, name string, op RelationalOp, depth int, fold bool) bool {
+	dir = strings.Trim(dir, "/")
+	if dir != "" {
+		if len(name) <= len(dir) || name[len(dir)] != '/' {
+			return false
+		}
+		prefix := name[:len(dir)]
+		if prefix != dir && !(fold && strings.EqualFold(prefix, dir)) {
+			return false
+		}
+		name = name[len(dir)+1:]
+	}
+	if op == RelLt && depth == 0 {
+		return true
+	}
+	return compare(int64(strings.Count(name, "/")), op, int64(depth))
+}
+
+func compare(x int64, op RelationalOp, y int64) bool {
+	switch op {
+	case RelLt:
+		return x < y
+	case RelLe:
+		return x <= y
+	case RelEq:
+		return x == y
+	case RelNe:
+		return x != y
+	case RelGt:
+		return x > y
+	case RelGe:
+		return x >= y
+	}
+	return false
+}
+
+func since(t TSince, f File) bool {
+	if clock, ok := t.Timestamp.(string); ok {
+		switch t.Source {
+		case OClock:
+			return newerClock(f.OClock, clock)
+		case CClock:
+			return newerClock(f.CClock, clock)
+		}
+		return false
+	}
+
+	timestamp, err := decodeInt(t.Timestamp, "")
+	if err != nil {
+		return false
+	}
+	switch t.Source {
+	case MTime:
+		return f.Mtime > int64(timestamp)
+	case CTime:
+		return f.Ctime > int64(timestamp)
+	}
+	return false
+}
+
+// newerClock reports whether clock is after since. Clocks look like
+// "c:start:pid:root:tick"; a clock of another Watchman instance or root
+// is always newer, as Watchman treats the query as a fresh instance.
+func newerClock(clock, since string) bool {
+	i, j := strings.LastIndexByte(clock, ':'), strings.LastIndexByte(since, ':')
+	if i < 0 || j < 0 || clock[:i] != since[:j] {
+		return true
+	}
+	a, err := strconv.ParseUint(clock[i+1:], 10, 64)
+	if err != nil {
+		return true
+	}
+	b, err := strconv.ParseUint(since[j+1:], 10, 64)
+	if err != nil {
+		return true
+	}
+	return a > b
+}
+
+// regexps caches the regular expressions of pcre terms. A nil value
+// records an invalid expression.
+var regexps sync.Map
+
+func matchRegexp(expr string, name string) bool {
+	re, ok := regexps.Load(expr)
+	if !ok {
+		compiled, _ := regexp.Compile(expr)
+		re, _ = regexps.LoadOrStore(expr, compiled)
+	}
+	return re.(*regexp.Regexp) != nil && re.(*regexp.Regexp).MatchString(name)
+}
+
+// matchGlob reports whether name matches a wildmatch pattern, as
+// Watchman does, and false if the pattern is invalid. "*", "?" and
+// bracket expressions do not match "/", and a "**" path component
+// matches any number of directories. Unless MatchIncludeDotFiles is
+// set, a path component may only start with "." if the pattern has a
+// literal "." there, and unless MatchNoEscape is set, "\\" escapes the
+// next character.
+func matchGlob(glob string, flags TMatchFlags, fold bool, name string) bool {
+	if fold {
+		glob, name = strings.ToLower(glob), strings.ToLower(name)
+	}
+	m := globMatcher{glob: glob, name: name, flags: flags}
+	return m.match(0, 0)
+}
+
+type globMatcher struct {
+	glob, name string
+	flags      TMatchFlags
+}
+
+// match reports whether the name from t matches the glob from p.
+func (m *globMatcher) match(p, t int) bool {
+	glob, name := m.glob, m.name
+	escape := m.flags&MatchNoEscape == 0
+	for p < len(glob) {
+		c := glob[p]
+		// "**" checks the path components it matches itself
+		if m.hidden(t) && c != '.' && c != '*' && !(c == '\\' && escape && strings.HasPrefix(glob[p+1:], ".")) {
+			return false
+		}
+
+		switch {
+		case c == '\\' && escape && p+1 < len(glob):
+			p++
+			if t == len(name) || name[t] != glob[p] {
+				return false
+			}
+			p, t = p+1, t+1
+
+		case c == '?':
+			if t == len(name) || name[t] == '/' {
+				return false
+			}
+			p, t = p+1, t+1
+
+		case c == '[':
+			if t == len(name) || name[t] == '/' {
+				return false
+			}
+			end, ok := m.bracket(p, name[t])
+			if !ok {
+				return false
+			}
+			p, t = end, t+1
+
+		case c == '*':
+			end := p
+			for end < len(glob) && glob[end] == '*' {
+				end++
+			}
+			if end-p > 1 && (p == 0 || glob[p-1] == '/') && (end == len(glob) || glob[end] == '/') {
+				return m.doubleStar(end, t)
+			}
+			if m.hidden(t) {
+				return false
+			}
+			// "*" matches any number of characters other than "/"
+			for ; ; t++ {
+				if m.match(end, t) {
+					return true
+				}
+				if t == len(name) || name[t] == '/' {
+					return false
+				}
+			}
+
+		default:
+			if t == len(name) || name[t] != c {
+				return false
+			}
+			p, t = p+1, t+1
+		}
+	}
+	return t == len(name)
+}
+
+// doubleStar reports whether the name from t matches a "**" path
+// component followed by the glob from p, which is either empty or
+// starts with "/".
+func (m *globMatcher) doubleStar(p, t int) bool {
+	name := m.name
+	if p == len(m.glob) {
+		// a trailing "**" matches everything below, except hidden paths
+		for ; t < len(name); t++ {
+			if m.hidden(t) {
+				return false
+			}
+		}
+		return true
+	}
+
+	// "**/" matches zero or more directories
+	p++
+	for {
+		if m.match(p, t) {
+			return true
+		}
+		if t == len(name) || m.hidden(t) {
+			return false
+		}
+		i := strings.IndexByte(name[t:], '/')
+		if i < 0 {
+			return false
+		}
+		t += i + 1
+	}
+}
+
+// hidden reports whether the name has a path component starting with
+// "." at t that wildcards may not match.
+func (m *globMatcher) hidden(t int) bool {
+	return m.flags&MatchIncludeDotFiles == 0 &&
+		t < len(m.name) && m.name[t] == '.' &&
+		(t == 0 || m.name[t-1] == '/')
+}
+
+// bracket matches c against the bracket expression at p, and returns
+// the end of the expression, or false if c does not match or the
+// expression is invalid. A leading "!" or "^" negates the expression,
+// and a "]" right after the opening bracket, or its negation, is
+// literal.
+func (m *globMatcher) bracket(p int, c byte) (int, bool) {
+	glob := m.glob
+	escape := m.flags&MatchNoEscape == 0
+	p++
+	negate := p < len(glob) && (glob[p] == '!' || glob[p] == '^')
+	if negate {
+		p++
+	}
+
+	matched := false
+	for first := true; ; first = false {
+		if p == len(glob) {
+			return 0, false
+		}
+		if glob[p] == ']' && !first {
+			break
+		}
+		lo := glob[p]
+		if lo == '\\' && escape && p+1 < len(glob) {
+			p++
+			lo = glob[p]
+		}
+		hi := lo
+		if p+2 < len(glob) && glob[p+1] == '-' && glob[p+2] != ']' {
+			p += 2
+			hi = glob[p]
+			if hi == '\\' && escape && p+1 < len(glob) {
+				p++
+				hi = glob[p]
+			}
+		}
+		if lo <= c && c <= hi {
+			matched = true
+		}
+		p++
+	}
+	return p + 1, matched != negate
+}
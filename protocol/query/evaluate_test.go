@@ -0,0 +1,83 @@
+package query
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// conformance is the table in testdata/conformance.json. The protocol
+// integration test runs the same table against a real Watchman server.
+type conformance struct {
+	Files []File
+	Tests []struct {
+		Term  json.RawMessage
+		Match []string
+	}
+}
+
+func TestEvaluateConformance(t *testing.T) {
+	require := require.New(t)
+
+	b, err := os.ReadFile("testdata/conformance.json")
+	require.NoError(err)
+	var table conformance
+	require.NoError(json.Unmarshal(b, &table))
+
+	for _, tc := range table.Tests {
+		term, err := ParseTerm(tc.Term)
+		require.NoError(err, string(tc.Term))
+
+		match := []string{}
+		for _, f := range table.Files {
+			f.Exists = true
+			if Evaluate(term, f) {
+				match = append(match, f.Name)
+			}
+		}
+		require.Equal(tc.Match, match, string(tc.Term))
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	require := require.New(t)
+
+	f := File{
+		Name:   "src/main.go",
+		Exists: true,
+		Type:   TFileRegular,
+		Mtime:  100,
+		Ctime:  50,
+		OClock: "c:1:2:3:10",
+		CClock: "c:1:2:3:5",
+	}
+	deleted := File{Name: "old", Type: TFileRegular}
+
+	for _, tc := range []struct {
+		term  Term
+		file  File
+		match bool
+	}{
+		{ExistsT, deleted, false},
+		{EmptyT, deleted, false},
+		{TSize{Op: RelEq, Size: 0}, deleted, false},
+		{TName{Names: []string{"old"}}, deleted, true},
+		{TDirname{Name: "", Op: RelEq, Depth: 1}, f, true},
+		{TDirname{Name: "/src/"}, f, true},
+		{TSince{Timestamp: "c:1:2:3:9"}, f, true},
+		{TSince{Timestamp: "c:1:2:3:10"}, f, false},
+		{TSince{Timestamp: "c:1:2:3:5", Source: CClock}, f, false},
+		{TSince{Timestamp: "c:1:2:3:4", Source: CClock}, f, true},
+		{TSince{Timestamp: "c:9:2:3:100"}, f, true},
+		{TSince{Timestamp: 99, Source: MTime}, f, true},
+		{TSince{Timestamp: int64(50), Source: CTime}, f, false},
+		{TSince{Timestamp: 10, Source: OClock}, f, false},
+		{TMatch{Glob: "[main.go"}, f, false},
+		{TPCRE{Regexp: "("}, f, false},
+		{nil, f, false},
+	} {
+		require.Equal(tc.match, Evaluate(tc.term, tc.file), "%#v", tc.term)
+	}
+}
@@ -53,6 +53,9 @@ func (op RelationalOp) MarshalJSON() ([]byte, error) {
 }
 
 // see https://facebook.github.io/watchman/docs/expr/dirname
+//
+// The depth of matching files below Name is compared to Depth with Op.
+// If Op and Depth are zero, which could never match, any depth matches.
 type TDirname struct {
 	Name  string
 	Op    RelationalOp
@@ -61,8 +64,8 @@ type TDirname struct {
 
 func (t TDirname) MarshalJSON() ([]byte, error) {
 	res := []any{"dirname", t.Name}
-	if t.Op != RelLe && t.Depth != 0 {
-		res = append(res, t.Depth)
+	if t.Op != RelLt || t.Depth != 0 {
+		res = append(res, []any{"depth", t.Op, t.Depth})
 	}
 	return json.Marshal(res)
 }
@@ -76,8 +79,8 @@ type TIDirname struct {
 
 func (t TIDirname) MarshalJSON() ([]byte, error) {
 	res := []any{"idirname", t.Name}
-	if t.Op != RelLe && t.Depth != 0 {
-		res = append(res, t.Depth)
+	if t.Op != RelLt || t.Depth != 0 {
+		res = append(res, []any{"depth", t.Op, t.Depth})
 	}
 	return json.Marshal(res)
 }
@@ -281,6 +284,21 @@ const (
 	MTime
 )
 
+func (s TClockSource) MarshalJSON() ([]byte, error) {
+	var str string
+	switch s {
+	case OClock:
+		str = "oclock"
+	case CClock:
+		str = "cclock"
+	case CTime:
+		str = "ctime"
+	case MTime:
+		str = "mtime"
+	}
+	return json.Marshal(str)
+}
+
 // see https://facebook.github.io/watchman/docs/expr/since
 type TSince struct {
 	Timestamp any
@@ -314,7 +332,7 @@ func (t TSuffix) MarshalJSON() ([]byte, error) {
 	if len(t) == 1 {
 		res = append(res, t[0])
 	} else {
-		res = append(res, t)
+		res = append(res, []string(t))
 	}
 	return json.Marshal(res)
 }
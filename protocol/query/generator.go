@@ -1,7 +1,7 @@
 package query
 
 import (
-	"strconv"
+	"encoding/json"
 )
 
 // See https://facebook.github.io/watchman/docs/file-query#generators
@@ -9,7 +9,7 @@ type Generator string
 
 const (
 	// See https://facebook.github.io/watchman/docs/file-query#since-generator
-	GSince Generator = "string"
+	GSince Generator = "since"
 	// See https://facebook.github.io/watchman/docs/file-query#suffix-generator
 	GSuffix Generator = "suffix"
 	// See https://facebook.github.io/watchman/docs/file-query#glob-generator
@@ -28,8 +28,8 @@ type GPathPath struct {
 
 func (p GPathPath) MarshalJSON() ([]byte, error) {
 	if p.Depth == -1 {
-		return []byte(`"` + p.Path + `"`), nil
+		return json.Marshal(p.Path)
 	}
 
-	return []byte(`["` + p.Path + `", ` + strconv.Itoa(p.Depth) + `]`), nil
+	return json.Marshal(map[string]any{"path": p.Path, "depth": p.Depth})
 }
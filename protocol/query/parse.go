@@ -0,0 +1,569 @@
+package query
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// A ParseError reports an invalid query or expression term.
+type ParseError struct {
+	// Path locates the invalid value, starting from "$" for the
+	// outermost value, with ".key" for the members of objects and
+	// "[i]" for the elements of arrays, as in "$.expression[2][1]".
+	Path string
+	Msg  string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("query: %s: %s", e.Path, e.Msg)
+}
+
+func parseError(path string, format string, args ...any) *ParseError {
+	return &ParseError{Path: path, Msg: fmt.Sprintf(format, args...)}
+}
+
+func index(path string, i int) string {
+	return path + "[" + strconv.Itoa(i) + "]"
+}
+
+// ParseTerm decodes an expression term from its JSON encoding, the
+// inverse of its MarshalJSON method.
+//
+// See also: https://facebook.github.io/watchman/docs/expr/allof
+func ParseTerm(b []byte) (Term, error) {
+	x, err := decodeJSON(b)
+	if err != nil {
+		return nil, err
+	}
+	return decodeTerm(x, "$")
+}
+
+// DecodeTerm converts an expression term, decoded to primitive Go values
+// from JSON or BSER, to a Term.
+func DecodeTerm(x any) (Term, error) {
+	return decodeTerm(x, "$")
+}
+
+// ParseQuery decodes a query from its JSON encoding, the inverse of
+// Query.MarshalJSON.
+func ParseQuery(b []byte) (*Query, error) {
+	q := &Query{}
+	if err := q.UnmarshalJSON(b); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// UnmarshalJSON decodes a query, failing with a *ParseError if it is not
+// valid.
+func (q *Query) UnmarshalJSON(b []byte) error {
+	x, err := decodeJSON(b)
+	if err != nil {
+		return err
+	}
+	spec, ok := x.(map[string]any)
+	if !ok {
+		return parseError("$", "expected a query object")
+	}
+
+	res := Query{}
+	for key, v := range spec {
+		path := "$." + key
+		switch key {
+		case string(GSince), string(GSuffix), string(GGlob), string(GPath):
+			arg, err := decodeGenerator(Generator(key), v, path)
+			if err != nil {
+				return err
+			}
+			if res.Generators == nil {
+				res.Generators = Generators{}
+			}
+			res.Generators[Generator(key)] = arg
+
+		case "expression":
+			if res.Expression, err = decodeTerm(v, path); err != nil {
+				return err
+			}
+
+		case "fields":
+			names, err := decodeStrings(v, path, false)
+			if err != nil {
+				return err
+			}
+			res.Fields = make(Fields, len(names))
+			for i, name := range names {
+				res.Fields[i] = Field(name)
+			}
+
+		case "dedup_results":
+			if res.DedupResults, err = decodeBool(v, path); err != nil {
+				return err
+			}
+
+		case "relative_root":
+			if res.RelativeRoot, err = decodeString(v, path); err != nil {
+				return err
+			}
+
+		case "sync_timeout":
+			if res.SyncTimeout, err = decodeInt(v, path); err != nil {
+				return err
+			}
+
+		case "lock_timeout":
+			if res.LockTimeout, err = decodeInt(v, path); err != nil {
+				return err
+			}
+
+		case "case_sensitive":
+			sensitive, err := decodeBool(v, path)
+			if err != nil {
+				return err
+			}
+			if !sensitive {
+				res.Case = CaseInsensitive
+			}
+
+		case "empty_on_fresh_instance":
+			if res.EmptyOnFreshInstance, err = decodeBool(v, path); err != nil {
+				return err
+			}
+
+		case "omit_changed_files":
+			if res.OmitChangedFiles, err = decodeBool(v, path); err != nil {
+				return err
+			}
+
+		case "always_include_directories":
+			if res.AlwaysIncludeDirectories, err = decodeBool(v, path); err != nil {
+				return err
+			}
+
+		default:
+			return parseError(path, "unknown query option")
+		}
+	}
+
+	*q = res
+	return nil
+}
+
+// decodeJSON decodes b to primitive Go values, keeping numbers as
+// json.Number so that integers are exact.
+func decodeJSON(b []byte) (any, error) {
+	var x any
+	if !json.Valid(b) {
+		// report the offset of the syntax error
+		return nil, json.Unmarshal(b, &x)
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(&x); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+func decodeGenerator(g Generator, x any, path string) (any, error) {
+	switch g {
+	case GSince:
+		if clock, ok := x.(string); ok {
+			return clock, nil
+		}
+		return decodeInt(x, path)
+
+	case GSuffix:
+		if suffix, ok := x.(string); ok {
+			return suffix, nil
+		}
+		return decodeStrings(x, path, false)
+
+	case GGlob:
+		return decodeStrings(x, path, false)
+	}
+
+	// the path generator
+	list, ok := x.([]any)
+	if !ok {
+		return nil, parseError(path, "expected an array of paths")
+	}
+	res := make([]any, len(list))
+	for i, p := range list {
+		switch p := p.(type) {
+		case string:
+			res[i] = p
+		case map[string]any:
+			name, err := decodeString(p["path"], index(path, i)+".path")
+			if err != nil {
+				return nil, err
+			}
+			depth, err := decodeInt(p["depth"], index(path, i)+".depth")
+			if err != nil {
+				return nil, err
+			}
+			res[i] = GPathPath{Path: name, Depth: depth}
+		default:
+			return nil, parseError(index(path, i), "expected a path or a {\"path\", \"depth\"} object")
+		}
+	}
+	return res, nil
+}
+
+func decodeTerm(x any, path string) (Term, error) {
+	if name, ok := x.(string); ok {
+		switch name {
+		case "true":
+			return TrueT, nil
+		case "false":
+			return FalseT, nil
+		case "empty":
+			return EmptyT, nil
+		case "exists":
+			return ExistsT, nil
+		}
+		return nil, parseError(path, "unknown expression term %q", name)
+	}
+
+	list, ok := x.([]any)
+	if !ok || len(list) == 0 {
+		return nil, parseError(path, "expected an array or string for an expression term")
+	}
+	name, ok := list[0].(string)
+	if !ok {
+		return nil, parseError(index(path, 0), "expected the name of an expression term")
+	}
+	args := list[1:]
+
+	// arg returns the path of the i-th argument
+	arg := func(i int) string {
+		return index(path, i+1)
+	}
+	nargs := func(min, max int) error {
+		if len(args) < min || len(args) > max {
+			if min == max {
+				return parseError(path, "%q expects %d arguments, got %d", name, min, len(args))
+			}
+			return parseError(path, "%q expects %d to %d arguments, got %d", name, min, max, len(args))
+		}
+		return nil
+	}
+
+	switch name {
+	case "true", "false", "empty", "exists":
+		if err := nargs(0, 0); err != nil {
+			return nil, err
+		}
+		return decodeTerm(name, path)
+
+	case "allof", "anyof":
+		terms := make([]Term, len(args))
+		for i, x := range args {
+			term, err := decodeTerm(x, arg(i))
+			if err != nil {
+				return nil, err
+			}
+			terms[i] = term
+		}
+		if name == "allof" {
+			return TAllof(terms), nil
+		}
+		return TAnyof(terms), nil
+
+	case "not":
+		if err := nargs(1, 1); err != nil {
+			return nil, err
+		}
+		term, err := decodeTerm(args[0], arg(0))
+		if err != nil {
+			return nil, err
+		}
+		return TNot{Not: term}, nil
+
+	case "match", "imatch":
+		if err := nargs(1, 3); err != nil {
+			return nil, err
+		}
+		glob, err := decodeString(args[0], arg(0))
+		if err != nil {
+			return nil, err
+		}
+		scope := MatchBaseName
+		if len(args) > 1 {
+			if scope, err = decodeMatchType(args[1], arg(1)); err != nil {
+				return nil, err
+			}
+		}
+		var flags TMatchFlags
+		if len(args) > 2 {
+			if flags, err = decodeMatchFlags(args[2], arg(2)); err != nil {
+				return nil, err
+			}
+		}
+		if name == "match" {
+			return TMatch{Glob: glob, MatchType: scope, Flags: flags}, nil
+		}
+		return TIMatch{Glob: glob, MatchType: scope, Flags: flags}, nil
+
+	case "name", "iname":
+		if err := nargs(1, 2); err != nil {
+			return nil, err
+		}
+		names, err := decodeStrings(args[0], arg(0), true)
+		if err != nil {
+			return nil, err
+		}
+		scope := MatchBaseName
+		if len(args) > 1 {
+			if scope, err = decodeMatchType(args[1], arg(1)); err != nil {
+				return nil, err
+			}
+		}
+		if name == "name" {
+			return TName{Names: names, MatchType: scope}, nil
+		}
+		return TIName{Names: names, MatchType: scope}, nil
+
+	case "pcre", "ipcre":
+		if err := nargs(1, 2); err != nil {
+			return nil, err
+		}
+		re, err := decodeString(args[0], arg(0))
+		if err != nil {
+			return nil, err
+		}
+		scope := MatchBaseName
+		if len(args) > 1 {
+			if scope, err = decodeMatchType(args[1], arg(1)); err != nil {
+				return nil, err
+			}
+		}
+		if name == "pcre" {
+			return TPCRE{Regexp: re, MatchType: scope}, nil
+		}
+		return TIPCRE{Regexp: re, MatchType: scope}, nil
+
+	case "since":
+		if err := nargs(1, 2); err != nil {
+			return nil, err
+		}
+		source := OClock
+		if len(args) > 1 {
+			s, err := decodeString(args[1], arg(1))
+			if err != nil {
+				return nil, err
+			}
+			var ok bool
+			if source, ok = clockSources[s]; !ok {
+				return nil, parseError(arg(1), "unknown field %q for \"since\"", s)
+			}
+		}
+		if clock, ok := args[0].(string); ok && (source == OClock || source == CClock) {
+			return TSince{Timestamp: clock, Source: source}, nil
+		}
+		timestamp, err := decodeInt(args[0], arg(0))
+		if err != nil {
+			return nil, err
+		}
+		return TSince{Timestamp: timestamp, Source: source}, nil
+
+	case "size":
+		if err := nargs(2, 2); err != nil {
+			return nil, err
+		}
+		op, err := decodeRelationalOp(args[0], arg(0))
+		if err != nil {
+			return nil, err
+		}
+		size, err := decodeInt(args[1], arg(1))
+		if err != nil {
+			return nil, err
+		}
+		return TSize{Op: op, Size: size}, nil
+
+	case "suffix":
+		if err := nargs(1, 1); err != nil {
+			return nil, err
+		}
+		suffixes, err := decodeStrings(args[0], arg(0), true)
+		if err != nil {
+			return nil, err
+		}
+		return TSuffix(suffixes), nil
+
+	case "type":
+		if err := nargs(1, 1); err != nil {
+			return nil, err
+		}
+		t, err := decodeString(args[0], arg(0))
+		if err != nil {
+			return nil, err
+		}
+		if !fileTypes[TFileType(t)] {
+			return nil, parseError(arg(0), "unknown file type %q", t)
+		}
+		return TFileType(t), nil
+
+	case "dirname", "idirname":
+		if err := nargs(1, 2); err != nil {
+			return nil, err
+		}
+		dir, err := decodeString(args[0], arg(0))
+		if err != nil {
+			return nil, err
+		}
+		var op RelationalOp
+		var depth int
+		if len(args) > 1 {
+			spec, ok := args[1].([]any)
+			if !ok || len(spec) != 3 || spec[0] != "depth" {
+				return nil, parseError(arg(1), "expected [\"depth\", op, value]")
+			}
+			if op, err = decodeRelationalOp(spec[1], index(arg(1), 1)); err != nil {
+				return nil, err
+			}
+			if depth, err = decodeInt(spec[2], index(arg(1), 2)); err != nil {
+				return nil, err
+			}
+		}
+		if name == "dirname" {
+			return TDirname{Name: dir, Op: op, Depth: depth}, nil
+		}
+		return TIDirname{Name: dir, Op: op, Depth: depth}, nil
+	}
+
+	return nil, parseError(index(path, 0), "unknown expression term %q", name)
+}
+
+var clockSources = map[string]TClockSource{
+	"oclock": OClock,
+	"cclock": CClock,
+	"ctime":  CTime,
+	"mtime":  MTime,
+}
+
+var fileTypes = map[TFileType]bool{
+	TFileBlock:       true,
+	TFileChar:        true,
+	TFileDir:         true,
+	TFileRegular:     true,
+	TFileFIFO:        true,
+	TFileLink:        true,
+	TFileSocket:      true,
+	TFileSolarisDoor: true,
+	TFileUnknown:     true,
+}
+
+func decodeMatchType(x any, path string) (TMatchType, error) {
+	switch x {
+	case "basename":
+		return MatchBaseName, nil
+	case "wholename":
+		return MatchWholeName, nil
+	}
+	return 0, parseError(path, "expected \"basename\" or \"wholename\"")
+}
+
+func decodeMatchFlags(x any, path string) (TMatchFlags, error) {
+	opts, ok := x.(map[string]any)
+	if !ok {
+		return 0, parseError(path, "expected an object")
+	}
+	var flags TMatchFlags
+	for key, v := range opts {
+		on, err := decodeBool(v, path+"."+key)
+		if err != nil {
+			return 0, err
+		}
+		var flag TMatchFlags
+		switch key {
+		case "includedotfiles":
+			flag = MatchIncludeDotFiles
+		case "noescape":
+			flag = MatchNoEscape
+		default:
+			return 0, parseError(path+"."+key, "unknown match option")
+		}
+		if on {
+			flags |= flag
+		}
+	}
+	return flags, nil
+}
+
+func decodeRelationalOp(x any, path string) (RelationalOp, error) {
+	for op, name := range relationalOpMap {
+		if x == name {
+			return op, nil
+		}
+	}
+	return 0, parseError(path, "unknown relational operator %v", x)
+}
+
+func decodeString(x any, path string) (string, error) {
+	s, ok := x.(string)
+	if !ok {
+		return "", parseError(path, "expected a string")
+	}
+	return s, nil
+}
+
+// decodeStrings decodes an array of strings, or a single string if
+// single is set.
+func decodeStrings(x any, path string, single bool) ([]string, error) {
+	if s, ok := x.(string); ok && single {
+		return []string{s}, nil
+	}
+	list, ok := x.([]any)
+	if !ok {
+		if single {
+			return nil, parseError(path, "expected a string or an array of strings")
+		}
+		return nil, parseError(path, "expected an array of strings")
+	}
+	res := make([]string, len(list))
+	for i, s := range list {
+		var err error
+		if res[i], err = decodeString(s, index(path, i)); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func decodeBool(x any, path string) (bool, error) {
+	b, ok := x.(bool)
+	if !ok {
+		return false, parseError(path, "expected a boolean")
+	}
+	return b, nil
+}
+
+// decodeInt decodes an integer decoded from JSON, as a json.Number or a
+// float64, or from BSER.
+func decodeInt(x any, path string) (int, error) {
+	switch n := x.(type) {
+	case json.Number:
+		i, err := strconv.ParseInt(string(n), 10, 0)
+		if err == nil {
+			return int(i), nil
+		}
+	case float64:
+		if n == math.Trunc(n) {
+			return int(n), nil
+		}
+	case int64:
+		return int(n), nil
+	case int32:
+		return int(n), nil
+	case int16:
+		return int(n), nil
+	case int8:
+		return int(n), nil
+	case int:
+		return n, nil
+	}
+	return 0, parseError(path, "expected an integer")
+}
@@ -0,0 +1,155 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var termTests = []struct {
+	json string
+	term Term
+}{
+	{`"true"`, TrueT},
+	{`"false"`, FalseT},
+	{`"empty"`, EmptyT},
+	{`"exists"`, ExistsT},
+	{`["allof",["type","f"],["not","empty"]]`, TAllof{TFileType("f"), TNot{EmptyT}}},
+	{`["anyof",["suffix","go"],["suffix",["c","h"]]]`, TAnyof{TSuffix{"go"}, TSuffix{"c", "h"}}},
+	{`["match","*.go"]`, TMatch{Glob: "*.go"}},
+	{`["match","src/**/*.go","wholename"]`, TMatch{Glob: "src/**/*.go", MatchType: MatchWholeName}},
+	{
+		`["imatch","*.GO","basename",{"includedotfiles":true,"noescape":true}]`,
+		TIMatch{Glob: "*.GO", Flags: MatchIncludeDotFiles | MatchNoEscape},
+	},
+	{`["name","Makefile"]`, TName{Names: []string{"Makefile"}}},
+	{`["iname",["a","b/c"],"wholename"]`, TIName{Names: []string{"a", "b/c"}, MatchType: MatchWholeName}},
+	{`["pcre","^a.*"]`, TPCRE{Regexp: "^a.*"}},
+	{`["ipcre","^A","wholename"]`, TIPCRE{Regexp: "^A", MatchType: MatchWholeName}},
+	{`["since","c:1:2:3:4"]`, TSince{Timestamp: "c:1:2:3:4"}},
+	{`["since","c:1:2:3:4","cclock"]`, TSince{Timestamp: "c:1:2:3:4", Source: CClock}},
+	{`["since",1384402349,"mtime"]`, TSince{Timestamp: 1384402349, Source: MTime}},
+	{`["size","gt",1024]`, TSize{Op: RelGt, Size: 1024}},
+	{`["dirname","src"]`, TDirname{Name: "src"}},
+	{`["idirname","SRC",["depth","ge",2]]`, TIDirname{Name: "SRC", Op: RelGe, Depth: 2}},
+}
+
+func TestParseTerm(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range termTests {
+		b, err := json.Marshal(tc.term)
+		require.NoError(err)
+		require.JSONEq(tc.json, string(b))
+
+		term, err := ParseTerm([]byte(tc.json))
+		require.NoError(err, tc.json)
+		require.Equal(tc.term, term, tc.json)
+	}
+
+	// the array form of nullary terms
+	term, err := ParseTerm([]byte(`["exists"]`))
+	require.NoError(err)
+	require.Equal(ExistsT, term)
+}
+
+func TestDecodeTerm(t *testing.T) {
+	require := require.New(t)
+
+	// BSER decodes integers to sized types
+	term, err := DecodeTerm([]interface{}{"size", "le", int8(3)})
+	require.NoError(err)
+	require.Equal(TSize{Op: RelLe, Size: 3}, term)
+
+	term, err = DecodeTerm([]interface{}{"since", float64(10), "ctime"})
+	require.NoError(err)
+	require.Equal(TSince{Timestamp: 10, Source: CTime}, term)
+}
+
+func TestParseTermErrors(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		json string
+		path string
+		msg  string
+	}{
+		{`"spoon"`, "$", `unknown expression term "spoon"`},
+		{`[]`, "$", "expected an array or string for an expression term"},
+		{`{}`, "$", "expected an array or string for an expression term"},
+		{`[1]`, "$[0]", "expected the name of an expression term"},
+		{`["spoon"]`, "$[0]", `unknown expression term "spoon"`},
+		{`["allof",["type","f"],["not"]]`, "$[2]", `"not" expects 1 arguments, got 0`},
+		{`["anyof",["allof",["match","*.go","fullname"]]]`, "$[1][1][2]", `expected "basename" or "wholename"`},
+		{`["match","*",{"hidden":true}]`, "$[2]", `expected "basename" or "wholename"`},
+		{`["match","*","basename",{"hidden":true}]`, "$[3].hidden", "unknown match option"},
+		{`["name",["a",1]]`, "$[1][1]", "expected a string"},
+		{`["since","c:1","mtime"]`, "$[1]", "expected an integer"},
+		{`["since","c:1","atime"]`, "$[2]", `unknown field "atime" for "since"`},
+		{`["size","big",1]`, "$[1]", "unknown relational operator big"},
+		{`["size","gt",1.5]`, "$[2]", "expected an integer"},
+		{`["type","x"]`, "$[1]", `unknown file type "x"`},
+		{`["dirname","src",2]`, "$[2]", `expected ["depth", op, value]`},
+		{`["true",1]`, "$", `"true" expects 0 arguments, got 1`},
+	} {
+		_, err := ParseTerm([]byte(tc.json))
+		require.Equal(&ParseError{Path: tc.path, Msg: tc.msg}, err, tc.json)
+	}
+
+	_, err := ParseTerm([]byte(`["true"`))
+	require.IsType(&json.SyntaxError{}, err)
+}
+
+func TestParseQuery(t *testing.T) {
+	require := require.New(t)
+
+	for _, test := range queryTests {
+		b, err := json.Marshal(test.query)
+		require.NoError(err)
+		q, err := ParseQuery(b)
+		require.NoError(err)
+		require.Equal(&test.query, q)
+	}
+
+	var q Query
+	err := json.Unmarshal([]byte(`{
+		"since": "c:1:2:3:4",
+		"path": ["src", {"path": "lib", "depth": 1}],
+		"glob": ["*.go"],
+		"expression": ["dirname", "lib"],
+		"fields": ["name", "size"],
+		"dedup_results": true,
+		"relative_root": "sub",
+		"lock_timeout": 100,
+		"case_sensitive": false,
+		"empty_on_fresh_instance": true,
+		"omit_changed_files": true,
+		"always_include_directories": true
+	}`), &q)
+	require.NoError(err)
+	require.Equal(Query{
+		Generators: Generators{
+			GSince: "c:1:2:3:4",
+			GPath:  []any{"src", GPathPath{Path: "lib", Depth: 1}},
+			GGlob:  []string{"*.go"},
+		},
+		Expression:   TDirname{Name: "lib"},
+		Fields:       Fields{FName, FSize},
+		DedupResults: true,
+		RelativeRoot: "sub",
+		LockTimeout:  100,
+		Case:         CaseInsensitive,
+
+		EmptyOnFreshInstance:     true,
+		OmitChangedFiles:         true,
+		AlwaysIncludeDirectories: true,
+	}, q)
+
+	_, err = ParseQuery([]byte(`{"expression": ["not", ["name", 1]]}`))
+	require.EqualError(err, "query: $.expression[1][1]: expected a string or an array of strings")
+	_, err = ParseQuery([]byte(`{"spoon": true}`))
+	require.EqualError(err, "query: $.spoon: unknown query option")
+	_, err = ParseQuery([]byte(`["name"]`))
+	require.EqualError(err, "query: $: expected a query object")
+}
@@ -11,6 +11,10 @@ type Query struct {
 	SyncTimeout  int
 	LockTimeout  int
 	Case         Case
+
+	EmptyOnFreshInstance     bool
+	OmitChangedFiles         bool
+	AlwaysIncludeDirectories bool
 }
 
 type Case int
@@ -55,5 +59,17 @@ func (q Query) MarshalJSON() ([]byte, error) {
 		res["case_sensitive"] = false
 	}
 
+	if q.EmptyOnFreshInstance {
+		res["empty_on_fresh_instance"] = true
+	}
+
+	if q.OmitChangedFiles {
+		res["omit_changed_files"] = true
+	}
+
+	if q.AlwaysIncludeDirectories {
+		res["always_include_directories"] = true
+	}
+
 	return json.Marshal(res)
 }
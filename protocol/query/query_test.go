@@ -64,6 +64,73 @@ var queryTests = []struct {
 			SyncTimeout: 60000,
 		},
 	},
+
+	{
+		expect: obj{"since": "c:1:2:3:4", "fields": []any{"name"}},
+		query: Query{
+			Generators: Generators{GSince: "c:1:2:3:4"},
+			Fields:     Fields{FName},
+		},
+	},
+
+	{
+		expect: obj{"expression": []any{"suffix", []any{"c", "h"}}},
+		query:  Query{Expression: TSuffix{"c", "h"}},
+	},
+
+	{
+		expect: obj{"expression": []any{
+			"anyof",
+			[]any{"dirname", "src"},
+			[]any{"dirname", "src", []any{"depth", "ge", float64(2)}},
+			[]any{"idirname", "SRC", []any{"depth", "eq", float64(0)}},
+		}},
+		query: Query{Expression: TAnyof{
+			TDirname{Name: "src"},
+			TDirname{Name: "src", Op: RelGe, Depth: 2},
+			TIDirname{Name: "SRC", Op: RelEq},
+		}},
+	},
+
+	{
+		expect: obj{"expression": []any{
+			"anyof",
+			[]any{"since", "c:1:2:3:4"},
+			[]any{"since", "c:1:2:3:4", "cclock"},
+			[]any{"since", float64(1384402349), "mtime"},
+			[]any{"since", float64(1384402349), "ctime"},
+		}},
+		query: Query{Expression: TAnyof{
+			TSince{Timestamp: "c:1:2:3:4"},
+			TSince{Timestamp: "c:1:2:3:4", Source: CClock},
+			TSince{Timestamp: 1384402349, Source: MTime},
+			TSince{Timestamp: 1384402349, Source: CTime},
+		}},
+	},
+
+	{
+		expect: obj{"path": []any{
+			"src",
+			map[string]any{"path": `a"b`, "depth": float64(0)},
+		}},
+		query: Query{Generators: Generators{GPath: []any{
+			"src",
+			GPathPath{Path: `a"b`, Depth: 0},
+		}}},
+	},
+
+	{
+		expect: obj{
+			"empty_on_fresh_instance":    true,
+			"omit_changed_files":         true,
+			"always_include_directories": true,
+		},
+		query: Query{
+			EmptyOnFreshInstance:     true,
+			OmitChangedFiles:         true,
+			AlwaysIncludeDirectories: true,
+		},
+	},
 }
 
 func TestQueries(t *testing.T) {
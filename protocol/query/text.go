@@ -0,0 +1,511 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The text form of an expression combines terms with "and", "or", "not"
+// and parentheses, in decreasing order of precedence "not", "and", "or":
+//
+//	type:f and suffix:go and not dirname:vendor and size > 1024
+//
+// Terms are written as follows, where a value is either a bare word,
+// which ends at a space or a parenthesis, or a Go quoted string:
+//
+//	true, false, exists, empty
+//	type:f
+//	suffix:go,c                   a comma separated list of values
+//	name:Makefile,README          also iname
+//	match:*.go                    also imatch
+//	pcre:^a.*                     also ipcre
+//	dirname:lib                   also idirname
+//	dirname:lib depth >= 1
+//	size > 1024                   with <, <=, =, !=, > or >=
+//	since:c:1:2:3:4               also since.cclock
+//	since.mtime:1384402349        also since.ctime
+//
+// The name, match and pcre terms match the basename of files unless the
+// "wholename" modifier is added, as in "match.wholename:src/**/*.go".
+// match also takes the "dotfiles" and "noescape" modifiers, which set
+// MatchIncludeDotFiles and MatchNoEscape.
+
+// A SyntaxError reports an invalid expression in text form.
+type SyntaxError struct {
+	// Offset is the byte offset of the error in the text.
+	Offset int
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("query: offset %d: %s", e.Offset, e.Msg)
+}
+
+// ParseExpr parses an expression term from its text form, the inverse
+// of FormatExpr.
+func ParseExpr(s string) (Term, error) {
+	p := &exprParser{s: s}
+	term, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.space(); p.pos < len(s) {
+		return nil, p.errorf("unexpected %q", s[p.pos:p.pos+1])
+	}
+	return term, nil
+}
+
+type exprParser struct {
+	s   string
+	pos int
+}
+
+func (p *exprParser) errorf(format string, args ...any) *SyntaxError {
+	return &SyntaxError{Offset: p.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) space() {
+	for p.pos < len(p.s) && isSpace(p.s[p.pos]) {
+		p.pos++
+	}
+}
+
+// word returns the keyword or term name at the current position,
+// without consuming it.
+func (p *exprParser) word() string {
+	p.space()
+	end := p.pos
+	for end < len(p.s) && (p.s[end] >= 'a' && p.s[end] <= 'z' || p.s[end] == '.') {
+		end++
+	}
+	return p.s[p.pos:end]
+}
+
+func (p *exprParser) or() (Term, error) {
+	return p.list("or", p.and, func(terms []Term) Term { return TAnyof(terms) })
+}
+
+func (p *exprParser) and() (Term, error) {
+	return p.list("and", p.not, func(terms []Term) Term { return TAllof(terms) })
+}
+
+// list parses operands separated by the keyword op.
+func (p *exprParser) list(op string, operand func() (Term, error), join func([]Term) Term) (Term, error) {
+	term, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	terms := []Term{term}
+	for p.word() == op {
+		p.pos += len(op)
+		if term, err = operand(); err != nil {
+			return nil, err
+		}
+		terms = append(terms, term)
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return join(terms), nil
+}
+
+func (p *exprParser) not() (Term, error) {
+	if p.word() != "not" {
+		return p.term()
+	}
+	p.pos += len("not")
+	term, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	return TNot{term}, nil
+}
+
+func (p *exprParser) term() (Term, error) {
+	if p.space(); p.pos < len(p.s) && p.s[p.pos] == '(' {
+		p.pos++
+		term, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.space(); p.pos >= len(p.s) || p.s[p.pos] != ')' {
+			return nil, p.errorf("expected )")
+		}
+		p.pos++
+		return term, nil
+	}
+
+	start := p.pos
+	word := p.word()
+	if word == "" {
+		return nil, p.errorf("expected an expression term")
+	}
+	p.pos += len(word)
+	name, mods, _ := strings.Cut(word, ".")
+
+	switch name {
+	case "true", "false", "exists", "empty", "size":
+		if mods != "" {
+			return nil, &SyntaxError{Offset: start, Msg: fmt.Sprintf("%q takes no modifiers", name)}
+		}
+		switch name {
+		case "true":
+			return TrueT, nil
+		case "false":
+			return FalseT, nil
+		case "exists":
+			return ExistsT, nil
+		case "empty":
+			return EmptyT, nil
+		}
+		op, err := p.op()
+		if err != nil {
+			return nil, err
+		}
+		size, err := p.int()
+		if err != nil {
+			return nil, err
+		}
+		return TSize{Op: op, Size: size}, nil
+
+	case "type", "suffix", "name", "iname", "match", "imatch", "pcre", "ipcre", "dirname", "idirname", "since":
+	default:
+		return nil, &SyntaxError{Offset: start, Msg: fmt.Sprintf("unknown expression term %q", name)}
+	}
+
+	// the modifiers each term accepts
+	scoped := name != "type" && name != "suffix" && name != "dirname" && name != "idirname" && name != "since"
+	glob := name == "match" || name == "imatch"
+	var matchType TMatchType
+	var flags TMatchFlags
+	source, sourced := OClock, false
+	if mods != "" {
+		for _, mod := range strings.Split(mods, ".") {
+			s, ok := clockSources[mod]
+			switch {
+			case mod == "wholename" && scoped:
+				matchType = MatchWholeName
+			case mod == "dotfiles" && glob:
+				flags |= MatchIncludeDotFiles
+			case mod == "noescape" && glob:
+				flags |= MatchNoEscape
+			case ok && name == "since" && !sourced:
+				source, sourced = s, true
+			default:
+				return nil, &SyntaxError{Offset: start, Msg: fmt.Sprintf("unknown modifier %q for %q", mod, name)}
+			}
+		}
+	}
+
+	if p.pos >= len(p.s) || p.s[p.pos] != ':' {
+		return nil, p.errorf("expected : after %q", word)
+	}
+	p.pos++
+	list := name == "suffix" || name == "name" || name == "iname"
+	values := []string{}
+	quoted := false
+	for {
+		value, q, err := p.value(list)
+		if err != nil {
+			return nil, err
+		}
+		values, quoted = append(values, value), q
+		if !list || p.pos >= len(p.s) || p.s[p.pos] != ',' {
+			break
+		}
+		p.pos++
+	}
+	value := values[0]
+
+	switch name {
+	case "type":
+		if !fileTypes[TFileType(value)] {
+			return nil, &SyntaxError{Offset: start, Msg: fmt.Sprintf("unknown file type %q", value)}
+		}
+		return TFileType(value), nil
+	case "suffix":
+		return TSuffix(values), nil
+	case "name":
+		return TName{Names: values, MatchType: matchType}, nil
+	case "iname":
+		return TIName{Names: values, MatchType: matchType}, nil
+	case "match":
+		return TMatch{Glob: value, MatchType: matchType, Flags: flags}, nil
+	case "imatch":
+		return TIMatch{Glob: value, MatchType: matchType, Flags: flags}, nil
+	case "pcre":
+		return TPCRE{Regexp: value, MatchType: matchType}, nil
+	case "ipcre":
+		return TIPCRE{Regexp: value, MatchType: matchType}, nil
+	case "since":
+		timestamp, err := strconv.Atoi(value)
+		if err == nil && !quoted {
+			return TSince{Timestamp: timestamp, Source: source}, nil
+		}
+		if source == CTime || source == MTime {
+			return nil, &SyntaxError{Offset: start, Msg: fmt.Sprintf("expected an integer timestamp, got %q", value)}
+		}
+		return TSince{Timestamp: value, Source: source}, nil
+	}
+
+	// dirname and idirname, with an optional depth
+	op, depth := RelLt, 0
+	if p.word() == "depth" {
+		p.pos += len("depth")
+		var err error
+		if op, err = p.op(); err != nil {
+			return nil, err
+		}
+		if depth, err = p.int(); err != nil {
+			return nil, err
+		}
+	}
+	if name == "idirname" {
+		return TIDirname{Name: value, Op: op, Depth: depth}, nil
+	}
+	return TDirname{Name: value, Op: op, Depth: depth}, nil
+}
+
+// value parses a bare or quoted value, reporting whether it was quoted.
+// Bare values in a list also end at a comma.
+func (p *exprParser) value(list bool) (string, bool, error) {
+	start := p.pos
+	if p.pos < len(p.s) && p.s[p.pos] == '"' {
+		for end := p.pos + 1; end < len(p.s); end++ {
+			switch p.s[end] {
+			case '\\':
+				end++
+			case '"':
+				s, err := strconv.Unquote(p.s[p.pos : end+1])
+				if err != nil {
+					return "", false, p.errorf("invalid quoted string")
+				}
+				p.pos = end + 1
+				return s, true, nil
+			}
+		}
+		return "", false, p.errorf("unterminated quoted string")
+	}
+
+	for p.pos < len(p.s) && !isSpace(p.s[p.pos]) && p.s[p.pos] != '(' && p.s[p.pos] != ')' &&
+		!(list && p.s[p.pos] == ',') {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", false, p.errorf("expected a value")
+	}
+	return p.s[start:p.pos], false, nil
+}
+
+var textOps = []struct {
+	text string
+	op   RelationalOp
+}{
+	// longer operators first
+	{"<=", RelLe},
+	{">=", RelGe},
+	{"!=", RelNe},
+	{"==", RelEq},
+	{"<", RelLt},
+	{">", RelGt},
+	{"=", RelEq},
+}
+
+func (p *exprParser) op() (RelationalOp, error) {
+	p.space()
+	for _, op := range textOps {
+		if strings.HasPrefix(p.s[p.pos:], op.text) {
+			p.pos += len(op.text)
+			return op.op, nil
+		}
+	}
+	return 0, p.errorf("expected a relational operator")
+}
+
+func (p *exprParser) int() (int, error) {
+	p.space()
+	start := p.pos
+	if p.pos < len(p.s) && p.s[p.pos] == '-' {
+		p.pos++
+	}
+	for p.pos < len(p.s) && p.s[p.pos] >= '0' && p.s[p.pos] <= '9' {
+		p.pos++
+	}
+	n, err := strconv.Atoi(p.s[start:p.pos])
+	if err != nil {
+		p.pos = start
+		return 0, p.errorf("expected an integer")
+	}
+	return n, nil
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// FormatExpr renders an expression term in the text form parsed by
+// ParseExpr, with nested TAllof and TAnyof in parentheses. TAllof and
+// TAnyof with a single term render as that term, and without terms as
+// "true" and "false" respectively, which match the same files.
+func FormatExpr(t Term) (string, error) {
+	var b strings.Builder
+	if err := formatExpr(&b, t, false); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// formatExpr writes t to b, in parentheses if nested is set and t is a
+// list of terms.
+func formatExpr(b *strings.Builder, t Term, nested bool) error {
+	switch t := t.(type) {
+	case TAllof:
+		return formatList(b, []Term(t), "and", "true", nested)
+	case TAnyof:
+		return formatList(b, []Term(t), "or", "false", nested)
+	case TNot:
+		b.WriteString("not ")
+		return formatExpr(b, t.Not, true)
+
+	case TTrue:
+		b.WriteString("true")
+	case TFalse:
+		b.WriteString("false")
+	case TExists:
+		b.WriteString("exists")
+	case TEmpty:
+		b.WriteString("empty")
+	case TFileType:
+		b.WriteString("type:" + quoteValue(string(t), false))
+	case TSuffix:
+		formatTerm(b, "suffix", "", []string(t)...)
+	case TName:
+		formatTerm(b, "name", matchMods(t.MatchType, 0), t.Names...)
+	case TIName:
+		formatTerm(b, "iname", matchMods(t.MatchType, 0), t.Names...)
+	case TMatch:
+		formatTerm(b, "match", matchMods(t.MatchType, t.Flags), t.Glob)
+	case TIMatch:
+		formatTerm(b, "imatch", matchMods(t.MatchType, t.Flags), t.Glob)
+	case TPCRE:
+		formatTerm(b, "pcre", matchMods(t.MatchType, 0), t.Regexp)
+	case TIPCRE:
+		formatTerm(b, "ipcre", matchMods(t.MatchType, 0), t.Regexp)
+	case TDirname:
+		formatDirname(b, "dirname", t.Name, t.Op, t.Depth)
+	case TIDirname:
+		formatDirname(b, "idirname", t.Name, t.Op, t.Depth)
+	case TSize:
+		fmt.Fprintf(b, "size %s %d", formatOp(t.Op), t.Size)
+
+	case TSince:
+		mods := ""
+		if t.Source != OClock {
+			for name, source := range clockSources {
+				if source == t.Source {
+					mods = "." + name
+				}
+			}
+		}
+		b.WriteString("since" + mods + ":")
+		switch ts := t.Timestamp.(type) {
+		case string:
+			if _, err := strconv.Atoi(ts); err == nil {
+				b.WriteString(strconv.Quote(ts))
+			} else {
+				b.WriteString(quoteValue(ts, false))
+			}
+		default:
+			n, err := decodeInt(ts, "")
+			if err != nil {
+				return fmt.Errorf("query: invalid since timestamp %v", ts)
+			}
+			b.WriteString(strconv.Itoa(n))
+		}
+
+	default:
+		return fmt.Errorf("query: cannot format %T as text", t)
+	}
+	return nil
+}
+
+func formatList(b *strings.Builder, terms []Term, op, empty string, nested bool) error {
+	switch len(terms) {
+	case 0:
+		b.WriteString(empty)
+		return nil
+	case 1:
+		return formatExpr(b, terms[0], nested)
+	}
+
+	if nested {
+		b.WriteString("(")
+	}
+	for i, term := range terms {
+		if i > 0 {
+			b.WriteString(" " + op + " ")
+		}
+		if err := formatExpr(b, term, true); err != nil {
+			return err
+		}
+	}
+	if nested {
+		b.WriteString(")")
+	}
+	return nil
+}
+
+func formatTerm(b *strings.Builder, name, mods string, values ...string) {
+	b.WriteString(name + mods + ":")
+	list := len(values) > 1 || name == "suffix" || name == "name" || name == "iname"
+	for i, value := range values {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(quoteValue(value, list))
+	}
+}
+
+func formatDirname(b *strings.Builder, name, dir string, op RelationalOp, depth int) {
+	formatTerm(b, name, "", dir)
+	if op != RelLt || depth != 0 {
+		fmt.Fprintf(b, " depth %s %d", formatOp(op), depth)
+	}
+}
+
+func formatOp(op RelationalOp) string {
+	for _, o := range textOps {
+		if o.op == op && o.text != "==" {
+			return o.text
+		}
+	}
+	return "?"
+}
+
+func matchMods(t TMatchType, flags TMatchFlags) string {
+	mods := ""
+	if t == MatchWholeName {
+		mods += ".wholename"
+	}
+	if flags&MatchIncludeDotFiles != 0 {
+		mods += ".dotfiles"
+	}
+	if flags&MatchNoEscape != 0 {
+		mods += ".noescape"
+	}
+	return mods
+}
+
+// quoteValue quotes s unless it can be parsed as a bare value.
+func quoteValue(s string, list bool) string {
+	if s == "" || s[0] == '"' || strings.ContainsAny(s, " \t\n\r()") || list && strings.Contains(s, ",") {
+		return strconv.Quote(s)
+	}
+	for _, r := range s {
+		if !strconv.IsPrint(r) {
+			return strconv.Quote(s)
+		}
+	}
+	return s
+}
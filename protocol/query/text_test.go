@@ -0,0 +1,145 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var exprTests = []struct {
+	text string
+	term Term
+}{
+	{`true`, TrueT},
+	{`false`, FalseT},
+	{`exists`, ExistsT},
+	{`empty`, EmptyT},
+	{`type:f`, TFileRegular},
+	{`suffix:go`, TSuffix{"go"}},
+	{`suffix:c,h`, TSuffix{"c", "h"}},
+	{`name:Makefile`, TName{Names: []string{"Makefile"}}},
+	{`iname.wholename:a,"b c",d`, TIName{Names: []string{"a", "b c", "d"}, MatchType: MatchWholeName}},
+	{`match:*.go`, TMatch{Glob: "*.go"}},
+	{`match.wholename:src/**/*.go`, TMatch{Glob: "src/**/*.go", MatchType: MatchWholeName}},
+	{`imatch.dotfiles.noescape:*\x`, TIMatch{Glob: `*\x`, Flags: MatchIncludeDotFiles | MatchNoEscape}},
+	{`match:a,b`, TMatch{Glob: "a,b"}},
+	{`pcre:"^(a|b)"`, TPCRE{Regexp: "^(a|b)"}},
+	{`ipcre.wholename:\.GO$`, TIPCRE{Regexp: `\.GO$`, MatchType: MatchWholeName}},
+	{`dirname:vendor`, TDirname{Name: "vendor"}},
+	{`idirname:SRC depth >= 2`, TIDirname{Name: "SRC", Op: RelGe, Depth: 2}},
+	{`dirname:"" depth = 0`, TDirname{Name: "", Op: RelEq, Depth: 0}},
+	{`size > 1024`, TSize{Op: RelGt, Size: 1024}},
+	{`size != 0`, TSize{Op: RelNe, Size: 0}},
+	{`since:c:1:2:3:4`, TSince{Timestamp: "c:1:2:3:4"}},
+	{`since.cclock:c:1:2:3:4`, TSince{Timestamp: "c:1:2:3:4", Source: CClock}},
+	{`since.mtime:1384402349`, TSince{Timestamp: 1384402349, Source: MTime}},
+	{`since:"12"`, TSince{Timestamp: "12"}},
+	{
+		`type:f and suffix:go and not dirname:vendor and size > 1024`,
+		TAllof{TFileRegular, TSuffix{"go"}, TNot{TDirname{Name: "vendor"}}, TSize{Op: RelGt, Size: 1024}},
+	},
+	{
+		`name:a or (name:b and not empty)`,
+		TAnyof{TName{Names: []string{"a"}}, TAllof{TName{Names: []string{"b"}}, TNot{EmptyT}}},
+	},
+	{
+		`(suffix:c or suffix:h) and not (dirname:vendor or match:"*_test *")`,
+		TAllof{
+			TAnyof{TSuffix{"c"}, TSuffix{"h"}},
+			TNot{TAnyof{TDirname{Name: "vendor"}, TMatch{Glob: "*_test *"}}},
+		},
+	},
+	{`(exists and empty) and true`, TAllof{TAllof{ExistsT, EmptyT}, TrueT}},
+	{`not not exists`, TNot{TNot{ExistsT}}},
+}
+
+func TestExpr(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range exprTests {
+		term, err := ParseExpr(tc.text)
+		require.NoError(err, tc.text)
+		require.Equal(tc.term, term, tc.text)
+
+		text, err := FormatExpr(tc.term)
+		require.NoError(err)
+		require.Equal(tc.text, text)
+	}
+
+	// every JSON term test round trips through text
+	for _, tc := range termTests {
+		text, err := FormatExpr(tc.term)
+		require.NoError(err, tc.json)
+		term, err := ParseExpr(text)
+		require.NoError(err, text)
+		require.Equal(tc.term, term, text)
+	}
+
+	for _, tc := range []struct {
+		text string
+		term Term
+	}{
+		{" \tsize==1\n", TSize{Op: RelEq, Size: 1}},
+		{"(((true)))", TrueT},
+		// and binds more tightly than or
+		{"exists or empty and true", TAnyof{ExistsT, TAllof{EmptyT, TrueT}}},
+		{"dirname:lib depth<-1", TDirname{Name: "lib", Op: RelLt, Depth: -1}},
+		{"since.oclock:c:1:2:3:4", TSince{Timestamp: "c:1:2:3:4"}},
+		{`name:"a\tb"`, TName{Names: []string{"a\tb"}}},
+	} {
+		term, err := ParseExpr(tc.text)
+		require.NoError(err, tc.text)
+		require.Equal(tc.term, term, tc.text)
+	}
+
+	for _, tc := range []struct {
+		term Term
+		text string
+	}{
+		{TAllof{}, "true"},
+		{TAnyof{}, "false"},
+		{TNot{TAllof{ExistsT}}, "not exists"},
+		{TName{Names: []string{"a\tb", ""}}, `name:"a\tb",""`},
+	} {
+		text, err := FormatExpr(tc.term)
+		require.NoError(err)
+		require.Equal(tc.text, text)
+	}
+
+	_, err := FormatExpr(json.RawMessage(`["spoon"]`))
+	require.Error(err)
+}
+
+func TestExprErrors(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		text   string
+		offset int
+		msg    string
+	}{
+		{``, 0, "expected an expression term"},
+		{`exists and`, 10, "expected an expression term"},
+		{`(exists`, 7, "expected )"},
+		{`exists)`, 6, `unexpected ")"`},
+		{`spoon:x`, 0, `unknown expression term "spoon"`},
+		{`exists and or`, 11, `unknown expression term "or"`},
+		{`true.x`, 0, `"true" takes no modifiers`},
+		{`name.dotfiles:x`, 0, `unknown modifier "dotfiles" for "name"`},
+		{`since.mtime.ctime:1`, 0, `unknown modifier "ctime" for "since"`},
+		{`suffix go`, 6, `expected : after "suffix"`},
+		{`suffix:`, 7, "expected a value"},
+		{`suffix:go,`, 10, "expected a value"},
+		{`name:"a`, 5, "unterminated quoted string"},
+		{`name:"\q"`, 5, "invalid quoted string"},
+		{`type:x`, 0, `unknown file type "x"`},
+		{`size ~ 1`, 5, "expected a relational operator"},
+		{`size > big`, 7, "expected an integer"},
+		{`dirname:a depth 1`, 16, "expected a relational operator"},
+		{`since.mtime:c:1:2:3:4`, 0, `expected an integer timestamp, got "c:1:2:3:4"`},
+	} {
+		_, err := ParseExpr(tc.text)
+		require.Equal(&SyntaxError{Offset: tc.offset, Msg: tc.msg}, err, tc.text)
+	}
+}
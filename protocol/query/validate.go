@@ -0,0 +1,307 @@
+package query
+
+import (
+	"sort"
+	"strings"
+)
+
+// knownFields lists the fields a query may request.
+var knownFields = map[Field]bool{
+	FName: true, FExists: true, FCclock: true, FOclock: true,
+	FCtime: true, FCtimeMs: true, FCtimeUs: true, FCtimeNs: true, FCtimeF: true,
+	FMtime: true, FMtimeMs: true, FMtimeUs: true, FMtimeNs: true, FMtimeF: true,
+	FSize: true, FMode: true, FUid: true, FGid: true, FIno: true, FDev: true,
+	FNlink: true, FNew: true, FType: true, FSymlinkTarget: true, FContentSha1hex: true,
+}
+
+// Validate checks q for values that the server would reject, failing
+// with a *ParseError whose Path locates the invalid value in the JSON
+// encoding of q.
+//
+// If hasCapability is not nil, such as Connection.HasCapability,
+// Validate also checks that the server supports the terms, generators,
+// fields and options used by q, as in "term-dirname",
+// "field-content.sha1hex", "suffix-set" or "relative_root". Terms of
+// types unknown to this package are not checked.
+func (q *Query) Validate(hasCapability func(string) bool) error {
+	v := &validator{hasCapability: hasCapability}
+
+	generators := make([]string, 0, len(q.Generators))
+	for g := range q.Generators {
+		generators = append(generators, string(g))
+	}
+	sort.Strings(generators)
+	for _, g := range generators {
+		if err := v.generator(Generator(g), q.Generators[Generator(g)], "$."+g); err != nil {
+			return err
+		}
+	}
+
+	if q.Expression != nil {
+		if err := v.term(q.Expression, "$.expression"); err != nil {
+			return err
+		}
+	}
+
+	if q.Fields != nil && len(q.Fields) == 0 {
+		return parseError("$.fields", "expected at least one field")
+	}
+	for i, f := range q.Fields {
+		path := index("$.fields", i)
+		if !knownFields[f] {
+			return parseError(path, "unknown field %q", f)
+		}
+		if err := v.require("field-"+string(f), path); err != nil {
+			return err
+		}
+	}
+
+	if q.DedupResults {
+		if err := v.require("dedup_results", "$.dedup_results"); err != nil {
+			return err
+		}
+	}
+	if q.RelativeRoot != "" {
+		if strings.HasPrefix(q.RelativeRoot, "/") {
+			return parseError("$.relative_root", "expected a path relative to the root")
+		}
+		if err := v.require("relative_root", "$.relative_root"); err != nil {
+			return err
+		}
+	}
+	if q.SyncTimeout < 0 {
+		return parseError("$.sync_timeout", "expected a non-negative timeout")
+	}
+	if q.LockTimeout < 0 {
+		return parseError("$.lock_timeout", "expected a non-negative timeout")
+	}
+	if q.Case != CaseSensitive && q.Case != CaseInsensitive {
+		return parseError("$.case_sensitive", "unknown case sensitivity %d", q.Case)
+	}
+	return nil
+}
+
+type validator struct {
+	hasCapability func(string) bool
+}
+
+func (v *validator) require(capability, path string) error {
+	if v.hasCapability != nil && !v.hasCapability(capability) {
+		return parseError(path, "server lacks capability %q", capability)
+	}
+	return nil
+}
+
+func (v *validator) generator(g Generator, arg any, path string) error {
+	switch g {
+	case GSince:
+		if clock, ok := arg.(string); ok {
+			if clock == "" {
+				return parseError(path, "expected a clock")
+			}
+			return nil
+		}
+		_, err := decodeInt(arg, path)
+		return err
+
+	case GSuffix:
+		if suffix, ok := arg.(string); ok {
+			if suffix == "" {
+				return parseError(path, "expected a suffix")
+			}
+			return nil
+		}
+		suffixes, ok := arg.([]string)
+		if !ok || len(suffixes) == 0 {
+			return parseError(path, "expected a suffix or a non-empty array of suffixes")
+		}
+		return v.require("suffix-set", path)
+
+	case GGlob:
+		globs, ok := arg.([]string)
+		if !ok || len(globs) == 0 {
+			return parseError(path, "expected a non-empty array of patterns")
+		}
+		return v.require("glob_generator", path)
+
+	case GPath:
+		var paths []any
+		switch arg := arg.(type) {
+		case []any:
+			paths = arg
+		case []string:
+			for _, p := range arg {
+				paths = append(paths, p)
+			}
+		default:
+			return parseError(path, "expected an array of paths")
+		}
+		if len(paths) == 0 {
+			return parseError(path, "expected at least one path")
+		}
+		for i, p := range paths {
+			switch p := p.(type) {
+			case string:
+			case GPathPath:
+				if p.Depth < -1 {
+					return parseError(index(path, i)+".depth", "expected a depth of at least -1")
+				}
+			default:
+				return parseError(index(path, i), "expected a path or a GPathPath")
+			}
+		}
+		return nil
+	}
+	return parseError(path, "unknown generator")
+}
+
+func (v *validator) term(t Term, path string) error {
+	switch t := t.(type) {
+	case nil:
+		return parseError(path, "expected an expression term")
+
+	case TAllof:
+		return v.terms("allof", t, path)
+	case TAnyof:
+		return v.terms("anyof", t, path)
+	case TNot:
+		if err := v.require("term-not", path); err != nil {
+			return err
+		}
+		return v.term(t.Not, index(path, 1))
+
+	case TTrue:
+		return v.require("term-true", path)
+	case TFalse:
+		return v.require("term-false", path)
+	case TExists:
+		return v.require("term-exists", path)
+	case TEmpty:
+		return v.require("term-empty", path)
+
+	case TFileType:
+		if !fileTypes[t] {
+			return parseError(index(path, 1), "unknown file type %q", string(t))
+		}
+		return v.require("term-type", path)
+
+	case TSuffix:
+		if len(t) == 0 {
+			return parseError(index(path, 1), "expected at least one suffix")
+		}
+		if err := v.require("term-suffix", path); err != nil {
+			return err
+		}
+		if len(t) > 1 {
+			return v.require("suffix-set", index(path, 1))
+		}
+		return nil
+
+	case TName:
+		return v.name("name", t.Names, t.MatchType, path)
+	case TIName:
+		return v.name("iname", t.Names, t.MatchType, path)
+	case TMatch:
+		return v.match("match", t.Glob, t.MatchType, t.Flags, path)
+	case TIMatch:
+		return v.match("imatch", t.Glob, t.MatchType, t.Flags, path)
+	case TPCRE:
+		return v.pcre("pcre", t.Regexp, t.MatchType, path)
+	case TIPCRE:
+		return v.pcre("ipcre", t.Regexp, t.MatchType, path)
+	case TDirname:
+		return v.dirname("dirname", t.Op, path)
+	case TIDirname:
+		return v.dirname("idirname", t.Op, path)
+
+	case TSize:
+		if _, ok := relationalOpMap[t.Op]; !ok {
+			return parseError(index(path, 1), "unknown relational operator %d", t.Op)
+		}
+		return v.require("term-size", path)
+
+	case TSince:
+		switch t.Source {
+		case OClock, CClock:
+			if clock, ok := t.Timestamp.(string); ok && clock != "" {
+				break
+			}
+			if _, err := decodeInt(t.Timestamp, ""); err != nil {
+				return parseError(index(path, 1), "expected a clock or a timestamp")
+			}
+		case MTime, CTime:
+			if _, err := decodeInt(t.Timestamp, ""); err != nil {
+				return parseError(index(path, 1), "expected a timestamp")
+			}
+		default:
+			return parseError(index(path, 2), "unknown clock source %d", t.Source)
+		}
+		return v.require("term-since", path)
+	}
+	return nil
+}
+
+func (v *validator) terms(name string, terms []Term, path string) error {
+	if err := v.require("term-"+name, path); err != nil {
+		return err
+	}
+	for i, t := range terms {
+		if err := v.term(t, index(path, i+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *validator) name(name string, names []string, t TMatchType, path string) error {
+	if len(names) == 0 {
+		return parseError(index(path, 1), "expected at least one name")
+	}
+	if err := v.matchType(t, index(path, 2)); err != nil {
+		return err
+	}
+	return v.require("term-"+name, path)
+}
+
+func (v *validator) match(name, glob string, t TMatchType, flags TMatchFlags, path string) error {
+	if glob == "" {
+		return parseError(index(path, 1), "expected a pattern")
+	}
+	if err := v.matchType(t, index(path, 2)); err != nil {
+		return err
+	}
+	if flags&^(MatchIncludeDotFiles|MatchNoEscape) != 0 {
+		return parseError(index(path, 3), "unknown match flags %#x", int(flags))
+	}
+	if err := v.require("term-"+name, path); err != nil {
+		return err
+	}
+	if strings.Contains(glob, "**") {
+		return v.require("wildmatch", index(path, 1))
+	}
+	return nil
+}
+
+func (v *validator) pcre(name, expr string, t TMatchType, path string) error {
+	if expr == "" {
+		return parseError(index(path, 1), "expected a regular expression")
+	}
+	if err := v.matchType(t, index(path, 2)); err != nil {
+		return err
+	}
+	return v.require("term-"+name, path)
+}
+
+func (v *validator) dirname(name string, op RelationalOp, path string) error {
+	if _, ok := relationalOpMap[op]; !ok {
+		return parseError(index(index(path, 2), 1), "unknown relational operator %d", op)
+	}
+	return v.require("term-"+name, path)
+}
+
+func (v *validator) matchType(t TMatchType, path string) error {
+	if t != MatchBaseName && t != MatchWholeName {
+		return parseError(path, "unknown match type %d", t)
+	}
+	return nil
+}
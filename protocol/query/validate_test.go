@@ -0,0 +1,100 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	require := require.New(t)
+
+	for _, test := range queryTests {
+		require.NoError(test.query.Validate(nil))
+	}
+	for _, tc := range termTests {
+		require.NoError((&Query{Expression: tc.term}).Validate(nil), tc.json)
+	}
+	// terms unknown to this package are sent as they are
+	require.NoError((&Query{Expression: TNot{json.RawMessage(`["spoon"]`)}}).Validate(nil))
+
+	for _, tc := range []struct {
+		query Query
+		path  string
+		msg   string
+	}{
+		{Query{Generators: Generators{"spoon": true}}, "$.spoon", "unknown generator"},
+		{Query{Generators: Generators{GSince: ""}}, "$.since", "expected a clock"},
+		{Query{Generators: Generators{GSince: 1.5}}, "$.since", "expected an integer"},
+		{Query{Generators: Generators{GSuffix: []string{}}}, "$.suffix", "expected a suffix or a non-empty array of suffixes"},
+		{Query{Generators: Generators{GGlob: []string{}}}, "$.glob", "expected a non-empty array of patterns"},
+		{Query{Generators: Generators{GPath: []any{}}}, "$.path", "expected at least one path"},
+		{Query{Generators: Generators{GPath: []any{"a", 1}}}, "$.path[1]", "expected a path or a GPathPath"},
+		{Query{Generators: Generators{GPath: []any{GPathPath{Path: "a", Depth: -2}}}}, "$.path[0].depth", "expected a depth of at least -1"},
+		{Query{Expression: TAllof{TrueT, nil}}, "$.expression[2]", "expected an expression term"},
+		{Query{Expression: TNot{TFileType("x")}}, "$.expression[1][1]", `unknown file type "x"`},
+		{Query{Expression: TSuffix{}}, "$.expression[1]", "expected at least one suffix"},
+		{Query{Expression: TName{}}, "$.expression[1]", "expected at least one name"},
+		{Query{Expression: TIName{Names: []string{"a"}, MatchType: 2}}, "$.expression[2]", "unknown match type 2"},
+		{Query{Expression: TMatch{}}, "$.expression[1]", "expected a pattern"},
+		{Query{Expression: TIMatch{Glob: "*", Flags: 4}}, "$.expression[3]", "unknown match flags 0x4"},
+		{Query{Expression: TPCRE{}}, "$.expression[1]", "expected a regular expression"},
+		{Query{Expression: TDirname{Name: "a", Op: 9}}, "$.expression[2][1]", "unknown relational operator 9"},
+		{Query{Expression: TSize{Op: -1}}, "$.expression[1]", "unknown relational operator -1"},
+		{Query{Expression: TSince{}}, "$.expression[1]", "expected a clock or a timestamp"},
+		{Query{Expression: TSince{Timestamp: "c:1:2:3:4", Source: MTime}}, "$.expression[1]", "expected a timestamp"},
+		{Query{Expression: TSince{Timestamp: 1, Source: 7}}, "$.expression[2]", "unknown clock source 7"},
+		{Query{Fields: Fields{}}, "$.fields", "expected at least one field"},
+		{Query{Fields: Fields{FName, "nmae"}}, "$.fields[1]", `unknown field "nmae"`},
+		{Query{RelativeRoot: "/src"}, "$.relative_root", "expected a path relative to the root"},
+		{Query{SyncTimeout: -1}, "$.sync_timeout", "expected a non-negative timeout"},
+		{Query{LockTimeout: -1}, "$.lock_timeout", "expected a non-negative timeout"},
+		{Query{Case: 2}, "$.case_sensitive", "unknown case sensitivity 2"},
+	} {
+		err := tc.query.Validate(nil)
+		require.Equal(&ParseError{Path: tc.path, Msg: tc.msg}, err, "%#v", tc.query)
+	}
+}
+
+func TestValidateCapabilities(t *testing.T) {
+	require := require.New(t)
+
+	// an old server without dirname, pcre, suffix sets and wildmatch
+	caps := map[string]bool{}
+	for _, c := range []string{
+		"field-name", "field-exists", "relative_root",
+		"term-allof", "term-anyof", "term-not", "term-true", "term-false",
+		"term-exists", "term-empty", "term-type", "term-suffix", "term-match",
+		"term-name", "term-since", "term-size",
+	} {
+		caps[c] = true
+	}
+	hasCapability := func(c string) bool { return caps[c] }
+
+	q := New().
+		Suffix("go").
+		Not(TMatch{Glob: "*_test.go"}).
+		Fields(FName, FExists).
+		RelativeRoot("src").
+		Query()
+	require.NoError(q.Validate(hasCapability))
+
+	for _, tc := range []struct {
+		query *Query
+		path  string
+		cap   string
+	}{
+		{New().Dirname("vendor").Query(), "$.expression", "term-dirname"},
+		{New().Where(TAnyof{TrueT, TIPCRE{Regexp: "a"}}).Query(), "$.expression[2]", "term-ipcre"},
+		{New().Suffix("c", "h").Query(), "$.expression[1]", "suffix-set"},
+		{New().Match("src/**/*.go").Query(), "$.expression[1]", "wildmatch"},
+		{New().Glob("*.go").Query(), "$.glob", "glob_generator"},
+		{New().Generator(GSuffix, []string{"c", "h"}).Query(), "$.suffix", "suffix-set"},
+		{New().Fields(FName, FContentSha1hex).Query(), "$.fields[1]", "field-content.sha1hex"},
+		{New().DedupResults().Query(), "$.dedup_results", "dedup_results"},
+	} {
+		err := tc.query.Validate(hasCapability)
+		require.Equal(&ParseError{Path: tc.path, Msg: `server lacks capability "` + tc.cap + `"`}, err, tc.cap)
+	}
+}
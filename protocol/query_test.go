@@ -0,0 +1,123 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+func TestQuery(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *QueryRequest
+		res      *QueryResponse
+	}{
+		{
+			request: `["query","/tmp",{}]` + "\n",
+			response: `{"clock":"c:1531594843:978:9:345","files":["foo","bar"],` +
+				`"is_fresh_instance":true,"version":"4.9.0"}` + "\n",
+			req: &QueryRequest{Root: "/tmp"},
+			res: &QueryResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":           "4.9.0",
+						"clock":             "c:1531594843:978:9:345",
+						"files":             []interface{}{"foo", "bar"},
+						"is_fresh_instance": true,
+					},
+					version: "4.9.0",
+				},
+				clock:           "c:1531594843:978:9:345",
+				rawFiles:        []interface{}{"foo", "bar"},
+				files:           []File{{Name: "foo"}, {Name: "bar"}},
+				isFreshInstance: true,
+			},
+		},
+		{
+			request: `["query","/tmp",{"expression":["suffix","go"],` +
+				`"fields":["name","exists"]}]` + "\n",
+			response: `{"clock":{"clock":"c:1531594843:978:9:345","scm":{}},` +
+				`"files":[{"name":"main.go","exists":true}],` +
+				`"is_fresh_instance":true,"version":"4.9.0"}` + "\n",
+			req: &QueryRequest{
+				Root: "/tmp",
+				Query: &query.Query{
+					Expression: query.TSuffix{"go"},
+					Fields:     query.Fields{query.FName, query.FExists},
+				},
+			},
+			res: &QueryResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version": "4.9.0",
+						"clock": map[string]interface{}{
+							"clock": "c:1531594843:978:9:345",
+							"scm":   map[string]interface{}{},
+						},
+						"files": []interface{}{
+							map[string]interface{}{"name": "main.go", "exists": true},
+						},
+						"is_fresh_instance": true,
+					},
+					version: "4.9.0",
+				},
+				clock: "c:1531594843:978:9:345",
+				rawFiles: []interface{}{
+					map[string]interface{}{"name": "main.go", "exists": true},
+				},
+				files: []File{
+					{Name: "main.go", Exists: true},
+				},
+				isFreshInstance: true,
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewQueryResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal("c:1531594843:978:9:345", actual.Clock())
+		require.Equal(true, actual.IsFreshInstance())
+		require.Equal(tc.res.files, actual.Files())
+		var fields query.Fields
+		if tc.req.Query != nil {
+			fields = tc.req.Query.Fields
+		}
+		require.Equal(tc.res.files, actual.DecodeFiles(fields))
+	}
+
+	// a single field other than name is returned as bare values
+	c := &Connection{
+		reader: bufio.NewReader(bytes.NewReader([]byte(
+			`{"clock":"c:1531594843:978:9:345","files":[3,5],"version":"4.9.0"}` + "\n",
+		))),
+		socket: &bytes.Buffer{},
+	}
+	require.NoError(c.Send(&QueryRequest{Root: "/tmp", Query: &query.Query{Fields: query.Fields{query.FSize}}}))
+	pdu, err := c.Recv()
+	require.NoError(err)
+	res := NewQueryResponse(pdu)
+	require.Equal([]File{{Size: 3}, {Size: 5}}, res.DecodeFiles(query.Fields{query.FSize}))
+}
@@ -0,0 +1,42 @@
+package protocol
+
+/*
+["shutdown-server"]
+{"shutdown-server":true,"version":"4.9.0"}
+*/
+
+// A ShutdownServerRequest represents the Watchman shutdown-server
+// command. The server closes every connection after responding.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/shutdown-server.html
+type ShutdownServerRequest struct{}
+
+// Args returns values used to encode a request PDU.
+func (req *ShutdownServerRequest) Args() []interface{} {
+	return []interface{}{"shutdown-server"}
+}
+
+// A ShutdownServerResponse represents a response to the Watchman
+// shutdown-server command.
+type ShutdownServerResponse struct {
+	response
+	shutdown bool
+}
+
+// NewShutdownServerResponse converts a ResponsePDU to ShutdownServerResponse
+func NewShutdownServerResponse(pdu ResponsePDU) (res *ShutdownServerResponse) {
+	res = &ShutdownServerResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["shutdown-server"]; ok {
+		if shutdown, ok := x.(bool); ok {
+			res.shutdown = shutdown
+		}
+	}
+	return
+}
+
+// Shutdown indicates if the Watchman server is shutting down.
+func (res *ShutdownServerResponse) Shutdown() bool {
+	return res.shutdown
+}
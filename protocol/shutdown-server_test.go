@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdownServer(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *ShutdownServerRequest
+		res      *ShutdownServerResponse
+	}{
+		{
+			request:  `["shutdown-server"]` + "\n",
+			response: `{"shutdown-server":true,"version":"4.9.0"}` + "\n",
+			req:      &ShutdownServerRequest{},
+			res: &ShutdownServerResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":         "4.9.0",
+						"shutdown-server": true,
+					},
+					version: "4.9.0",
+				},
+				shutdown: true,
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewShutdownServerResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.True(actual.Shutdown())
+	}
+}
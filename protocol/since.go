@@ -0,0 +1,85 @@
+package protocol
+
+/*
+["since","/tmp","c:1531594843:978:9:826","*.go"]
+{"version":"4.9.0",
+ "clock":"c:1531594843:978:9:830",
+ "is_fresh_instance":false,
+ "files":[{
+  "name":"foo/main.go",
+  "exists":true,
+  "new":false,
+  "size":1024,
+  "mode":33188
+ }]}
+*/
+
+// A SinceRequest represents the Watchman since command. Patterns
+// optionally restrict the results to files whose path, relative to the
+// root, matches one of the wildmatch patterns.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/since.html
+type SinceRequest struct {
+	Root     string
+	Clock    string
+	Patterns []string
+}
+
+// Args returns values used to encode a request PDU.
+func (req *SinceRequest) Args() []interface{} {
+	args := []interface{}{"since", req.Root, req.Clock}
+	for _, pattern := range req.Patterns {
+		args = append(args, pattern)
+	}
+	return args
+}
+
+// A SinceResponse represents a response to the Watchman since command.
+type SinceResponse struct {
+	response
+	clock           string
+	files           []File
+	isFreshInstance bool
+}
+
+// NewSinceResponse converts a ResponsePDU to SinceResponse
+func NewSinceResponse(pdu ResponsePDU) (res *SinceResponse) {
+	res = &SinceResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["clock"]; ok {
+		if clock, ok := x.(string); ok {
+			res.clock = clock
+		}
+	}
+	if x, ok := pdu["files"]; ok {
+		if files, ok := x.([]interface{}); ok {
+			res.files = NewFiles(files, nil)
+		}
+	}
+	if x, ok := pdu["is_fresh_instance"]; ok {
+		if isFreshInstance, ok := x.(bool); ok {
+			res.isFreshInstance = isFreshInstance
+		}
+	}
+	return
+}
+
+// Clock returns the clock value of the root when the changes were
+// computed. Pass it to the next since command to continue from there.
+func (res *SinceResponse) Clock() string {
+	return res.clock
+}
+
+// Files returns the files that changed since the requested clock.
+func (res *SinceResponse) Files() []File {
+	return res.files
+}
+
+// IsFreshInstance indicates if the requested clock was not recognized,
+// for example because the Watchman server restarted since it was
+// issued. In that case Files lists every existing file, rather than
+// the changes, and deleted files are not reported.
+func (res *SinceResponse) IsFreshInstance() bool {
+	return res.isFreshInstance
+}
@@ -0,0 +1,97 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSince(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *SinceRequest
+		res      *SinceResponse
+	}{
+		{
+			request: `["since","/tmp","c:1531594843:978:9:826"]` + "\n",
+			response: `{"clock":"c:1531594843:978:9:830","files":[],` +
+				`"is_fresh_instance":false,"version":"4.9.0"}` + "\n",
+			req: &SinceRequest{Root: "/tmp", Clock: "c:1531594843:978:9:826"},
+			res: &SinceResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":           "4.9.0",
+						"clock":             "c:1531594843:978:9:830",
+						"files":             []interface{}{},
+						"is_fresh_instance": false,
+					},
+					version: "4.9.0",
+				},
+				clock: "c:1531594843:978:9:830",
+				files: []File{},
+			},
+		},
+		{
+			request: `["since","/tmp","c:1531594843:978:9:826","*.go","*.c"]` + "\n",
+			response: `{"clock":"c:1531594843:978:9:830",` +
+				`"files":[{"name":"foo/main.go","exists":true,"new":true,"size":1024}],` +
+				`"is_fresh_instance":true,"version":"4.9.0"}` + "\n",
+			req: &SinceRequest{
+				Root:     "/tmp",
+				Clock:    "c:1531594843:978:9:826",
+				Patterns: []string{"*.go", "*.c"},
+			},
+			res: &SinceResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version": "4.9.0",
+						"clock":   "c:1531594843:978:9:830",
+						"files": []interface{}{
+							map[string]interface{}{
+								"name":   "foo/main.go",
+								"exists": true,
+								"new":    true,
+								"size":   float64(1024),
+							},
+						},
+						"is_fresh_instance": true,
+					},
+					version: "4.9.0",
+				},
+				clock: "c:1531594843:978:9:830",
+				files: []File{
+					{Name: "foo/main.go", Exists: true, New: true, Size: 1024},
+				},
+				isFreshInstance: true,
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewSinceResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal("c:1531594843:978:9:830", actual.Clock())
+		require.Equal(tc.res.isFreshInstance, actual.IsFreshInstance())
+		require.Equal(tc.res.files, actual.Files())
+	}
+}
@@ -0,0 +1,85 @@
+package protocol
+
+/*
+["state-enter", "/tmp", {"name": "hg.update", "metadata": {"rev": "abc"}}]
+{"root":"/tmp", "state-enter":"hg.update",
+ "clock":"c:1531594843:978:9:345", "version":"4.9.0"}
+*/
+
+// A StateEnterRequest represents the Watchman state-enter command.
+//
+// Metadata is optional, and is passed to subscribers in the
+// state-enter notification. SyncTimeout is in milliseconds.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/state-enter.html
+type StateEnterRequest struct {
+	Root        string
+	Name        string
+	Metadata    interface{}
+	SyncTimeout int
+}
+
+// Args returns values used to encode a request PDU.
+func (req *StateEnterRequest) Args() []interface{} {
+	return []interface{}{"state-enter", req.Root, stateArgs(req.Name, req.Metadata, req.SyncTimeout)}
+}
+
+func stateArgs(name string, metadata interface{}, syncTimeout int) interface{} {
+	if metadata == nil && syncTimeout < 1 {
+		return name
+	}
+	m := map[string]interface{}{"name": name}
+	if metadata != nil {
+		m["metadata"] = metadata
+	}
+	if syncTimeout > 0 {
+		m["sync_timeout"] = syncTimeout
+	}
+	return m
+}
+
+// A StateEnterResponse represents a response to the Watchman state-enter command.
+type StateEnterResponse struct {
+	response
+	clock string
+	root  string
+	state string
+}
+
+// NewStateEnterResponse converts a ResponsePDU to StateEnterResponse
+func NewStateEnterResponse(pdu ResponsePDU) (res *StateEnterResponse) {
+	res = &StateEnterResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["clock"]; ok {
+		if clock, ok := x.(string); ok {
+			res.clock = clock
+		}
+	}
+	if x, ok := pdu["root"]; ok {
+		if root, ok := x.(string); ok {
+			res.root = root
+		}
+	}
+	if x, ok := pdu["state-enter"]; ok {
+		if state, ok := x.(string); ok {
+			res.state = state
+		}
+	}
+	return
+}
+
+// Clock returns a value representing when the state was entered.
+func (res *StateEnterResponse) Clock() string {
+	return res.clock
+}
+
+// Root returns the watched root.
+func (res *StateEnterResponse) Root() string {
+	return res.root
+}
+
+// State returns the name of the entered state.
+func (res *StateEnterResponse) State() string {
+	return res.state
+}
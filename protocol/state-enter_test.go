@@ -0,0 +1,90 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateEnter(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *StateEnterRequest
+		res      *StateEnterResponse
+	}{
+		{
+			request: `["state-enter","/tmp","hg.update"]` + "\n",
+			response: `{"root":"/tmp","state-enter":"hg.update",` +
+				`"clock":"c:1531594843:978:9:345","version":"4.9.0"}` + "\n",
+			req: &StateEnterRequest{Root: "/tmp", Name: "hg.update"},
+			res: &StateEnterResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":     "4.9.0",
+						"root":        "/tmp",
+						"state-enter": "hg.update",
+						"clock":       "c:1531594843:978:9:345",
+					},
+					version: "4.9.0",
+				},
+				clock: "c:1531594843:978:9:345",
+				root:  "/tmp",
+				state: "hg.update",
+			},
+		},
+		{
+			request: `["state-enter","/tmp",{"metadata":{"rev":"abc"},` +
+				`"name":"hg.update","sync_timeout":200}]` + "\n",
+			response: `{"root":"/tmp","state-enter":"hg.update",` +
+				`"clock":"c:1531594843:978:9:345","version":"4.9.0"}` + "\n",
+			req: &StateEnterRequest{
+				Root:        "/tmp",
+				Name:        "hg.update",
+				Metadata:    map[string]string{"rev": "abc"},
+				SyncTimeout: 200,
+			},
+			res: &StateEnterResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":     "4.9.0",
+						"root":        "/tmp",
+						"state-enter": "hg.update",
+						"clock":       "c:1531594843:978:9:345",
+					},
+					version: "4.9.0",
+				},
+				clock: "c:1531594843:978:9:345",
+				root:  "/tmp",
+				state: "hg.update",
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewStateEnterResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal("c:1531594843:978:9:345", actual.Clock())
+		require.Equal("/tmp", actual.Root())
+		require.Equal("hg.update", actual.State())
+	}
+}
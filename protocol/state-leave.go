@@ -0,0 +1,71 @@
+package protocol
+
+/*
+["state-leave", "/tmp", {"name": "hg.update", "metadata": {"status": "ok"}}]
+{"root":"/tmp", "state-leave":"hg.update",
+ "clock":"c:1531594843:978:9:346", "version":"4.9.0"}
+*/
+
+// A StateLeaveRequest represents the Watchman state-leave command.
+//
+// Metadata is optional, and is passed to subscribers in the
+// state-leave notification. SyncTimeout is in milliseconds.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/state-leave.html
+type StateLeaveRequest struct {
+	Root        string
+	Name        string
+	Metadata    interface{}
+	SyncTimeout int
+}
+
+// Args returns values used to encode a request PDU.
+func (req *StateLeaveRequest) Args() []interface{} {
+	return []interface{}{"state-leave", req.Root, stateArgs(req.Name, req.Metadata, req.SyncTimeout)}
+}
+
+// A StateLeaveResponse represents a response to the Watchman state-leave command.
+type StateLeaveResponse struct {
+	response
+	clock string
+	root  string
+	state string
+}
+
+// NewStateLeaveResponse converts a ResponsePDU to StateLeaveResponse
+func NewStateLeaveResponse(pdu ResponsePDU) (res *StateLeaveResponse) {
+	res = &StateLeaveResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["clock"]; ok {
+		if clock, ok := x.(string); ok {
+			res.clock = clock
+		}
+	}
+	if x, ok := pdu["root"]; ok {
+		if root, ok := x.(string); ok {
+			res.root = root
+		}
+	}
+	if x, ok := pdu["state-leave"]; ok {
+		if state, ok := x.(string); ok {
+			res.state = state
+		}
+	}
+	return
+}
+
+// Clock returns a value representing when the state was left.
+func (res *StateLeaveResponse) Clock() string {
+	return res.clock
+}
+
+// Root returns the watched root.
+func (res *StateLeaveResponse) Root() string {
+	return res.root
+}
+
+// State returns the name of the vacated state.
+func (res *StateLeaveResponse) State() string {
+	return res.state
+}
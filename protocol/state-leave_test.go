@@ -0,0 +1,88 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateLeave(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *StateLeaveRequest
+		res      *StateLeaveResponse
+	}{
+		{
+			request: `["state-leave","/tmp","hg.update"]` + "\n",
+			response: `{"root":"/tmp","state-leave":"hg.update",` +
+				`"clock":"c:1531594843:978:9:346","version":"4.9.0"}` + "\n",
+			req: &StateLeaveRequest{Root: "/tmp", Name: "hg.update"},
+			res: &StateLeaveResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":     "4.9.0",
+						"root":        "/tmp",
+						"state-leave": "hg.update",
+						"clock":       "c:1531594843:978:9:346",
+					},
+					version: "4.9.0",
+				},
+				clock: "c:1531594843:978:9:346",
+				root:  "/tmp",
+				state: "hg.update",
+			},
+		},
+		{
+			request: `["state-leave","/tmp",{"metadata":"done","name":"hg.update"}]` + "\n",
+			response: `{"root":"/tmp","state-leave":"hg.update",` +
+				`"clock":"c:1531594843:978:9:346","version":"4.9.0"}` + "\n",
+			req: &StateLeaveRequest{
+				Root:     "/tmp",
+				Name:     "hg.update",
+				Metadata: "done",
+			},
+			res: &StateLeaveResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":     "4.9.0",
+						"root":        "/tmp",
+						"state-leave": "hg.update",
+						"clock":       "c:1531594843:978:9:346",
+					},
+					version: "4.9.0",
+				},
+				clock: "c:1531594843:978:9:346",
+				root:  "/tmp",
+				state: "hg.update",
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewStateLeaveResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal("c:1531594843:978:9:346", actual.Clock())
+		require.Equal("/tmp", actual.Root())
+		require.Equal("hg.update", actual.State())
+	}
+}
@@ -1,6 +1,11 @@
 package protocol
 
-import "github.com/cdmistman/watchman/protocol/query"
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/cdmistman/watchman/protocol/query"
+)
 
 /*
 ["subscribe","/tmp","sub1",{"fields":["exists","name","type"]}]
@@ -32,15 +37,53 @@ import "github.com/cdmistman/watchman/protocol/query"
  "clock":"c:1531594843:978:9:827",
  "is_fresh_instance":false}
 {"unilateral":true,"subscription":"sub1","root":"/tmp","canceled":true,"version":"4.9.0"}
+{"unilateral":true,
+ "subscription":"sub1",
+ "root":"/tmp",
+ "state-enter":"hg.update",
+ "metadata":{"rev":"abc"},
+ "clock":"c:1531594843:978:9:828",
+ "version":"4.9.0"}
+{"unilateral":true,
+ "subscription":"sub1",
+ "root":"/tmp",
+ "state-leave":"hg.update",
+ "abandoned":true,
+ "clock":"c:1531594843:978:9:829",
+ "version":"4.9.0"}
 */
 
 // A SubscribeRequest represents the Watchman subscribe command.
 //
+// The subscription options are merged into the query object:
+//
+//   - While a state named in Defer is asserted, notifications are
+//     deferred until the state is vacated.
+//   - While a state named in Drop is asserted, changes are not notified
+//     at all.
+//   - DisableDeferVCS stops Watchman from deferring notifications while
+//     a version control operation is in progress.
+//   - SettlePeriod and SettleTimeout, in milliseconds, override how long
+//     the root must be idle before notifications are sent, and how long
+//     to wait for it to settle.
+//   - EmptyOnFreshInstance omits the files list from fresh instance
+//     notifications.
+//   - Since starts the subscription from a clock, overriding the since
+//     generator of Query.
+//
 // See also: https://facebook.github.io/watchman/docs/cmd/subscribe.html
 type SubscribeRequest struct {
 	Root  string
 	Name  string
 	Query *query.Query
+
+	Defer                []string
+	Drop                 []string
+	DisableDeferVCS      bool
+	SettlePeriod         int
+	SettleTimeout        int
+	EmptyOnFreshInstance bool
+	Since                string
 }
 
 // Args returns values used to encode a request PDU.
@@ -51,13 +94,97 @@ func (req *SubscribeRequest) Args() []interface{} {
 		req.Name,
 	}
 
-	if req.Query != nil {
+	if req.hasOptions() {
+		res = append(res, subscribeSpec{req})
+	} else if req.Query != nil {
 		res = append(res, req.Query)
 	}
 
 	return res
 }
 
+func (req *SubscribeRequest) hasOptions() bool {
+	return len(req.Defer) > 0 ||
+		len(req.Drop) > 0 ||
+		req.DisableDeferVCS ||
+		req.SettlePeriod > 0 ||
+		req.SettleTimeout > 0 ||
+		req.EmptyOnFreshInstance ||
+		req.Since != ""
+}
+
+// Capabilities returns the capabilities the Watchman server must
+// advertise to honor the request; see Connection.HasCapability.
+func (req *SubscribeRequest) Capabilities() []string {
+	caps := []string{"cmd-subscribe"}
+	if len(req.Defer) > 0 || len(req.Drop) > 0 {
+		caps = append(caps, "cmd-state-enter")
+	}
+	if q := req.Query; q != nil {
+		if q.RelativeRoot != "" {
+			caps = append(caps, "relative_root")
+		}
+		if q.DedupResults {
+			caps = append(caps, "dedup_results")
+		}
+		if q.SyncTimeout > 0 {
+			caps = append(caps, "clock-sync-timeout")
+		}
+	}
+	return caps
+}
+
+// subscribeSpec encodes the query of a SubscribeRequest together with
+// its subscription options.
+type subscribeSpec struct {
+	req *SubscribeRequest
+}
+
+func (spec subscribeSpec) MarshalJSON() ([]byte, error) {
+	res := map[string]interface{}{}
+	if spec.req.Query != nil {
+		b, err := json.Marshal(spec.req.Query)
+		if err != nil {
+			return nil, err
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err = dec.Decode(&res); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(spec.req.Defer) > 0 {
+		res["defer"] = spec.req.Defer
+	}
+
+	if len(spec.req.Drop) > 0 {
+		res["drop"] = spec.req.Drop
+	}
+
+	if spec.req.DisableDeferVCS {
+		res["defer_vcs"] = false
+	}
+
+	if spec.req.SettlePeriod > 0 {
+		res["settle_period"] = spec.req.SettlePeriod
+	}
+
+	if spec.req.SettleTimeout > 0 {
+		res["settle_timeout"] = spec.req.SettleTimeout
+	}
+
+	if spec.req.EmptyOnFreshInstance {
+		res["empty_on_fresh_instance"] = true
+	}
+
+	if spec.req.Since != "" {
+		res["since"] = spec.req.Since
+	}
+
+	return json.Marshal(res)
+}
+
 // A SubscribeResponse represents a response to the Watchman subscribe command.
 type SubscribeResponse struct {
 	response
@@ -100,8 +227,14 @@ type Subscription struct {
 	clock           string
 	root            string
 	subscription    string
-	files           []interface{}
+	rawFiles        []interface{}
+	files           []File
 	isFreshInstance bool
+	canceled        bool
+	stateEnter      string
+	stateLeave      string
+	metadata        interface{}
+	abandoned       bool
 }
 
 // NewSubscription converts a ResponsePDU to Subscription
@@ -116,7 +249,8 @@ func NewSubscription(pdu ResponsePDU) (s *Subscription) {
 	}
 	if x, ok := pdu["files"]; ok {
 		if files, ok := x.([]interface{}); ok {
-			s.files = files
+			s.rawFiles = files
+			s.files = NewFiles(files, nil)
 		}
 	}
 	if x, ok := pdu["is_fresh_instance"]; ok {
@@ -124,11 +258,34 @@ func NewSubscription(pdu ResponsePDU) (s *Subscription) {
 			s.isFreshInstance = isFreshInstance
 		}
 	}
+	if x, ok := pdu["canceled"]; ok {
+		if canceled, ok := x.(bool); ok {
+			s.canceled = canceled
+		}
+	}
 	if x, ok := pdu["root"]; ok {
 		if root, ok := x.(string); ok {
 			s.root = root
 		}
 	}
+	if x, ok := pdu["state-enter"]; ok {
+		if state, ok := x.(string); ok {
+			s.stateEnter = state
+		}
+	}
+	if x, ok := pdu["state-leave"]; ok {
+		if state, ok := x.(string); ok {
+			s.stateLeave = state
+		}
+	}
+	if x, ok := pdu["metadata"]; ok {
+		s.metadata = x
+	}
+	if x, ok := pdu["abandoned"]; ok {
+		if abandoned, ok := x.(bool); ok {
+			s.abandoned = abandoned
+		}
+	}
 	if x, ok := pdu["subscription"]; ok {
 		if subscription, ok := x.(string); ok {
 			s.subscription = subscription
@@ -143,11 +300,27 @@ func (s *Subscription) Clock() string {
 	return s.clock
 }
 
-// Files returns a list of of relative filepaths.
-func (s *Subscription) Files() []interface{} {
+// Files returns the files that changed, relative to the root. If the
+// subscription requested a single field other than name, use
+// DecodeFiles instead.
+func (s *Subscription) Files() []File {
 	return s.files
 }
 
+// DecodeFiles returns the files that changed, given the fields
+// requested by the subscription. Unlike Files, it decodes the bare
+// values that Watchman returns when a single field other than name is
+// requested.
+func (s *Subscription) DecodeFiles(fields query.Fields) []File {
+	return NewFiles(s.rawFiles, fields)
+}
+
+// Canceled indicates if the Watchman server canceled the subscription,
+// for example because the watched root was deleted.
+func (s *Subscription) Canceled() bool {
+	return s.canceled
+}
+
 // IsFreshInstance indicates if the notification was sent because
 // of a newly established subscription, or observed changes.
 func (s *Subscription) IsFreshInstance() bool {
@@ -163,3 +336,27 @@ func (s *Subscription) Root() string {
 func (s *Subscription) Subscription() string {
 	return s.subscription
 }
+
+// StateEnter returns the name of the state entered, if the notification
+// was sent because of the state-enter command.
+func (s *Subscription) StateEnter() string {
+	return s.stateEnter
+}
+
+// StateLeave returns the name of the state vacated, if the notification
+// was sent because of the state-leave command, or because the client
+// that entered the state disconnected.
+func (s *Subscription) StateLeave() string {
+	return s.stateLeave
+}
+
+// Metadata returns the metadata passed to state-enter or state-leave.
+func (s *Subscription) Metadata() interface{} {
+	return s.metadata
+}
+
+// Abandoned indicates if the state was vacated because the client that
+// entered it disconnected.
+func (s *Subscription) Abandoned() bool {
+	return s.abandoned
+}
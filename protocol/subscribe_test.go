@@ -58,6 +58,62 @@ func TestSubscribe(t *testing.T) {
 				subscription: "sub1",
 			},
 		},
+		{
+			request: `["subscribe","/tmp","sub1",{"defer":["hg.update"],` +
+				`"drop":["build.codegen"],"fields":["name"],"sync_timeout":100}]` + "\n",
+			response: `{"clock":"c:1531594843:978:9:345","subscribe":"sub1","version":"4.9.0"}` + "\n",
+			req: &SubscribeRequest{
+				Root: "/tmp",
+				Name: "sub1",
+				Query: &query.Query{
+					Fields:      query.Fields{query.FName},
+					SyncTimeout: 100,
+				},
+				Defer: []string{"hg.update"},
+				Drop:  []string{"build.codegen"},
+			},
+			res: &SubscribeResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":   "4.9.0",
+						"clock":     "c:1531594843:978:9:345",
+						"subscribe": "sub1",
+					},
+					version: "4.9.0",
+				},
+				clock:        "c:1531594843:978:9:345",
+				subscription: "sub1",
+			},
+		},
+		{
+			request: `["subscribe","/tmp","sub1",{"defer_vcs":false,` +
+				`"empty_on_fresh_instance":true,"fields":["name"],` +
+				`"settle_period":20,"settle_timeout":1000,` +
+				`"since":"c:1531594843:978:9:300"}]` + "\n",
+			response: `{"clock":"c:1531594843:978:9:345","subscribe":"sub1","version":"4.9.0"}` + "\n",
+			req: &SubscribeRequest{
+				Root:                 "/tmp",
+				Name:                 "sub1",
+				Query:                &query.Query{Fields: query.Fields{query.FName}},
+				DisableDeferVCS:      true,
+				SettlePeriod:         20,
+				SettleTimeout:        1000,
+				EmptyOnFreshInstance: true,
+				Since:                "c:1531594843:978:9:300",
+			},
+			res: &SubscribeResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":   "4.9.0",
+						"clock":     "c:1531594843:978:9:345",
+						"subscribe": "sub1",
+					},
+					version: "4.9.0",
+				},
+				clock:        "c:1531594843:978:9:345",
+				subscription: "sub1",
+			},
+		},
 	} {
 		requested := &bytes.Buffer{}
 		c := &Connection{
@@ -83,6 +139,31 @@ func TestSubscribe(t *testing.T) {
 	}
 }
 
+func TestSubscribeCapabilities(t *testing.T) {
+	require := require.New(t)
+
+	req := &SubscribeRequest{Root: "/tmp", Name: "sub1"}
+	require.Equal([]string{"cmd-subscribe"}, req.Capabilities())
+
+	req = &SubscribeRequest{
+		Root: "/tmp",
+		Name: "sub1",
+		Query: &query.Query{
+			RelativeRoot: "src",
+			DedupResults: true,
+			SyncTimeout:  100,
+		},
+		Drop: []string{"hg.update"},
+	}
+	require.Equal([]string{
+		"cmd-subscribe",
+		"cmd-state-enter",
+		"relative_root",
+		"dedup_results",
+		"clock-sync-timeout",
+	}, req.Capabilities())
+}
+
 func TestNewSubscription(t *testing.T) {
 	require := require.New(t)
 
@@ -127,15 +208,108 @@ func TestNewSubscription(t *testing.T) {
 				root:            "/tmp",
 				subscription:    "sub2",
 				isFreshInstance: true,
-				files: []interface{}{
+				rawFiles: []interface{}{
 					map[string]interface{}{"name": "foo/main.go", "exists": true},
 					map[string]interface{}{"name": "bar/main.go", "exists": true},
 				},
+				files: []File{
+					{Name: "foo/main.go", Exists: true},
+					{Name: "bar/main.go", Exists: true},
+				},
+			},
+		},
+		{
+			pdu: ResponsePDU{
+				"unilateral":   true,
+				"subscription": "sub2",
+				"root":         "/tmp",
+				"version":      "4.9.0",
+				"canceled":     true,
+			},
+			sub: &Subscription{
+				response: response{
+					pdu: ResponsePDU{
+						"unilateral":   true,
+						"subscription": "sub2",
+						"root":         "/tmp",
+						"version":      "4.9.0",
+						"canceled":     true,
+					},
+					version: "4.9.0",
+				},
+				root:         "/tmp",
+				subscription: "sub2",
+				canceled:     true,
+			},
+		},
+		{
+			pdu: ResponsePDU{
+				"unilateral":   true,
+				"subscription": "sub2",
+				"root":         "/tmp",
+				"version":      "4.9.0",
+				"clock":        "c:1531594843:978:9:828",
+				"state-enter":  "hg.update",
+				"metadata":     map[string]interface{}{"rev": "abc"},
+			},
+			sub: &Subscription{
+				response: response{
+					pdu: ResponsePDU{
+						"unilateral":   true,
+						"subscription": "sub2",
+						"root":         "/tmp",
+						"version":      "4.9.0",
+						"clock":        "c:1531594843:978:9:828",
+						"state-enter":  "hg.update",
+						"metadata":     map[string]interface{}{"rev": "abc"},
+					},
+					version: "4.9.0",
+				},
+				clock:        "c:1531594843:978:9:828",
+				root:         "/tmp",
+				subscription: "sub2",
+				stateEnter:   "hg.update",
+				metadata:     map[string]interface{}{"rev": "abc"},
+			},
+		},
+		{
+			pdu: ResponsePDU{
+				"unilateral":   true,
+				"subscription": "sub2",
+				"root":         "/tmp",
+				"version":      "4.9.0",
+				"clock":        "c:1531594843:978:9:829",
+				"state-leave":  "hg.update",
+				"abandoned":    true,
+			},
+			sub: &Subscription{
+				response: response{
+					pdu: ResponsePDU{
+						"unilateral":   true,
+						"subscription": "sub2",
+						"root":         "/tmp",
+						"version":      "4.9.0",
+						"clock":        "c:1531594843:978:9:829",
+						"state-leave":  "hg.update",
+						"abandoned":    true,
+					},
+					version: "4.9.0",
+				},
+				clock:        "c:1531594843:978:9:829",
+				root:         "/tmp",
+				subscription: "sub2",
+				stateLeave:   "hg.update",
+				abandoned:    true,
 			},
 		},
 	} {
 		actual := NewSubscription(tc.pdu)
 		require.Equal(tc.sub, actual)
+		require.Equal(tc.sub.canceled, actual.Canceled())
+		require.Equal(tc.sub.stateEnter, actual.StateEnter())
+		require.Equal(tc.sub.stateLeave, actual.StateLeave())
+		require.Equal(tc.sub.metadata, actual.Metadata())
+		require.Equal(tc.sub.abandoned, actual.Abandoned())
 	}
 }
 
@@ -146,8 +320,8 @@ func TestSubscription(t *testing.T) {
 		clock:        "c:2642605954:867:8:937",
 		root:         "/projects/x",
 		subscription: "sub42",
-		files: []any{
-			map[string]any{"name": "secrets.txt", "exists": true},
+		files: []File{
+			{Name: "secrets.txt", Exists: true},
 		},
 		isFreshInstance: true,
 	}
@@ -155,7 +329,11 @@ func TestSubscription(t *testing.T) {
 	require.Equal(true, s.IsFreshInstance())
 	require.Equal("/projects/x", s.Root())
 	require.Equal("sub42", s.Subscription())
-	require.Equal([]any{
-		map[string]any{"name": "secrets.txt", "exists": true},
+	require.Equal([]File{
+		{Name: "secrets.txt", Exists: true},
 	}, s.Files())
+
+	// a single field other than name is returned as bare values
+	s = NewSubscription(ResponsePDU{"subscription": "sub42", "files": []interface{}{true, false}})
+	require.Equal([]File{{Exists: true}, {Exists: false}}, s.DecodeFiles(query.Fields{query.FExists}))
 }
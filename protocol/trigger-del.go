@@ -0,0 +1,54 @@
+package protocol
+
+/*
+["trigger-del", "/tmp", "assets"]
+{"deleted":true, "trigger":"assets", "version":"4.9.0"}
+*/
+
+// A TriggerDelRequest represents the Watchman trigger-del command.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/trigger-del.html
+type TriggerDelRequest struct {
+	Root string
+	Name string
+}
+
+// Args returns values used to encode a request PDU.
+func (req *TriggerDelRequest) Args() []interface{} {
+	return []interface{}{"trigger-del", req.Root, req.Name}
+}
+
+// A TriggerDelResponse represents a response to the Watchman trigger-del command.
+type TriggerDelResponse struct {
+	response
+	deleted bool
+	trigger string
+}
+
+// NewTriggerDelResponse converts a ResponsePDU to TriggerDelResponse
+func NewTriggerDelResponse(pdu ResponsePDU) (res *TriggerDelResponse) {
+	res = &TriggerDelResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["deleted"]; ok {
+		if deleted, ok := x.(bool); ok {
+			res.deleted = deleted
+		}
+	}
+	if x, ok := pdu["trigger"]; ok {
+		if trigger, ok := x.(string); ok {
+			res.trigger = trigger
+		}
+	}
+	return
+}
+
+// Deleted returns the status of the deleted trigger.
+func (res *TriggerDelResponse) Deleted() bool {
+	return res.deleted
+}
+
+// Trigger returns the name of the deleted trigger.
+func (res *TriggerDelResponse) Trigger() string {
+	return res.trigger
+}
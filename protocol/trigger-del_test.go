@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTriggerDel(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *TriggerDelRequest
+		res      *TriggerDelResponse
+	}{
+		{
+			request:  `["trigger-del","/tmp","assets"]` + "\n",
+			response: `{"deleted":true, "trigger":"assets", "version":"4.9.0"}` + "\n",
+			req: &TriggerDelRequest{
+				Root: "/tmp",
+				Name: "assets",
+			},
+			res: &TriggerDelResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version": "4.9.0",
+						"deleted": true,
+						"trigger": "assets",
+					},
+					version: "4.9.0",
+				},
+				deleted: true,
+				trigger: "assets",
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewTriggerDelResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal(true, actual.Deleted())
+		require.Equal("assets", actual.Trigger())
+	}
+}
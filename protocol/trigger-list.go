@@ -0,0 +1,54 @@
+package protocol
+
+/*
+["trigger-list", "/tmp"]
+{"version":"4.9.0",
+ "triggers":[{
+  "name":"assets",
+  "command":["make"],
+  "expression":["suffix","go"],
+  "append_files":true,
+  "stdin":["name","size"]
+ }]}
+*/
+
+// A TriggerListRequest represents the Watchman trigger-list command.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/trigger-list.html
+type TriggerListRequest struct {
+	Root string
+}
+
+// Args returns values used to encode a request PDU.
+func (req *TriggerListRequest) Args() []interface{} {
+	return []interface{}{"trigger-list", req.Root}
+}
+
+// A TriggerListResponse represents a response to the Watchman trigger-list command.
+type TriggerListResponse struct {
+	response
+	triggers []TriggerSpec
+}
+
+// NewTriggerListResponse converts a ResponsePDU to TriggerListResponse
+func NewTriggerListResponse(pdu ResponsePDU) (res *TriggerListResponse) {
+	res = &TriggerListResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["triggers"]; ok {
+		if triggers, ok := x.([]interface{}); ok {
+			res.triggers = make([]TriggerSpec, 0, len(triggers))
+			for _, trigger := range triggers {
+				if trigger, ok := trigger.(map[string]interface{}); ok {
+					res.triggers = append(res.triggers, newTriggerSpec(trigger))
+				}
+			}
+		}
+	}
+	return
+}
+
+// Triggers returns the triggers registered on the watched root.
+func (res *TriggerListResponse) Triggers() []TriggerSpec {
+	return res.triggers
+}
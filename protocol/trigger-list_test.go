@@ -0,0 +1,50 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+func TestTriggerList(t *testing.T) {
+	require := require.New(t)
+
+	requested := &bytes.Buffer{}
+	c := &Connection{
+		reader: bufio.NewReader(bytes.NewReader([]byte(`{"version":"4.9.0",` +
+			`"triggers":[{"name":"assets","command":["make"],` +
+			`"expression":["suffix","go"],"append_files":true,` +
+			`"stdin":["name","size"],"stdout":">>/tmp/out"},` +
+			`{"name":"lint","command":["lint"],"stdin":"NAME_PER_LINE"}]}` + "\n"))),
+		socket: requested,
+	}
+
+	err := c.Send(&TriggerListRequest{Root: "/tmp"})
+	require.NoError(err)
+	require.Equal(`["trigger-list","/tmp"]`+"\n", requested.String())
+
+	pdu, err := c.Recv()
+	require.NoError(err)
+	res := NewTriggerListResponse(pdu)
+	require.Equal("4.9.0", res.Version())
+	require.Equal([]TriggerSpec{
+		{
+			Name:        "assets",
+			Command:     []string{"make"},
+			Expression:  query.TSuffix{"go"},
+			AppendFiles: true,
+			Stdin:       StdinJSON,
+			StdinFields: query.Fields{query.FName, query.FSize},
+			Stdout:      Redirect{Path: "/tmp/out", Append: true},
+		},
+		{
+			Name:    "lint",
+			Command: []string{"lint"},
+			Stdin:   StdinNamePerLine,
+		},
+	}, res.Triggers())
+}
@@ -0,0 +1,227 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+/*
+["trigger","/tmp",{"name":"assets","command":["make"],
+ "expression":["suffix","go"],"append_files":true}]
+{"triggerid":"assets","disposition":"created","version":"4.9.0"}
+*/
+
+// TriggerStdin selects what a trigger command reads on stdin.
+type TriggerStdin int
+
+const (
+	// StdinDevNull connects stdin to /dev/null.
+	StdinDevNull TriggerStdin = iota
+	// StdinNamePerLine writes the name of each changed file, one per
+	// line.
+	StdinNamePerLine
+	// StdinJSON writes a JSON array of the changed files, with the
+	// fields listed in TriggerSpec.StdinFields.
+	StdinJSON
+)
+
+// A Redirect sends the output of a trigger command to a file.
+type Redirect struct {
+	Path   string
+	Append bool
+}
+
+// String returns the redirect in Watchman syntax: ">path" or ">>path".
+// It returns an empty string if Path is empty.
+func (r Redirect) String() string {
+	if r.Path == "" {
+		return ""
+	}
+	if r.Append {
+		return ">>" + r.Path
+	}
+	return ">" + r.Path
+}
+
+func parseRedirect(s string) Redirect {
+	if strings.HasPrefix(s, ">>") {
+		return Redirect{Path: s[2:], Append: true}
+	}
+	return Redirect{Path: strings.TrimPrefix(s, ">")}
+}
+
+// A TriggerSpec describes a command that Watchman runs when files
+// matching an expression change.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/trigger.html
+type TriggerSpec struct {
+	Name       string
+	Command    []string
+	Expression query.Term
+	// AppendFiles appends the names of the changed files to Command.
+	AppendFiles bool
+	Stdin       TriggerStdin
+	StdinFields query.Fields
+	// MaxFilesStdin limits the number of files written to stdin; zero
+	// means no limit.
+	MaxFilesStdin int
+	// Stdout and Stderr are inherited from the Watchman server when
+	// their Path is empty.
+	Stdout       Redirect
+	Stderr       Redirect
+	Chdir        string
+	RelativeRoot string
+}
+
+// MarshalJSON encodes the trigger definition expected by the trigger
+// command.
+func (spec TriggerSpec) MarshalJSON() ([]byte, error) {
+	res := map[string]interface{}{
+		"name":    spec.Name,
+		"command": spec.Command,
+	}
+
+	if spec.Expression != nil {
+		res["expression"] = spec.Expression
+	}
+
+	if spec.AppendFiles {
+		res["append_files"] = true
+	}
+
+	switch spec.Stdin {
+	case StdinNamePerLine:
+		res["stdin"] = "NAME_PER_LINE"
+	case StdinJSON:
+		res["stdin"] = spec.StdinFields
+	}
+
+	if spec.MaxFilesStdin > 0 {
+		res["max_files_stdin"] = spec.MaxFilesStdin
+	}
+
+	if spec.Stdout.Path != "" {
+		res["stdout"] = spec.Stdout.String()
+	}
+
+	if spec.Stderr.Path != "" {
+		res["stderr"] = spec.Stderr.String()
+	}
+
+	if spec.Chdir != "" {
+		res["chdir"] = spec.Chdir
+	}
+
+	if spec.RelativeRoot != "" {
+		res["relative_root"] = spec.RelativeRoot
+	}
+
+	return json.Marshal(res)
+}
+
+// newTriggerSpec converts a trigger definition, as returned by the
+// trigger-list command, to a TriggerSpec.
+func newTriggerSpec(x map[string]interface{}) (spec TriggerSpec) {
+	setString(&spec.Name, x["name"])
+	if command, ok := x["command"].([]interface{}); ok {
+		for _, arg := range command {
+			if arg, ok := arg.(string); ok {
+				spec.Command = append(spec.Command, arg)
+			}
+		}
+	}
+	if expression, ok := x["expression"]; ok {
+		term, err := query.DecodeTerm(expression)
+		if err != nil {
+			// keep terms unknown to package query as they are
+			term = rawTerm{expression}
+		}
+		spec.Expression = term
+	}
+	setBool(&spec.AppendFiles, x["append_files"])
+
+	switch stdin := x["stdin"].(type) {
+	case string:
+		if stdin == "NAME_PER_LINE" {
+			spec.Stdin = StdinNamePerLine
+		}
+	case []interface{}:
+		spec.Stdin = StdinJSON
+		for _, field := range stdin {
+			if field, ok := field.(string); ok {
+				spec.StdinFields = append(spec.StdinFields, query.Field(field))
+			}
+		}
+	}
+
+	spec.MaxFilesStdin = int(toInt(x["max_files_stdin"]))
+	if stdout, ok := x["stdout"].(string); ok {
+		spec.Stdout = parseRedirect(stdout)
+	}
+	if stderr, ok := x["stderr"].(string); ok {
+		spec.Stderr = parseRedirect(stderr)
+	}
+	setString(&spec.Chdir, x["chdir"])
+	setString(&spec.RelativeRoot, x["relative_root"])
+	return
+}
+
+// rawTerm is an expression term decoded from a response PDU. It
+// encodes to the same JSON value it was decoded from.
+type rawTerm struct {
+	value interface{}
+}
+
+func (t rawTerm) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.value)
+}
+
+// A TriggerRequest represents the Watchman trigger command.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/trigger.html
+type TriggerRequest struct {
+	Root string
+	Spec *TriggerSpec
+}
+
+// Args returns values used to encode a request PDU.
+func (req *TriggerRequest) Args() []interface{} {
+	return []interface{}{"trigger", req.Root, req.Spec}
+}
+
+// A TriggerResponse represents a response to the Watchman trigger command.
+type TriggerResponse struct {
+	response
+	disposition string
+	triggerID   string
+}
+
+// NewTriggerResponse converts a ResponsePDU to TriggerResponse
+func NewTriggerResponse(pdu ResponsePDU) (res *TriggerResponse) {
+	res = &TriggerResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["disposition"]; ok {
+		if disposition, ok := x.(string); ok {
+			res.disposition = disposition
+		}
+	}
+	if x, ok := pdu["triggerid"]; ok {
+		if triggerID, ok := x.(string); ok {
+			res.triggerID = triggerID
+		}
+	}
+	return
+}
+
+// Disposition returns "created", "replaced", or "already_defined".
+func (res *TriggerResponse) Disposition() string {
+	return res.disposition
+}
+
+// TriggerID returns the name of the trigger.
+func (res *TriggerResponse) TriggerID() string {
+	return res.triggerID
+}
@@ -0,0 +1,142 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+func TestTrigger(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *TriggerRequest
+		res      *TriggerResponse
+	}{
+		{
+			request: `["trigger","/tmp",{"command":["make"],"name":"assets"}]` + "\n",
+			response: `{"triggerid":"assets","disposition":"created","version":"4.9.0"}` +
+				"\n",
+			req: &TriggerRequest{
+				Root: "/tmp",
+				Spec: &TriggerSpec{Name: "assets", Command: []string{"make"}},
+			},
+			res: &TriggerResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":     "4.9.0",
+						"triggerid":   "assets",
+						"disposition": "created",
+					},
+					version: "4.9.0",
+				},
+				disposition: "created",
+				triggerID:   "assets",
+			},
+		},
+		{
+			request: `["trigger","/tmp",{"append_files":true,"chdir":"web",` +
+				`"command":["make","-C","web"],"expression":["suffix","css"],` +
+				`"max_files_stdin":10,"name":"assets","relative_root":"web",` +
+				`"stderr":"\u003e\u003e/tmp/err","stdin":["name","size"],` +
+				`"stdout":"\u003e/tmp/out"}]` + "\n",
+			response: `{"triggerid":"assets","disposition":"replaced","version":"4.9.0"}` +
+				"\n",
+			req: &TriggerRequest{
+				Root: "/tmp",
+				Spec: &TriggerSpec{
+					Name:          "assets",
+					Command:       []string{"make", "-C", "web"},
+					Expression:    query.TSuffix{"css"},
+					AppendFiles:   true,
+					Stdin:         StdinJSON,
+					StdinFields:   query.Fields{query.FName, query.FSize},
+					MaxFilesStdin: 10,
+					Stdout:        Redirect{Path: "/tmp/out"},
+					Stderr:        Redirect{Path: "/tmp/err", Append: true},
+					Chdir:         "web",
+					RelativeRoot:  "web",
+				},
+			},
+			res: &TriggerResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":     "4.9.0",
+						"triggerid":   "assets",
+						"disposition": "replaced",
+					},
+					version: "4.9.0",
+				},
+				disposition: "replaced",
+				triggerID:   "assets",
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewTriggerResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal("assets", actual.TriggerID())
+	}
+}
+
+func TestTriggerSpecRoundTrip(t *testing.T) {
+	require := require.New(t)
+
+	for _, spec := range []TriggerSpec{
+		{Name: "a", Command: []string{"true"}},
+		{
+			Name:          "b",
+			Command:       []string{"make"},
+			Expression:    query.TSuffix{"go"},
+			AppendFiles:   true,
+			Stdin:         StdinNamePerLine,
+			MaxFilesStdin: 3,
+			Stdout:        Redirect{Path: "/tmp/out", Append: true},
+			Stderr:        Redirect{Path: "/tmp/err"},
+			Chdir:         "src",
+			RelativeRoot:  "src",
+		},
+		{
+			Name:        "c",
+			Command:     []string{"cat"},
+			Stdin:       StdinJSON,
+			StdinFields: query.Fields{query.FName},
+		},
+	} {
+		b, err := json.Marshal(spec)
+		require.NoError(err)
+
+		var x map[string]interface{}
+		require.NoError(json.Unmarshal(b, &x))
+		decoded := newTriggerSpec(x)
+
+		actual, err := json.Marshal(decoded)
+		require.NoError(err)
+		require.JSONEq(string(b), string(actual))
+		require.Equal(spec.Stdin, decoded.Stdin)
+		require.Equal(spec.Stdout, decoded.Stdout)
+	}
+}
@@ -0,0 +1,65 @@
+package protocol
+
+/*
+["version",{"optional":["term-pcre"],"required":["cmd-subscribe"]}]
+{"version":"4.9.0","capabilities":{"cmd-subscribe":true,"term-pcre":false}}
+
+["version",{"required":["grant-three-wishes"]}]
+{"error":"client required capability `grant-three-wishes` is not supported by this server","version":"4.9.0"}
+*/
+
+// A VersionRequest represents the Watchman version command. The
+// Watchman server reports which of the Required and Optional
+// capabilities it supports, and fails if a Required capability is not
+// supported.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/version.html
+type VersionRequest struct {
+	Required []string
+	Optional []string
+}
+
+// Args returns values used to encode a request PDU.
+func (req *VersionRequest) Args() []interface{} {
+	if len(req.Required) == 0 && len(req.Optional) == 0 {
+		return []interface{}{"version"}
+	}
+
+	opts := map[string][]string{}
+	if len(req.Required) > 0 {
+		opts["required"] = req.Required
+	}
+	if len(req.Optional) > 0 {
+		opts["optional"] = req.Optional
+	}
+	return []interface{}{"version", opts}
+}
+
+// A VersionResponse represents a response to the Watchman version command.
+type VersionResponse struct {
+	response
+	capabilities map[string]bool
+}
+
+// NewVersionResponse converts a ResponsePDU to VersionResponse
+func NewVersionResponse(pdu ResponsePDU) (res *VersionResponse) {
+	res = &VersionResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["capabilities"]; ok {
+		if capabilities, ok := x.(map[string]interface{}); ok {
+			res.capabilities = make(map[string]bool, len(capabilities))
+			for capability, x := range capabilities {
+				supported, _ := x.(bool)
+				res.capabilities[capability] = supported
+			}
+		}
+	}
+	return
+}
+
+// Capabilities reports, for each requested capability, whether the
+// Watchman server supports it.
+func (res *VersionResponse) Capabilities() map[string]bool {
+	return res.capabilities
+}
@@ -0,0 +1,78 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVersion(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *VersionRequest
+		res      *VersionResponse
+	}{
+		{
+			request:  `["version"]` + "\n",
+			response: `{"version":"4.9.0"}` + "\n",
+			req:      &VersionRequest{},
+			res: &VersionResponse{
+				response: response{
+					pdu:     ResponsePDU{"version": "4.9.0"},
+					version: "4.9.0",
+				},
+			},
+		},
+		{
+			request: `["version",{"optional":["term-pcre"],"required":["cmd-subscribe"]}]` + "\n",
+			response: `{"capabilities":{"cmd-subscribe":true,"term-pcre":false},` +
+				`"version":"4.9.0"}` + "\n",
+			req: &VersionRequest{
+				Required: []string{"cmd-subscribe"},
+				Optional: []string{"term-pcre"},
+			},
+			res: &VersionResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version": "4.9.0",
+						"capabilities": map[string]interface{}{
+							"cmd-subscribe": true,
+							"term-pcre":     false,
+						},
+					},
+					version: "4.9.0",
+				},
+				capabilities: map[string]bool{
+					"cmd-subscribe": true,
+					"term-pcre":     false,
+				},
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewVersionResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal(tc.res.capabilities, actual.Capabilities())
+	}
+}
@@ -0,0 +1,46 @@
+package protocol
+
+/*
+["watch-del-all"]
+{"roots":["/tmp","/home/wez/watchman"],"version":"4.9.0"}
+*/
+
+// A WatchDelAllRequest represents the Watchman watch-del-all command.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/watch-del-all.html
+type WatchDelAllRequest struct{}
+
+// Args returns values used to encode a request PDU.
+func (req *WatchDelAllRequest) Args() []interface{} {
+	return []interface{}{"watch-del-all"}
+}
+
+// A WatchDelAllResponse represents a response to the Watchman
+// watch-del-all command.
+type WatchDelAllResponse struct {
+	response
+	roots []string
+}
+
+// NewWatchDelAllResponse converts a ResponsePDU to WatchDelAllResponse
+func NewWatchDelAllResponse(pdu ResponsePDU) (res *WatchDelAllResponse) {
+	res = &WatchDelAllResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["roots"]; ok {
+		if roots, ok := x.([]interface{}); ok {
+			res.roots = make([]string, 0, len(roots))
+			for _, root := range roots {
+				if root, ok := root.(string); ok {
+					res.roots = append(res.roots, root)
+				}
+			}
+		}
+	}
+	return
+}
+
+// Roots returns the roots that are no longer watched.
+func (res *WatchDelAllResponse) Roots() []string {
+	return res.roots
+}
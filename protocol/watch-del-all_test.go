@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchDelAll(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *WatchDelAllRequest
+		res      *WatchDelAllResponse
+	}{
+		{
+			request:  `["watch-del-all"]` + "\n",
+			response: `{"roots":["/src","/tmp"],"version":"4.9.0"}` + "\n",
+			req:      &WatchDelAllRequest{},
+			res: &WatchDelAllResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version": "4.9.0",
+						"roots":   []interface{}{"/src", "/tmp"},
+					},
+					version: "4.9.0",
+				},
+				roots: []string{"/src", "/tmp"},
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewWatchDelAllResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.Equal([]string{"/src", "/tmp"}, actual.Roots())
+	}
+}
@@ -0,0 +1,53 @@
+package protocol
+
+/*
+["watch-del","/tmp"]
+{"watch-del":true,"root":"/tmp","version":"4.9.0"}
+*/
+
+// A WatchDelRequest represents the Watchman watch-del command.
+//
+// See also: https://facebook.github.io/watchman/docs/cmd/watch-del.html
+type WatchDelRequest struct {
+	Root string
+}
+
+// Args returns values used to encode a request PDU.
+func (req *WatchDelRequest) Args() []interface{} {
+	return []interface{}{"watch-del", req.Root}
+}
+
+// A WatchDelResponse represents a response to the Watchman watch-del command.
+type WatchDelResponse struct {
+	response
+	deleted bool
+	root    string
+}
+
+// NewWatchDelResponse converts a ResponsePDU to WatchDelResponse
+func NewWatchDelResponse(pdu ResponsePDU) (res *WatchDelResponse) {
+	res = &WatchDelResponse{}
+	res.response.init(pdu)
+
+	if x, ok := pdu["watch-del"]; ok {
+		if deleted, ok := x.(bool); ok {
+			res.deleted = deleted
+		}
+	}
+	if x, ok := pdu["root"]; ok {
+		if root, ok := x.(string); ok {
+			res.root = root
+		}
+	}
+	return
+}
+
+// Deleted indicates if the root was being watched.
+func (res *WatchDelResponse) Deleted() bool {
+	return res.deleted
+}
+
+// Root returns the root that is no longer watched.
+func (res *WatchDelResponse) Root() string {
+	return res.root
+}
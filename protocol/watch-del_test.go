@@ -0,0 +1,60 @@
+package protocol
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchDel(t *testing.T) {
+	require := require.New(t)
+
+	for _, tc := range []struct {
+		request  string
+		response string
+		req      *WatchDelRequest
+		res      *WatchDelResponse
+	}{
+		{
+			request:  `["watch-del","/tmp"]` + "\n",
+			response: `{"root":"/tmp","version":"4.9.0","watch-del":true}` + "\n",
+			req:      &WatchDelRequest{Root: "/tmp"},
+			res: &WatchDelResponse{
+				response: response{
+					pdu: ResponsePDU{
+						"version":   "4.9.0",
+						"root":      "/tmp",
+						"watch-del": true,
+					},
+					version: "4.9.0",
+				},
+				deleted: true,
+				root:    "/tmp",
+			},
+		},
+	} {
+		requested := &bytes.Buffer{}
+		c := &Connection{
+			reader: bufio.NewReader(
+				bytes.NewReader([]byte(tc.response)),
+			),
+			socket: requested,
+		}
+
+		err := c.Send(tc.req)
+		require.NoError(err)
+		require.Equal(tc.request, requested.String())
+
+		pdu, err := c.Recv()
+		require.NoError(err)
+		require.NotNil(pdu)
+		actual := NewWatchDelResponse(pdu)
+		require.Equal(tc.res, actual)
+		require.Equal("", actual.Warning())
+		require.Equal("4.9.0", actual.Version())
+		require.True(actual.Deleted())
+		require.Equal("/tmp", actual.Root())
+	}
+}
@@ -0,0 +1,161 @@
+package watchman
+
+import (
+	"time"
+
+	"github.com/cdmistman/watchman/protocol"
+)
+
+const (
+	defaultReconnectInterval = time.Second
+	maxReconnectInterval     = time.Minute
+)
+
+// A ReconnectNotification is emitted after a Client configured with
+// Options.Reconnect reconnects to the Watchman server and restores its
+// watches and subscriptions.
+//
+// Changes that occurred while the Client was disconnected may have been
+// missed. Restored subscriptions resume from the clock of their last
+// notification, but if the server restarted, they start over with a
+// fresh instance notification. Either way, consumers should treat this
+// notification as a signal to resynchronize.
+type ReconnectNotification struct {
+	// Err is the error that caused the connection to be lost.
+	Err error
+	// Subscriptions lists the names of the restored subscriptions.
+	Subscriptions []string
+	// RestoreErrors lists errors that prevented watches or
+	// subscriptions from being restored.
+	RestoreErrors []error
+}
+
+// reconnect connects to the Watchman server again, after the event
+// loop lost its connection because of cause. It returns nil if the
+// Client is closed first.
+//
+// Requests made while disconnected fail immediately.
+func (l *lane) reconnect(cause error) *protocol.Connection {
+	c := l.client
+	delay := c.opts.ReconnectInterval
+	if delay <= 0 {
+		delay = defaultReconnectInterval
+	}
+
+	for {
+		if !l.wait(delay) {
+			return nil
+		}
+
+		delay *= 2
+		if delay > maxReconnectInterval {
+			delay = maxReconnectInterval
+		}
+
+		conn, err := protocol.ConnectWithOptions(c.closed, c.opts.Connection)
+		if err != nil {
+			continue
+		}
+
+		n, err := l.restore(conn, cause)
+		if err != nil {
+			conn.Close()
+			continue
+		}
+
+		c.emit(n)
+		return conn
+	}
+}
+
+// wait sleeps for delay, failing any requests made in the meantime. It
+// returns false if the Client is closed first.
+func (l *lane) wait(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case call := <-l.requests:
+			call.abandon()
+		case <-l.client.closed.Done():
+			return false
+		case <-timer.C:
+			return true
+		}
+	}
+}
+
+// restore re-issues watch-project for every watched root, log-level
+// if l is the command lane, and subscribe for every subscription
+// carried by l, over a new connection. It only returns an error if the
+// new connection is also lost, or the Client is closed.
+func (l *lane) restore(
+	conn *protocol.Connection,
+	cause error,
+) (*ReconnectNotification, error) {
+	c := l.client
+	c.mu.Lock()
+	roots := make([]string, 0, len(c.watches))
+	for root := range c.watches {
+		roots = append(roots, root)
+	}
+	logLevel := c.logLevel
+	subs := make([]*Subscription, 0, len(c.subs))
+	reqs := make([]*protocol.SubscribeRequest, 0, len(c.subs))
+	for _, s := range c.subs {
+		if s.lane != l {
+			continue
+		}
+		subs = append(subs, s)
+		reqs = append(reqs, s.resubscribeRequest())
+	}
+	c.mu.Unlock()
+
+	n := &ReconnectNotification{Err: cause}
+	for _, root := range roots {
+		req := &protocol.WatchProjectRequest{Path: root}
+		if _, err := roundTrip(c.closed, conn, req, c.dispatch); err != nil {
+			if _, ok := err.(*protocol.WatchmanError); !ok {
+				return nil, err
+			}
+			n.RestoreErrors = append(n.RestoreErrors, err)
+		}
+	}
+	if l == c.commands && logLevel != "" && logLevel != protocol.LogOff {
+		req := &protocol.LogLevelRequest{Level: logLevel}
+		if _, err := roundTrip(c.closed, conn, req, c.dispatch); err != nil {
+			if _, ok := err.(*protocol.WatchmanError); !ok {
+				return nil, err
+			}
+			n.RestoreErrors = append(n.RestoreErrors, err)
+		}
+	}
+	for i, req := range reqs {
+		if _, err := roundTrip(c.closed, conn, req, c.dispatch); err != nil {
+			if _, ok := err.(*protocol.WatchmanError); !ok {
+				return nil, err
+			}
+			n.RestoreErrors = append(n.RestoreErrors, err)
+			c.removeSubscription(subs[i], err)
+			continue
+		}
+		n.Subscriptions = append(n.Subscriptions, req.Name)
+	}
+
+	c.mu.Lock()
+	l.conn = conn
+	c.mu.Unlock()
+	return n, nil
+}
+
+// resubscribeRequest returns a request that recreates the subscription,
+// starting from the clock of its most recent notification. It must be
+// called with client.mu held.
+func (s *Subscription) resubscribeRequest() *protocol.SubscribeRequest {
+	req := s.opts.request(s.watch, s.name, s.query)
+	if s.clock != "" {
+		req.Since = s.clock
+	}
+	return req
+}
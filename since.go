@@ -0,0 +1,47 @@
+package watchman
+
+import (
+	"context"
+
+	"github.com/cdmistman/watchman/protocol"
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+// Since returns the files under the watched root that changed since
+// clock, and the clock to pass to the next call. fields selects the
+// metadata returned for each file; by default, the name, existence,
+// newness, size and mode of files are returned.
+//
+// If clock is empty or not recognized, for example because the
+// Watchman server restarted since it was issued, the result is a fresh
+// instance: Files lists every existing file rather than the changes,
+// and files deleted in the meantime are not reported. Callers that
+// maintain state derived from the files should then rebuild it.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/since.html
+func (w *Watch) Since(ctx context.Context, clock string, fields ...query.Field) (*QueryResult, error) {
+	if clock == "" || len(fields) > 0 || w.rel != "" {
+		// the since command cannot select fields or a relative root,
+		// and requires a clock, but the since generator of a query
+		// can do all of these
+		q := &query.Query{Fields: fields}
+		if clock != "" {
+			q.Generators = query.Generators{query.GSince: clock}
+		}
+		return w.Query(ctx, q)
+	}
+
+	req := &protocol.SinceRequest{Root: w.root, Clock: clock}
+	pdu, err := w.client.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := protocol.NewSinceResponse(pdu)
+	return &QueryResult{
+		IsFreshInstance: res.IsFreshInstance(),
+		Clock:           res.Clock(),
+		Files:           res.Files(),
+		Warning:         res.Warning(),
+	}, nil
+}
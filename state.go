@@ -0,0 +1,114 @@
+package watchman
+
+import (
+	"context"
+	"time"
+
+	"github.com/cdmistman/watchman/protocol"
+)
+
+// stateLeaveTimeout bounds how long WithState and Leave wait for the
+// Watchman server to vacate a state, as they do not have a context
+// that limits it.
+const stateLeaveTimeout = 30 * time.Second
+
+// A State is a named state asserted on a watched root, for example
+// while a version control update or a code generator is running.
+// Subscriptions are notified when a state is entered and left, and may
+// defer or drop notifications while it is asserted; see
+// SubscribeOptions.
+//
+// A state belongs to the connection that entered it. If the connection
+// is lost, the Watchman server vacates the state and reports it as
+// abandoned, and Leave fails.
+type State struct {
+	watch *Watch
+	name  string
+	clock string
+}
+
+// EnterState asserts a named state on the watched root. metadata is
+// optional, and is passed to subscribers in the state-enter
+// notification.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/state-enter.html
+func (w *Watch) EnterState(ctx context.Context, name string, metadata interface{}) (*State, error) {
+	req := &protocol.StateEnterRequest{
+		Root:     w.root,
+		Name:     name,
+		Metadata: metadata,
+	}
+	pdu, err := w.client.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := protocol.NewStateEnterResponse(pdu)
+	return &State{watch: w, name: name, clock: res.Clock()}, nil
+}
+
+// WithState enters a named state, runs fn, and leaves the state, even
+// if fn fails or ctx is done. It returns the error from fn, if any, or
+// else the error from leaving the state.
+//
+// If ctx is done by the time fn returns, leaving the state waits for
+// the Watchman server for at most 30 seconds.
+func (w *Watch) WithState(
+	ctx context.Context,
+	name string,
+	metadata interface{},
+	fn func(ctx context.Context) error,
+) (err error) {
+	state, err := w.EnterState(ctx, name, metadata)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		leaveCtx := ctx
+		if ctx.Err() != nil {
+			var cancel context.CancelFunc
+			leaveCtx, cancel = context.WithTimeout(context.Background(), stateLeaveTimeout)
+			defer cancel()
+		}
+		leaveErr := state.LeaveContext(leaveCtx, nil)
+		if err == nil {
+			err = leaveErr
+		}
+	}()
+	return fn(ctx)
+}
+
+// Name returns the name of the state.
+func (s *State) Name() string {
+	return s.name
+}
+
+// Clock returns the clock value of the watched root when the state
+// was entered.
+func (s *State) Clock() string {
+	return s.clock
+}
+
+// Leave vacates the state. metadata is optional, and is passed to
+// subscribers in the state-leave notification. Leave waits for the
+// Watchman server for at most 30 seconds; use LeaveContext to choose
+// the limit.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/state-leave.html
+func (s *State) Leave(metadata interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), stateLeaveTimeout)
+	defer cancel()
+	return s.LeaveContext(ctx, metadata)
+}
+
+// LeaveContext is like Leave, but gives up waiting for the Watchman
+// server when ctx is done.
+func (s *State) LeaveContext(ctx context.Context, metadata interface{}) error {
+	req := &protocol.StateLeaveRequest{
+		Root:     s.watch.root,
+		Name:     s.name,
+		Metadata: metadata,
+	}
+	_, err := s.watch.client.send(ctx, req)
+	return err
+}
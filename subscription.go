@@ -1,21 +1,185 @@
 package watchman
 
-import "github.com/cdmistman/watchman/protocol"
+import (
+	"context"
+	"errors"
+	"path"
+	"sync"
+
+	"github.com/cdmistman/watchman/protocol"
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+var (
+	// ErrUnsubscribed is reported by Subscription.Err after Unsubscribe.
+	ErrUnsubscribed = errors.New("watchman: unsubscribed")
+	// ErrSubscriptionCanceled is reported by Subscription.Err when the
+	// Watchman server cancels a subscription, for example because its
+	// root was deleted, or when its root is no longer watched.
+	ErrSubscriptionCanceled = errors.New("watchman: subscription canceled by server")
+)
 
 // A Subscription represents a request to receive notification of changes to a watched root.
 type Subscription struct {
 	client *Client
-	name   string
-	root   string
+	// lane carries the subscription
+	lane  *lane
+	name  string
+	root  string
+	watch string
+	query *query.Query
+	opts  SubscribeOptions
+	// clock is the clock of the most recent notification, and is
+	// guarded by client.mu
+	clock string
+
+	changes chan *ChangeNotification
+	done    chan struct{}
+	signal  chan struct{}
+
+	mu      sync.Mutex
+	pending []*ChangeNotification
+	err     error
+}
+
+// subscriptionKey identifies a subscription; Watchman scopes
+// subscription names to a watched root.
+type subscriptionKey struct {
+	root string
+	name string
+}
+
+func newSubscription(w *Watch, name string, q *query.Query, opts SubscribeOptions) *Subscription {
+	s := &Subscription{
+		client:  w.client,
+		lane:    w.client.subscriptionLane(subscriptionKey{root: w.root, name: name}),
+		name:    name,
+		root:    path.Join(w.root, w.rel),
+		watch:   w.root,
+		query:   q,
+		opts:    opts,
+		changes: make(chan *ChangeNotification),
+		done:    make(chan struct{}),
+		signal:  make(chan struct{}, 1),
+	}
+	return s
+}
+
+func (s *Subscription) key() subscriptionKey {
+	return subscriptionKey{root: s.watch, name: s.name}
+}
+
+// Changes returns a channel that emits the notifications of the
+// subscription, in the order they were received.
+//
+// Notifications are buffered, so a slow consumer does not delay other
+// subscriptions. After the subscription ends, the remaining buffered
+// notifications are emitted and then the channel is closed; see Err.
+// Closing the Client drops any buffered notifications.
+func (s *Subscription) Changes() <-chan *ChangeNotification {
+	return s.changes
+}
+
+// Done returns a channel that is closed when the subscription ends.
+func (s *Subscription) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns nil while the subscription is active. After it ends, Err
+// returns ErrUnsubscribed, ErrSubscriptionCanceled, ErrClosed, or the
+// error that caused the connection to the Watchman server to be lost.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Name returns the name registered to the subscription.
+func (s *Subscription) Name() string {
+	return s.name
 }
 
 // Unsubscribe cancels a subscription.
 func (s *Subscription) Unsubscribe() (err error) {
+	return s.UnsubscribeContext(context.Background())
+}
+
+// UnsubscribeContext is like Unsubscribe, but gives up waiting for the
+// Watchman server when ctx is done.
+func (s *Subscription) UnsubscribeContext(ctx context.Context) (err error) {
 	req := &protocol.UnsubscribeRequest{
 		Name: s.name,
 		Root: s.root,
 	}
-	_, err = s.client.send(req)
+	_, err = s.lane.send(ctx, req)
+	if err == nil {
+		s.client.removeSubscription(s, ErrUnsubscribed)
+	}
 
 	return
 }
+
+// discard unsubscribes a subscription that was created after its
+// caller gave up waiting for it, and ends it even if that fails.
+func (s *Subscription) discard() {
+	s.Unsubscribe()
+	s.client.removeSubscription(s, ErrUnsubscribed)
+}
+
+// push queues a notification for delivery.
+func (s *Subscription) push(cn *ChangeNotification) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.pending = append(s.pending, cn)
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+// end marks the subscription as ended because of err.
+func (s *Subscription) end(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+		close(s.done)
+	}
+	s.mu.Unlock()
+	s.notify()
+}
+
+func (s *Subscription) notify() {
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+}
+
+// deliver moves queued notifications to the changes channel, so that
+// the event loop never waits for the consumer. Notifications that have
+// not been delivered when the Client is closed are dropped.
+func (s *Subscription) deliver() {
+	defer close(s.changes)
+
+	for {
+		s.mu.Lock()
+		for len(s.pending) == 0 && s.err == nil {
+			s.mu.Unlock()
+			<-s.signal
+			s.mu.Lock()
+		}
+		if len(s.pending) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		cn := s.pending[0]
+		s.pending[0] = nil
+		s.pending = s.pending[1:]
+		s.mu.Unlock()
+
+		select {
+		case s.changes <- cn:
+		case <-s.client.closed.Done():
+			return
+		}
+	}
+}
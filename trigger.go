@@ -0,0 +1,66 @@
+package watchman
+
+import (
+	"context"
+	"path"
+
+	"github.com/cdmistman/watchman/protocol"
+	"github.com/cdmistman/watchman/protocol/query"
+)
+
+// A TriggerSpec describes a command that Watchman runs when files
+// matching an expression change. See protocol.TriggerSpec for details.
+type TriggerSpec = protocol.TriggerSpec
+
+// AddTrigger registers a trigger on the watched root, replacing any
+// trigger with the same name. If the Watch has a relative path, the
+// RelativeRoot of spec is interpreted relative to it.
+//
+// The expression of spec is checked as by Watch.Query, and AddTrigger
+// fails with ErrUnsupported if the Watchman server lacks a capability
+// needed by it.
+//
+// Triggers persist in the Watchman server after the Client is closed.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/trigger.html
+func (w *Watch) AddTrigger(ctx context.Context, spec *TriggerSpec) error {
+	trigger := *spec
+	if w.rel != "" {
+		trigger.RelativeRoot = path.Join(w.rel, spec.RelativeRoot)
+	}
+	q := &query.Query{Expression: trigger.Expression, RelativeRoot: trigger.RelativeRoot}
+	if err := w.validate(q); err != nil {
+		return err
+	}
+
+	req := &protocol.TriggerRequest{
+		Root: w.root,
+		Spec: &trigger,
+	}
+	_, err := w.client.send(ctx, req)
+	return err
+}
+
+// RemoveTrigger deletes the named trigger from the watched root.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/trigger-del.html
+func (w *Watch) RemoveTrigger(ctx context.Context, name string) error {
+	req := &protocol.TriggerDelRequest{
+		Root: w.root,
+		Name: name,
+	}
+	_, err := w.client.send(ctx, req)
+	return err
+}
+
+// ListTriggers returns the triggers registered on the watched root.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/trigger-list.html
+func (w *Watch) ListTriggers(ctx context.Context) ([]TriggerSpec, error) {
+	req := &protocol.TriggerListRequest{Root: w.root}
+	pdu, err := w.client.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return protocol.NewTriggerListResponse(pdu).Triggers(), nil
+}
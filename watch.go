@@ -1,13 +1,21 @@
 package watchman
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"path"
+	"sync"
 	"time"
 
 	"github.com/cdmistman/watchman/protocol"
 	"github.com/cdmistman/watchman/protocol/query"
 )
 
+// ErrUnsupported is returned when a request needs a capability that the
+// Watchman server does not advertise. The error names the capability.
+var ErrUnsupported = errors.New("watchman: unsupported by server")
+
 // A Watch represents a directory, or watched root, that Watchman is watching for changes.
 type Watch struct {
 	client *Client
@@ -19,12 +27,18 @@ type Watch struct {
 //
 // For details, see: https://facebook.github.io/watchman/docs/cmd/clock.html
 func (w *Watch) Clock(syncTimeout time.Duration) (string, error) {
+	return w.ClockContext(context.Background(), syncTimeout)
+}
+
+// ClockContext is like Clock, but gives up waiting for the Watchman
+// server when ctx is done.
+func (w *Watch) ClockContext(ctx context.Context, syncTimeout time.Duration) (string, error) {
 	timeout := syncTimeout.Nanoseconds() / int64(time.Millisecond)
 	req := &protocol.ClockRequest{
 		Path:        w.root,
 		SyncTimeout: int(timeout),
 	}
-	pdu, err := w.client.send(req)
+	pdu, err := w.client.send(ctx, req)
 	var clock string
 	if err == nil {
 		res := protocol.NewClockResponse(pdu)
@@ -33,27 +47,207 @@ func (w *Watch) Clock(syncTimeout time.Duration) (string, error) {
 	return clock, err
 }
 
+// A QueryResult represents the result of a query against a watched root.
+type QueryResult struct {
+	IsFreshInstance bool
+	Clock           string
+	Files           []File
+	Warning         string
+}
+
+// Query finds the files under a watched root that match a query. If the
+// Watch has a relative path, the RelativeRoot of q is interpreted
+// relative to it. The query is checked with query.Query.Validate first,
+// and Query fails with ErrUnsupported if the Watchman server lacks a
+// capability needed by the query.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/query.html
+func (w *Watch) Query(ctx context.Context, q *query.Query) (*QueryResult, error) {
+	req := &protocol.QueryRequest{Root: w.root, Query: w.scope(q)}
+	if err := w.validate(req.Query); err != nil {
+		return nil, err
+	}
+
+	pdu, err := w.client.send(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	res := protocol.NewQueryResponse(pdu)
+	files := res.Files()
+	if q != nil {
+		files = res.DecodeFiles(q.Fields)
+	}
+	return &QueryResult{
+		IsFreshInstance: res.IsFreshInstance(),
+		Clock:           res.Clock(),
+		Files:           files,
+		Warning:         res.Warning(),
+	}, nil
+}
+
 // Subscribe requests notification when changes occur under a watched root.
-func (w *Watch) Subscribe(name string, query *query.Query) (s *Subscription, err error) {
-	req := &protocol.SubscribeRequest{
-		Name:  name,
-		Root:  w.root,
-		Query: query,
+func (w *Watch) Subscribe(name string, q *query.Query) (s *Subscription, err error) {
+	return w.SubscribeContext(context.Background(), name, q)
+}
+
+// SubscribeContext is like Subscribe, but gives up waiting for the
+// Watchman server when ctx is done. In that case, if the server creates
+// the subscription regardless, it is unsubscribed.
+func (w *Watch) SubscribeContext(
+	ctx context.Context,
+	name string,
+	q *query.Query,
+) (s *Subscription, err error) {
+	return w.SubscribeWithOptions(ctx, name, q, SubscribeOptions{})
+}
+
+// SubscribeOptions configures a subscription.
+type SubscribeOptions struct {
+	// Defer lists states during which notifications are deferred.
+	// Changes are notified after the state is left.
+	Defer []string
+	// Drop lists states during which changes are not notified.
+	Drop []string
+	// DisableDeferVCS stops Watchman from deferring notifications
+	// while a version control operation is in progress.
+	DisableDeferVCS bool
+	// SettlePeriod overrides how long the root must be idle before
+	// notifications are sent, and SettleTimeout how long to wait for
+	// it to settle. Both are rounded down to milliseconds.
+	SettlePeriod  time.Duration
+	SettleTimeout time.Duration
+	// EmptyOnFreshInstance omits the files from fresh instance
+	// notifications, which is cheaper for large roots.
+	EmptyOnFreshInstance bool
+	// Since starts the subscription from a clock, so the first
+	// notification only reports changes after it.
+	Since string
+}
+
+// SubscribeWithOptions is like SubscribeContext, but configures the
+// subscription with opts. The query is checked as by Query, and
+// SubscribeWithOptions fails with ErrUnsupported if the Watchman server
+// lacks a capability needed by the query or opts. If the Watch has a
+// relative path, the RelativeRoot of q is interpreted relative to it.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/subscribe.html
+func (w *Watch) SubscribeWithOptions(
+	ctx context.Context,
+	name string,
+	q *query.Query,
+	opts SubscribeOptions,
+) (s *Subscription, err error) {
+	req := opts.request(w.root, name, w.scope(q))
+	if err := w.validate(req.Query); err != nil {
+		return nil, err
+	}
+
+	for _, capability := range req.Capabilities() {
+		if !w.client.HasCapability(capability) {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupported, capability)
+		}
 	}
 
-	if w.rel != "" {
-		req.Query.RelativeRoot = w.rel
+	// register the subscription before the event loop can dispatch
+	// its first notification
+	sub := newSubscription(w, name, req.Query, opts)
+	var mu sync.Mutex
+	var answered, abandoned bool
+	call := newCall(req)
+	call.onResponse = func(pdu protocol.ResponsePDU) {
+		sub.clock = protocol.NewSubscribeResponse(pdu).Clock()
+		w.client.addSubscription(sub)
+
+		mu.Lock()
+		answered = true
+		discard := abandoned
+		mu.Unlock()
+		if discard {
+			go sub.discard()
+		}
 	}
 
-	_, err = w.client.send(req)
+	_, err = sub.lane.do(ctx, call)
 	if err == nil {
-		s = &Subscription{
-			client: w.client,
-			name:   name,
-			root:   path.Join(w.root, w.rel),
+		return sub, nil
+	}
+
+	// ctx is done: if the subscription was created anyway, or is
+	// created later, cancel it, as the caller cannot
+	mu.Lock()
+	abandoned = true
+	discard := answered
+	mu.Unlock()
+	if discard {
+		go sub.discard()
+	}
+	return nil, err
+}
+
+// scope returns a copy of q whose RelativeRoot is interpreted relative
+// to the relative path of the Watch, or q itself if there is none.
+func (w *Watch) scope(q *query.Query) *query.Query {
+	if w.rel == "" {
+		return q
+	}
+	var spec query.Query
+	if q != nil {
+		spec = *q
+	}
+	spec.RelativeRoot = path.Join(w.rel, spec.RelativeRoot)
+	return &spec
+}
+
+// validate checks q with query.Query.Validate, failing with
+// ErrUnsupported if q needs a capability that the Watchman server does
+// not advertise.
+func (w *Watch) validate(q *query.Query) error {
+	if q == nil {
+		return nil
+	}
+	var missing string
+	err := q.Validate(func(capability string) bool {
+		if w.client.HasCapability(capability) {
+			return true
 		}
+		missing = capability
+		return false
+	})
+	if missing != "" {
+		return fmt.Errorf("%w: %s", ErrUnsupported, missing)
+	}
+	return err
+}
+
+// request returns a subscribe request configured with opts.
+func (opts *SubscribeOptions) request(root, name string, q *query.Query) *protocol.SubscribeRequest {
+	return &protocol.SubscribeRequest{
+		Root:                 root,
+		Name:                 name,
+		Query:                q,
+		Defer:                opts.Defer,
+		Drop:                 opts.Drop,
+		DisableDeferVCS:      opts.DisableDeferVCS,
+		SettlePeriod:         int(opts.SettlePeriod / time.Millisecond),
+		SettleTimeout:        int(opts.SettleTimeout / time.Millisecond),
+		EmptyOnFreshInstance: opts.EmptyOnFreshInstance,
+		Since:                opts.Since,
+	}
+}
+
+// Remove stops watching the root, including for other clients of the
+// Watchman server, which also deletes its triggers. Subscriptions to
+// the root end with ErrSubscriptionCanceled.
+//
+// For details, see: https://facebook.github.io/watchman/docs/cmd/watch-del.html
+func (w *Watch) Remove(ctx context.Context) error {
+	req := &protocol.WatchDelRequest{Root: w.root}
+	if _, err := w.client.send(ctx, req); err != nil {
+		return err
 	}
-	return
+	w.client.removeWatch(w.root)
+	return nil
 }
 
 func (w *Watch) Root() string {
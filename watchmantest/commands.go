@@ -0,0 +1,654 @@
+package watchmantest
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/cdmistman/watchman/protocol"
+)
+
+// command is either a built-in command, which runs with Server.mu held,
+// or a custom command registered with Server.Handle, which does not.
+type command struct {
+	builtin func(c *conn, args []interface{}) (pdu, error)
+	custom  HandlerFunc
+}
+
+// staticCapabilities lists the capabilities of the fake server that are
+// not derived from its commands.
+var staticCapabilities = []string{
+	"bser-v2",
+	"clock-sync-timeout",
+	"field-cclock",
+	"field-content.sha1hex",
+	"field-ctime",
+	"field-exists",
+	"field-mode",
+	"field-mtime",
+	"field-name",
+	"field-new",
+	"field-oclock",
+	"field-size",
+	"field-symlink_target",
+	"field-type",
+	"glob_generator",
+	"relative_root",
+	"suffix-set",
+	"term-allof",
+	"term-anyof",
+	"term-dirname",
+	"term-empty",
+	"term-exists",
+	"term-false",
+	"term-idirname",
+	"term-imatch",
+	"term-iname",
+	"term-match",
+	"term-name",
+	"term-not",
+	"term-since",
+	"term-size",
+	"term-suffix",
+	"term-true",
+	"term-type",
+	"wildmatch",
+	"wildmatch-multislash",
+}
+
+func (s *Server) builtinCommands() map[string]command {
+	return map[string]command{
+		"clock":               {builtin: s.cmdClock},
+		"find":                {builtin: s.cmdFind},
+		"flush-subscriptions": {builtin: s.cmdFlushSubscriptions},
+		"get-config":          {builtin: s.cmdGetConfig},
+		"get-pid":             {builtin: s.cmdGetPid},
+		"get-sockname":        {builtin: s.cmdGetSockname},
+		"list-capabilities":   {builtin: s.cmdListCapabilities},
+		"log":                 {builtin: s.cmdLog},
+		"log-level":           {builtin: s.cmdLogLevel},
+		"query":               {builtin: s.cmdQuery},
+		"shutdown-server":     {builtin: s.cmdShutdownServer},
+		"since":               {builtin: s.cmdSince},
+		"state-enter":         {builtin: s.cmdStateEnter},
+		"state-leave":         {builtin: s.cmdStateLeave},
+		"subscribe":           {builtin: s.cmdSubscribe},
+		"trigger":             {builtin: s.cmdTrigger},
+		"trigger-del":         {builtin: s.cmdTriggerDel},
+		"trigger-list":        {builtin: s.cmdTriggerList},
+		"unsubscribe":         {builtin: s.cmdUnsubscribe},
+		"version":             {builtin: s.cmdVersion},
+		"watch-del":           {builtin: s.cmdWatchDel},
+		"watch-del-all":       {builtin: s.cmdWatchDelAll},
+		"watch-list":          {builtin: s.cmdWatchList},
+		"watch-project":       {builtin: s.cmdWatchProject},
+	}
+}
+
+// lookup returns the watched root containing path, and the path
+// relative to it. It must be called with s.mu held.
+func (s *Server) lookup(path string) (*root, string, error) {
+	path = filepath.Clean(path)
+	for dir := path; ; dir = filepath.Dir(dir) {
+		if r, ok := s.roots[dir]; ok {
+			rel, _ := filepath.Rel(dir, path)
+			if rel == "." {
+				rel = ""
+			}
+			return r, filepath.ToSlash(rel), nil
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			break
+		}
+	}
+	return nil, "", fmt.Errorf("unable to resolve root %s: directory %s is not watched", path, path)
+}
+
+func stringArg(args []interface{}, i int, what string) (string, error) {
+	if i >= len(args) {
+		return "", fmt.Errorf("wrong number of arguments: missing %s", what)
+	}
+	x, ok := args[i].(string)
+	if !ok {
+		return "", fmt.Errorf("expected %s to be a string", what)
+	}
+	return x, nil
+}
+
+// patternsArg adds an expression matching the patterns of the find and
+// since commands, from args[i:], to a query specification. The patterns
+// match the path of a file relative to the root.
+func patternsArg(spec map[string]interface{}, args []interface{}, i int) error {
+	if len(args) <= i {
+		return nil
+	}
+	terms := []interface{}{"anyof"}
+	for ; i < len(args); i++ {
+		pattern, err := stringArg(args, i, "pattern")
+		if err != nil {
+			return err
+		}
+		terms = append(terms, []interface{}{"match", pattern, "wholename"})
+	}
+	spec["expression"] = terms
+	return nil
+}
+
+func objectArg(args []interface{}, i int, what string) (map[string]interface{}, error) {
+	if i >= len(args) {
+		return map[string]interface{}{}, nil
+	}
+	x, ok := args[i].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected %s to be an object", what)
+	}
+	return x, nil
+}
+
+func (s *Server) cmdListCapabilities(c *conn, args []interface{}) (pdu, error) {
+	return pdu{"capabilities": s.capabilities()}, nil
+}
+
+func (s *Server) cmdVersion(c *conn, args []interface{}) (pdu, error) {
+	res := pdu{}
+	opts, err := objectArg(args, 0, "version arguments")
+	if err != nil {
+		return nil, err
+	}
+
+	caps := map[string]struct{}{}
+	for _, cap := range s.capabilities() {
+		caps[cap] = struct{}{}
+	}
+
+	found := map[string]interface{}{}
+	for _, key := range []string{"optional", "required"} {
+		names, _ := opts[key].([]interface{})
+		for _, x := range names {
+			name, _ := x.(string)
+			_, ok := caps[name]
+			if !ok && key == "required" {
+				return nil, fmt.Errorf("client required capability `%s` is not supported by this server", name)
+			}
+			found[name] = ok
+		}
+	}
+	if len(found) > 0 {
+		res["capabilities"] = found
+	}
+	return res, nil
+}
+
+func (s *Server) cmdFlushSubscriptions(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := objectArg(args, 1, "options")
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := opts["sync_timeout"]; !ok {
+		return nil, errors.New("key 'sync_timeout' is not present in this json object")
+	}
+
+	var subs []*subscription
+	if x, ok := opts["subscriptions"]; ok {
+		names, ok := x.([]interface{})
+		if !ok {
+			return nil, errors.New("expected 'subscriptions' to be an array of subscription names")
+		}
+		for _, name := range names {
+			name, ok := name.(string)
+			if !ok {
+				return nil, errors.New("expected 'subscriptions' to be an array of subscription names")
+			}
+			sub, ok := r.subs[subscriptionKey{conn: c, name: name}]
+			if !ok {
+				return nil, fmt.Errorf("this client does not have a subscription named '%s'", name)
+			}
+			subs = append(subs, sub)
+		}
+	} else {
+		for key, sub := range r.subs {
+			if key.conn == c {
+				subs = append(subs, sub)
+			}
+		}
+		sort.Slice(subs, func(i, j int) bool {
+			return subs[i].name < subs[j].name
+		})
+	}
+
+	res := pdu{
+		"synced":         []interface{}{},
+		"no_sync_needed": []interface{}{},
+		"dropped":        []interface{}{},
+	}
+	for _, sub := range subs {
+		k := r.flush(sub)
+		res[k] = append(res[k].([]interface{}), sub.name)
+	}
+	return res, nil
+}
+
+func (s *Server) cmdGetConfig(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	r, ok := s.roots[filepath.Clean(path)]
+	if !ok {
+		return nil, fmt.Errorf("unable to resolve root %s: directory %s is not watched", path, path)
+	}
+	return pdu{"config": r.config}, nil
+}
+
+func (s *Server) cmdGetPid(c *conn, args []interface{}) (pdu, error) {
+	return pdu{"pid": os.Getpid()}, nil
+}
+
+func (s *Server) cmdLog(c *conn, args []interface{}) (pdu, error) {
+	level, err := stringArg(args, 0, "log level")
+	if err != nil {
+		return nil, err
+	}
+	message, err := stringArg(args, 1, "message")
+	if err != nil {
+		return nil, err
+	}
+	switch protocol.LogLevel(level) {
+	case protocol.LogDebug, protocol.LogError:
+	default:
+		return nil, fmt.Errorf("invalid log level '%s'", level)
+	}
+
+	s.log(protocol.LogLevel(level), message)
+	return pdu{"logged": true}, nil
+}
+
+func (s *Server) cmdLogLevel(c *conn, args []interface{}) (pdu, error) {
+	level, err := stringArg(args, 0, "log level")
+	if err != nil {
+		return nil, err
+	}
+	switch protocol.LogLevel(level) {
+	case protocol.LogDebug, protocol.LogError, protocol.LogOff:
+	default:
+		return nil, fmt.Errorf("invalid log level '%s'", level)
+	}
+
+	c.logLevel = protocol.LogLevel(level)
+	return pdu{"log_level": level}, nil
+}
+
+func (s *Server) cmdShutdownServer(c *conn, args []interface{}) (pdu, error) {
+	c.afterResponse(s.shutdown)
+	return pdu{"shutdown-server": true}, nil
+}
+
+func (s *Server) cmdFind(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	r, rel, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := map[string]interface{}{}
+	if err = patternsArg(spec, args, 1); err != nil {
+		return nil, err
+	}
+	q, err := parseQuery(spec, rel)
+	if err != nil {
+		return nil, err
+	}
+	res := r.query(q)
+	delete(res, "is_fresh_instance")
+	return res, nil
+}
+
+func (s *Server) cmdGetSockname(c *conn, args []interface{}) (pdu, error) {
+	return pdu{"sockname": s.SockName()}, nil
+}
+
+func (s *Server) cmdWatchList(c *conn, args []interface{}) (pdu, error) {
+	roots := make([]interface{}, 0, len(s.roots))
+	for dir := range s.roots {
+		roots = append(roots, dir)
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].(string) < roots[j].(string)
+	})
+	return pdu{"roots": roots}, nil
+}
+
+func (s *Server) cmdWatchDel(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	r, ok := s.roots[filepath.Clean(path)]
+	if !ok {
+		return nil, fmt.Errorf("unable to resolve root %s: directory %s is not watched", path, path)
+	}
+
+	r.cancel()
+	delete(s.roots, r.dir)
+	return pdu{"watch-del": true, "root": r.dir}, nil
+}
+
+func (s *Server) cmdWatchDelAll(c *conn, args []interface{}) (pdu, error) {
+	roots := make([]interface{}, 0, len(s.roots))
+	for dir, r := range s.roots {
+		r.cancel()
+		roots = append(roots, dir)
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].(string) < roots[j].(string)
+	})
+	s.roots = map[string]*root{}
+	return pdu{"roots": roots}, nil
+}
+
+func (s *Server) cmdWatchProject(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "path")
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("unable to resolve root %s: path must be absolute", path)
+	}
+
+	r, rel, err := s.lookup(path)
+	if err != nil {
+		r, rel = s.root(path), ""
+	}
+
+	res := pdu{"watch": r.dir, "watcher": "fake"}
+	if rel != "" {
+		res["relative_path"] = rel
+	}
+	return res, nil
+}
+
+func (s *Server) cmdClock(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+	return pdu{"clock": r.clock()}, nil
+}
+
+func (s *Server) cmdQuery(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	spec, err := objectArg(args, 1, "query")
+	if err != nil {
+		return nil, err
+	}
+	r, rel, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := parseQuery(spec, rel)
+	if err != nil {
+		return nil, err
+	}
+	return r.query(q), nil
+}
+
+func (s *Server) cmdSince(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	clock, err := stringArg(args, 1, "clock")
+	if err != nil {
+		return nil, err
+	}
+	r, rel, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := map[string]interface{}{"since": clock}
+	if err = patternsArg(spec, args, 2); err != nil {
+		return nil, err
+	}
+	q, err := parseQuery(spec, rel)
+	if err != nil {
+		return nil, err
+	}
+	return r.query(q), nil
+}
+
+func (s *Server) cmdSubscribe(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	name, err := stringArg(args, 1, "subscription name")
+	if err != nil {
+		return nil, err
+	}
+	spec, err := objectArg(args, 2, "query")
+	if err != nil {
+		return nil, err
+	}
+	r, rel, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := parseQuery(spec, rel)
+	if err != nil {
+		return nil, err
+	}
+
+	sub := r.subscribe(c, name, q)
+	for key, dst := range map[string]*[]string{"defer": &sub.deferStates, "drop": &sub.dropStates} {
+		if x, ok := spec[key]; ok {
+			if *dst, err = stringList(x, key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	// defer_vcs and the settle options only affect timing, which the
+	// fake server does not model
+	if x, ok := spec["empty_on_fresh_instance"]; ok {
+		sub.emptyOnFresh, _ = x.(bool)
+	}
+	c.afterResponse(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		r.start(sub)
+	})
+	return pdu{"clock": r.clock(), "subscribe": name}, nil
+}
+
+func (s *Server) cmdUnsubscribe(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	name, err := stringArg(args, 1, "subscription name")
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	res := pdu{"unsubscribe": name, "deleted": r.unsubscribe(c, name)}
+	return res, nil
+}
+
+func (s *Server) cmdStateEnter(c *conn, args []interface{}) (pdu, error) {
+	r, name, metadata, err := s.stateArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.enterState(c, name, metadata); err != nil {
+		return nil, err
+	}
+	return pdu{"root": r.dir, "state-enter": name, "clock": r.clock()}, nil
+}
+
+func (s *Server) cmdStateLeave(c *conn, args []interface{}) (pdu, error) {
+	r, name, metadata, err := s.stateArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	if err = r.leaveState(c, name, metadata, false); err != nil {
+		return nil, err
+	}
+	return pdu{"root": r.dir, "state-leave": name, "clock": r.clock()}, nil
+}
+
+// stateArgs parses the arguments of state-enter and state-leave: a
+// root, and either a state name or an object with a name and metadata.
+func (s *Server) stateArgs(args []interface{}) (*root, string, interface{}, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, "", nil, err
+	}
+	r, _, err := s.lookup(path)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if len(args) < 2 {
+		return nil, "", nil, errors.New("wrong number of arguments: missing state name")
+	}
+	switch x := args[1].(type) {
+	case string:
+		return r, x, nil, nil
+	case map[string]interface{}:
+		name, ok := x["name"].(string)
+		if !ok {
+			return nil, "", nil, errors.New("state name must be a string")
+		}
+		return r, name, x["metadata"], nil
+	}
+	return nil, "", nil, errors.New("expected state name to be a string or object")
+}
+
+// cmdTrigger records a trigger. Triggers are listed, but never run.
+func (s *Server) cmdTrigger(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	spec, err := objectArg(args, 1, "trigger")
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	name, ok := spec["name"].(string)
+	if !ok || name == "" {
+		return nil, errors.New("invalid or missing name")
+	}
+	if command, ok := spec["command"].([]interface{}); !ok || len(command) == 0 {
+		return nil, errors.New("invalid command array")
+	}
+	if x, ok := spec["expression"]; ok {
+		if _, err := compileTerm(x, true); err != nil {
+			return nil, err
+		}
+	}
+
+	disposition := "created"
+	if old, ok := r.triggers[name]; ok {
+		disposition = "replaced"
+		if reflect.DeepEqual(old, spec) {
+			disposition = "already_defined"
+		}
+	}
+	r.triggers[name] = spec
+	return pdu{"triggerid": name, "disposition": disposition}, nil
+}
+
+func (s *Server) cmdTriggerDel(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	name, err := stringArg(args, 1, "trigger name")
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := r.triggers[name]; !ok {
+		return nil, fmt.Errorf("no such trigger \"%s\"", name)
+	}
+	delete(r.triggers, name)
+	return pdu{"trigger": name, "deleted": true}, nil
+}
+
+func (s *Server) cmdTriggerList(c *conn, args []interface{}) (pdu, error) {
+	path, err := stringArg(args, 0, "root")
+	if err != nil {
+		return nil, err
+	}
+	r, _, err := s.lookup(path)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(r.triggers))
+	for name := range r.triggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	triggers := make([]interface{}, 0, len(names))
+	for _, name := range names {
+		triggers = append(triggers, r.triggers[name])
+	}
+	return pdu{"triggers": triggers}, nil
+}
+
+// errBadExpression is wrapped by errors about invalid query expressions.
+var errBadExpression = errors.New("failed to parse query")
+
+func badExpression(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errBadExpression, fmt.Sprintf(format, args...))
+}
+
+// trimRel returns name relative to rel, or false if it is outside rel.
+func trimRel(rel, name string) (string, bool) {
+	if rel == "" {
+		return name, true
+	}
+	if !strings.HasPrefix(name, rel+"/") {
+		return "", false
+	}
+	return name[len(rel)+1:], true
+}
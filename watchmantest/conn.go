@@ -0,0 +1,209 @@
+package watchmantest
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"sync"
+
+	"github.com/cdmistman/watchman/protocol"
+	"github.com/cdmistman/watchman/protocol/bser"
+)
+
+type pdu = map[string]interface{}
+
+// conn serves a single client connection.
+//
+// PDUs are written by a separate goroutine from an unbounded queue, so
+// that the Server never waits for a client to read, even over an
+// unbuffered net.Pipe.
+type conn struct {
+	server *Server
+	socket net.Conn
+	reader *bufio.Reader
+
+	// after holds actions to run once the response to the current
+	// request has been queued; it is only used by the goroutine
+	// running serve
+	after []func()
+
+	// logLevel is the level set by the log-level command, and is
+	// guarded by server.mu
+	logLevel protocol.LogLevel
+
+	mu       sync.Mutex
+	encoding bser.Version // zero for JSON
+	queue    []message
+	closed   bool
+	// closing is set to close the connection once the queue is empty
+	closing bool
+	signal  chan struct{}
+}
+
+type message struct {
+	pdu      pdu
+	encoding bser.Version
+}
+
+func newConn(s *Server, socket net.Conn) *conn {
+	return &conn{
+		server: s,
+		socket: socket,
+		reader: bufio.NewReader(socket),
+		signal: make(chan struct{}, 1),
+	}
+}
+
+func (c *conn) close() {
+	c.mu.Lock()
+	c.closed = true
+	c.mu.Unlock()
+	c.notify()
+	c.socket.Close()
+}
+
+// closeWhenFlushed closes the connection after the queued PDUs are
+// written.
+func (c *conn) closeWhenFlushed() {
+	c.mu.Lock()
+	c.closing = true
+	c.mu.Unlock()
+	c.notify()
+}
+
+func (c *conn) notify() {
+	select {
+	case c.signal <- struct{}{}:
+	default:
+	}
+}
+
+// serve reads and answers requests until the connection is closed.
+func (c *conn) serve() {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.writeLoop()
+	}()
+	defer func() {
+		c.close()
+		<-done
+	}()
+
+	for {
+		args, encoding, err := c.read()
+		if err != nil {
+			return
+		}
+
+		c.mu.Lock()
+		c.encoding = encoding
+		c.mu.Unlock()
+
+		c.send(c.server.handle(c, args))
+
+		after := c.after
+		c.after = nil
+		for _, fn := range after {
+			fn()
+		}
+	}
+}
+
+// afterResponse schedules fn to run after the response to the current
+// request has been queued.
+func (c *conn) afterResponse(fn func()) {
+	c.after = append(c.after, fn)
+}
+
+func (c *conn) read() ([]interface{}, bser.Version, error) {
+	magic, err := c.reader.Peek(2)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var req interface{}
+	var encoding bser.Version
+	if bser.IsPDU(magic) {
+		dec := bser.NewDecoder(c.reader)
+		if err = dec.Decode(&req); err != nil {
+			return nil, 0, err
+		}
+		encoding, _ = dec.Version()
+	} else {
+		line, err := c.reader.ReadBytes('\n')
+		if err != nil {
+			return nil, 0, err
+		}
+		if err = json.Unmarshal(line, &req); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	args, _ := req.([]interface{})
+	return args, encoding, nil
+}
+
+// send queues a PDU, to be written in the encoding of the most recent
+// request.
+func (c *conn) send(x pdu) {
+	c.mu.Lock()
+	if !c.closed {
+		c.queue = append(c.queue, message{pdu: x, encoding: c.encoding})
+	}
+	c.mu.Unlock()
+	c.notify()
+}
+
+// push queues a unilateral PDU.
+func (c *conn) push(x pdu) {
+	res := pdu{}
+	for k, v := range x {
+		res[k] = v
+	}
+	res["unilateral"] = true
+	res["version"] = Version
+	c.send(res)
+}
+
+func (c *conn) writeLoop() {
+	for {
+		c.mu.Lock()
+		for len(c.queue) == 0 && !c.closed && !c.closing {
+			c.mu.Unlock()
+			<-c.signal
+			c.mu.Lock()
+		}
+		if c.closed || len(c.queue) == 0 {
+			c.mu.Unlock()
+			// closing the socket ends serve
+			c.socket.Close()
+			return
+		}
+		m := c.queue[0]
+		c.queue[0] = message{}
+		c.queue = c.queue[1:]
+		c.mu.Unlock()
+
+		if err := c.write(m); err != nil {
+			// closing the socket ends serve
+			c.socket.Close()
+			return
+		}
+	}
+}
+
+func (c *conn) write(m message) error {
+	if m.encoding == 0 {
+		b, err := json.Marshal(m.pdu)
+		if err != nil {
+			return err
+		}
+		_, err = c.socket.Write(append(b, '\n'))
+		return err
+	}
+
+	enc := bser.NewEncoder(c.socket)
+	enc.SetVersion(m.encoding, 0)
+	return enc.Encode(m.pdu)
+}
@@ -0,0 +1,94 @@
+package watchmantest
+
+import (
+	"regexp"
+
+	pq "github.com/cdmistman/watchman/protocol/query"
+)
+
+// compileTerm decodes an expression term into a matcher, which evaluates
+// it with query.Evaluate. It supports the terms advertised in
+// staticCapabilities, plus pcre and ipcre using Go regular expression
+// syntax. Unless caseSensitive is set, names match case insensitively.
+func compileTerm(x interface{}, caseSensitive bool) (matcher, error) {
+	term, err := pq.DecodeTerm(x)
+	if err != nil {
+		return nil, badExpression("%v", err)
+	}
+	if err := (&pq.Query{Expression: term}).Validate(nil); err != nil {
+		return nil, badExpression("%v", err)
+	}
+	if err := checkRegexps(term); err != nil {
+		return nil, err
+	}
+	if !caseSensitive {
+		term = foldCase(term)
+	}
+	return func(r *root, e *entry, name string) bool {
+		return pq.Evaluate(term, r.file(e, name))
+	}, nil
+}
+
+// checkRegexps fails if a pcre or ipcre term of t is not a valid regular
+// expression, which query.Evaluate would silently never match.
+func checkRegexps(t pq.Term) error {
+	var expr string
+	switch t := t.(type) {
+	case pq.TAllof:
+		for _, t := range t {
+			if err := checkRegexps(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	case pq.TAnyof:
+		for _, t := range t {
+			if err := checkRegexps(t); err != nil {
+				return err
+			}
+		}
+		return nil
+	case pq.TNot:
+		return checkRegexps(t.Not)
+	case pq.TPCRE:
+		expr = t.Regexp
+	case pq.TIPCRE:
+		expr = t.Regexp
+	default:
+		return nil
+	}
+	if _, err := regexp.Compile(expr); err != nil {
+		return badExpression("invalid pcre: %v", err)
+	}
+	return nil
+}
+
+// foldCase returns t with the terms matching names replaced by their
+// case insensitive forms, as for a query with case_sensitive false.
+func foldCase(t pq.Term) pq.Term {
+	switch t := t.(type) {
+	case pq.TAllof:
+		res := make(pq.TAllof, len(t))
+		for i, t := range t {
+			res[i] = foldCase(t)
+		}
+		return res
+	case pq.TAnyof:
+		res := make(pq.TAnyof, len(t))
+		for i, t := range t {
+			res[i] = foldCase(t)
+		}
+		return res
+	case pq.TNot:
+		return pq.TNot{Not: foldCase(t.Not)}
+	case pq.TName:
+		return pq.TIName(t)
+	case pq.TMatch:
+		return pq.TIMatch(t)
+	case pq.TPCRE:
+		return pq.TIPCRE(t)
+	case pq.TDirname:
+		return pq.TIDirname(t)
+	}
+	return t
+}
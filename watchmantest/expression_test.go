@@ -0,0 +1,115 @@
+package watchmantest
+
+import (
+	"encoding/json"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cdmistman/watchman/protocol"
+)
+
+// TestConformance runs the query.Evaluate conformance table against
+// the queries of the fake server.
+func TestConformance(t *testing.T) {
+	require := require.New(t)
+
+	b, err := os.ReadFile("../protocol/query/testdata/conformance.json")
+	require.NoError(err)
+	var table struct {
+		Files []protocol.File
+		Tests []struct {
+			Term  json.RawMessage
+			Match []string
+		}
+	}
+	require.NoError(json.Unmarshal(b, &table))
+
+	s := newServer()
+	r := s.root("/src")
+	for _, f := range table.Files {
+		r.write(f)
+	}
+
+	for _, tc := range table.Tests {
+		var term interface{}
+		require.NoError(json.Unmarshal(tc.Term, &term))
+		q, err := parseQuery(map[string]interface{}{
+			"expression": term,
+			"fields":     []interface{}{"name"},
+		}, "")
+		require.NoError(err, string(tc.Term))
+
+		match := []string{}
+		for _, name := range r.query(q)["files"].([]interface{}) {
+			match = append(match, name.(string))
+		}
+		require.ElementsMatch(tc.Match, match, string(tc.Term))
+	}
+}
+
+func TestTerms(t *testing.T) {
+	require := require.New(t)
+
+	s := newServer()
+	r := s.root("/src")
+	r.write(protocol.File{Name: "main.go", Size: 10})
+	clock := r.clock()
+	r.write(protocol.File{Name: "lib/lib.GO"})
+	r.write(protocol.File{Name: "lib", Type: "d"})
+
+	tests := []struct {
+		term  interface{}
+		name  string
+		match bool
+	}{
+		{"true", "main.go", true},
+		{[]interface{}{"false"}, "main.go", false},
+		{"exists", "main.go", true},
+		{"empty", "main.go", false},
+		{"empty", "lib/lib.GO", true},
+		{[]interface{}{"not", "empty"}, "main.go", true},
+		{[]interface{}{"allof", "exists", "empty"}, "main.go", false},
+		{[]interface{}{"anyof", "false", "exists"}, "main.go", true},
+		{[]interface{}{"type", "d"}, "lib", true},
+		{[]interface{}{"suffix", "go"}, "lib/lib.GO", true},
+		{[]interface{}{"suffix", []interface{}{"c", "h"}}, "main.go", false},
+		{[]interface{}{"name", "main.go"}, "main.go", true},
+		{[]interface{}{"name", "lib.go"}, "lib/lib.GO", false},
+		{[]interface{}{"iname", "lib.go"}, "lib/lib.GO", true},
+		{[]interface{}{"name", "lib/lib.GO", "wholename"}, "lib/lib.GO", true},
+		{[]interface{}{"match", "*.go"}, "lib/lib.GO", false},
+		{[]interface{}{"imatch", "lib/*.go", "wholename"}, "lib/lib.GO", true},
+		{[]interface{}{"pcre", "^m.*o$"}, "main.go", true},
+		{[]interface{}{"dirname", "lib"}, "lib/lib.GO", true},
+		{[]interface{}{"dirname", "lib"}, "lib", false},
+		{[]interface{}{"dirname", "", []interface{}{"depth", "eq", 0.0}}, "main.go", true},
+		{[]interface{}{"dirname", "", []interface{}{"depth", "eq", 0.0}}, "lib/lib.GO", false},
+		{[]interface{}{"size", "gt", int64(5)}, "main.go", true},
+		{[]interface{}{"size", "le", 5.0}, "main.go", false},
+		{[]interface{}{"since", clock}, "main.go", false},
+		{[]interface{}{"since", clock}, "lib/lib.GO", true},
+		{[]interface{}{"since", "c:0:0:0:0"}, "main.go", true},
+	}
+
+	for _, test := range tests {
+		m, err := compileTerm(test.term, true)
+		require.NoError(err, "%v", test.term)
+		require.Equal(test.match, m(r, r.files[test.name], test.name), "%v ~ %s", test.term, test.name)
+	}
+
+	for _, term := range []interface{}{
+		nil,
+		"nope",
+		[]interface{}{},
+		[]interface{}{"not"},
+		[]interface{}{"type", "x"},
+		[]interface{}{"size", "approx", 1.0},
+		[]interface{}{"match", "*", "somename"},
+		[]interface{}{"pcre", "("},
+	} {
+		_, err := compileTerm(term, true)
+		require.ErrorIs(err, errBadExpression, "%v", term)
+	}
+}
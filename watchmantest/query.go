@@ -0,0 +1,269 @@
+package watchmantest
+
+import (
+	"path"
+	"strings"
+
+	pq "github.com/cdmistman/watchman/protocol/query"
+)
+
+// A query is a parsed query specification.
+type query struct {
+	since        string
+	suffixes     []string
+	paths        []pathGenerator
+	globs        []pq.Term
+	relativeRoot string
+	fields       []string
+	expression   matcher
+}
+
+type pathGenerator struct {
+	path  string
+	depth int // negative for unlimited
+}
+
+// A matcher evaluates an expression term against a file, whose name is
+// relative to the relative root of the query.
+type matcher func(r *root, e *entry, name string) bool
+
+var defaultFields = []string{"name", "exists", "new", "size", "mode"}
+
+var knownFields = map[string]bool{
+	"name": true, "exists": true, "new": true, "type": true,
+	"size": true, "mode": true, "uid": true, "gid": true,
+	"ino": true, "dev": true, "nlink": true,
+	"cclock": true, "oclock": true,
+	"ctime": true, "ctime_ms": true, "ctime_us": true, "ctime_ns": true, "ctime_f": true,
+	"mtime": true, "mtime_ms": true, "mtime_us": true, "mtime_ns": true, "mtime_f": true,
+	"symlink_target": true, "content.sha1hex": true,
+}
+
+// parseQuery parses a query specification. rel is the path of the
+// requested directory relative to the watched root.
+func parseQuery(spec map[string]interface{}, rel string) (*query, error) {
+	q := &query{relativeRoot: rel}
+
+	if x, ok := spec["since"]; ok {
+		since, ok := x.(string)
+		if !ok {
+			return nil, badExpression("'since' must be a clock string")
+		}
+		q.since = since
+	}
+
+	if x, ok := spec["suffix"]; ok {
+		suffixes, err := stringList(x, "suffix")
+		if err != nil {
+			return nil, err
+		}
+		for _, suffix := range suffixes {
+			q.suffixes = append(q.suffixes, strings.ToLower(suffix))
+		}
+	}
+
+	if x, ok := spec["path"]; ok {
+		list, ok := x.([]interface{})
+		if !ok {
+			return nil, badExpression("'path' must be an array")
+		}
+		for _, x := range list {
+			switch x := x.(type) {
+			case string:
+				q.paths = append(q.paths, pathGenerator{path: x, depth: -1})
+			case map[string]interface{}:
+				p, _ := x["path"].(string)
+				depth, ok := toInt(x["depth"])
+				if !ok {
+					return nil, badExpression("'depth' must be an integer")
+				}
+				q.paths = append(q.paths, pathGenerator{path: p, depth: int(depth)})
+			default:
+				return nil, badExpression("expected path element to be a string or object")
+			}
+		}
+	}
+
+	if x, ok := spec["glob"]; ok {
+		patterns, err := stringList(x, "glob")
+		if err != nil {
+			return nil, err
+		}
+		var flags pq.TMatchFlags
+		if dotfiles, _ := spec["glob_includedotfiles"].(bool); dotfiles {
+			flags = pq.MatchIncludeDotFiles
+		}
+		for _, pattern := range patterns {
+			q.globs = append(q.globs, pq.TMatch{Glob: pattern, MatchType: pq.MatchWholeName, Flags: flags})
+		}
+	}
+
+	if x, ok := spec["relative_root"]; ok {
+		relativeRoot, ok := x.(string)
+		if !ok {
+			return nil, badExpression("'relative_root' must be a string")
+		}
+		q.relativeRoot = strings.Trim(path.Join(rel, relativeRoot), "/")
+	}
+
+	q.fields = defaultFields
+	if x, ok := spec["fields"]; ok {
+		fields, err := stringList(x, "fields")
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range fields {
+			if !knownFields[field] {
+				return nil, badExpression("unknown field name '%s'", field)
+			}
+		}
+		q.fields = fields
+	}
+
+	caseSensitive := true
+	if x, ok := spec["case_sensitive"].(bool); ok {
+		caseSensitive = x
+	}
+
+	if x, ok := spec["expression"]; ok {
+		m, err := compileTerm(x, caseSensitive)
+		if err != nil {
+			return nil, err
+		}
+		q.expression = m
+	}
+
+	return q, nil
+}
+
+// generate reports whether the generators of q produce name.
+func (q *query) generate(name string) bool {
+	if q.suffixes == nil && q.paths == nil && q.globs == nil {
+		return true
+	}
+
+	for _, suffix := range q.suffixes {
+		if strings.ToLower(path.Ext(name)) == "."+suffix {
+			return true
+		}
+	}
+	for _, p := range q.paths {
+		rel, ok := trimRel(strings.Trim(p.path, "/"), name)
+		if ok && (p.depth < 0 || strings.Count(rel, "/") <= p.depth) {
+			return true
+		}
+	}
+	for _, glob := range q.globs {
+		if pq.Evaluate(glob, pq.File{Name: name}) {
+			return true
+		}
+	}
+	return false
+}
+
+// project returns the requested fields of a file.
+func (q *query) project(r *root, e *entry, name string, isNew bool) interface{} {
+	if len(q.fields) == 1 {
+		return fieldValue(r, e, name, isNew, q.fields[0])
+	}
+	res := map[string]interface{}{}
+	for _, field := range q.fields {
+		res[field] = fieldValue(r, e, name, isNew, field)
+	}
+	return res
+}
+
+func fieldValue(r *root, e *entry, name string, isNew bool, field string) interface{} {
+	switch field {
+	case "name":
+		return name
+	case "exists":
+		return e.Exists
+	case "new":
+		return isNew
+	case "type":
+		return e.Type
+	case "size":
+		return e.Size
+	case "mode":
+		return e.Mode
+	case "uid":
+		return e.UID
+	case "gid":
+		return e.GID
+	case "ino":
+		return e.Ino
+	case "dev":
+		return e.Dev
+	case "nlink":
+		return e.Nlink
+	case "cclock":
+		return r.clockAt(e.cclock)
+	case "oclock":
+		return r.clockAt(e.oclock)
+	case "ctime":
+		return e.Ctime
+	case "ctime_ms":
+		return e.CtimeMs
+	case "ctime_us":
+		return e.CtimeUs
+	case "ctime_ns":
+		return e.CtimeNs
+	case "ctime_f":
+		return e.CtimeF
+	case "mtime":
+		return e.Mtime
+	case "mtime_ms":
+		return e.MtimeMs
+	case "mtime_us":
+		return e.MtimeUs
+	case "mtime_ns":
+		return e.MtimeNs
+	case "mtime_f":
+		return e.MtimeF
+	case "symlink_target":
+		if e.Type != "l" {
+			return nil
+		}
+		return e.SymlinkTarget
+	case "content.sha1hex":
+		if e.ContentSHA1HexError != "" {
+			return map[string]interface{}{"error": e.ContentSHA1HexError}
+		}
+		if e.Type != "f" || !e.Exists {
+			return nil
+		}
+		return e.ContentSHA1Hex
+	}
+	return nil
+}
+
+func stringList(x interface{}, what string) ([]string, error) {
+	if s, ok := x.(string); ok {
+		return []string{s}, nil
+	}
+	list, ok := x.([]interface{})
+	if !ok {
+		return nil, badExpression("'%s' must be a string or an array of strings", what)
+	}
+	res := make([]string, 0, len(list))
+	for _, x := range list {
+		s, ok := x.(string)
+		if !ok {
+			return nil, badExpression("'%s' must be a string or an array of strings", what)
+		}
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+// toInt converts a number decoded from JSON or BSER.
+func toInt(x interface{}) (int64, bool) {
+	switch x := x.(type) {
+	case int64:
+		return x, true
+	case float64:
+		return int64(x), x == float64(int64(x))
+	}
+	return 0, false
+}
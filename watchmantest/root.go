@@ -0,0 +1,379 @@
+package watchmantest
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cdmistman/watchman/protocol"
+	pq "github.com/cdmistman/watchman/protocol/query"
+)
+
+// A root is a watched directory with a virtual file tree. Every change
+// advances its tick, from which clocks are derived. All of its methods
+// must be called with Server.mu held.
+type root struct {
+	server   *Server
+	dir      string
+	num      int
+	tick     int
+	files    map[string]*entry
+	subs     map[subscriptionKey]*subscription
+	triggers map[string]map[string]interface{}
+	// states maps asserted states to the connection that entered them
+	states map[string]*conn
+	// config is the contents of the .watchmanconfig file
+	config map[string]interface{}
+}
+
+// An entry is a file known to a root. Removed files are kept, so that
+// queries since an earlier clock can report them.
+type entry struct {
+	protocol.File
+	cclock int
+	oclock int
+}
+
+type subscriptionKey struct {
+	conn *conn
+	name string
+}
+
+type subscription struct {
+	name  string
+	conn  *conn
+	query *query
+	// tick is the tick of the most recent notification
+	tick int
+	// ready is set once the subscribe response has been queued
+	ready bool
+	// deferStates and dropStates hold the defer and drop options
+	deferStates []string
+	dropStates  []string
+	// emptyOnFresh holds the empty_on_fresh_instance option
+	emptyOnFresh bool
+}
+
+// suspended reports whether notifications to sub are deferred or
+// dropped because of an asserted state.
+func (sub *subscription) suspended(r *root) (deferred, dropped bool) {
+	for _, name := range sub.deferStates {
+		if _, ok := r.states[name]; ok {
+			deferred = true
+		}
+	}
+	for _, name := range sub.dropStates {
+		if _, ok := r.states[name]; ok {
+			dropped = true
+		}
+	}
+	return
+}
+
+func newRoot(s *Server, dir string, num int) *root {
+	return &root{
+		server:   s,
+		dir:      dir,
+		num:      num,
+		tick:     1,
+		files:    map[string]*entry{},
+		subs:     map[subscriptionKey]*subscription{},
+		triggers: map[string]map[string]interface{}{},
+		states:   map[string]*conn{},
+		config:   readConfig(dir),
+	}
+}
+
+// readConfig returns the contents of the .watchmanconfig file of dir, or
+// an empty configuration if it is missing or invalid, like the Watchman
+// server.
+func readConfig(dir string) map[string]interface{} {
+	config := map[string]interface{}{}
+	if b, err := os.ReadFile(filepath.Join(dir, ".watchmanconfig")); err == nil {
+		if json.Unmarshal(b, &config) != nil {
+			config = map[string]interface{}{}
+		}
+	}
+	return config
+}
+
+func (r *root) clockPrefix() string {
+	return fmt.Sprintf("c:%d:%d:%d:", r.server.start, os.Getpid(), r.num)
+}
+
+func (r *root) clockAt(tick int) string {
+	return r.clockPrefix() + strconv.Itoa(tick)
+}
+
+func (r *root) clock() string {
+	return r.clockAt(r.tick)
+}
+
+// parseClock returns the tick of a clock issued by r, or false if the
+// clock belongs to a different root or server instance.
+func (r *root) parseClock(clock string) (int, bool) {
+	x := strings.TrimPrefix(clock, r.clockPrefix())
+	if x == clock {
+		return 0, false
+	}
+	tick, err := strconv.Atoi(x)
+	if err != nil || tick > r.tick {
+		return 0, false
+	}
+	return tick, true
+}
+
+// file returns the metadata of e that query.Evaluate matches terms
+// against, with the given name.
+func (r *root) file(e *entry, name string) pq.File {
+	return pq.File{
+		Name:   name,
+		Exists: e.Exists,
+		Type:   pq.TFileType(e.Type),
+		Size:   e.Size,
+		Mtime:  e.Mtime,
+		Ctime:  e.Ctime,
+		OClock: r.clockAt(e.oclock),
+		CClock: r.clockAt(e.cclock),
+	}
+}
+
+func (r *root) write(f protocol.File) {
+	r.tick++
+
+	e, ok := r.files[f.Name]
+	if !ok || !e.Exists {
+		e = &entry{cclock: r.tick}
+		r.files[f.Name] = e
+	}
+	e.File = f
+	e.Exists = true
+	if e.Type == "" {
+		e.Type = "f"
+	}
+	e.oclock = r.tick
+}
+
+func (r *root) remove(name string) {
+	e, ok := r.files[name]
+	if !ok || !e.Exists {
+		return
+	}
+	r.tick++
+	e.Exists = false
+	e.oclock = r.tick
+}
+
+// since returns the tick to report changes after for the since clock
+// of q, or true if a fresh instance result is required instead.
+func (r *root) since(q *query) (int, bool) {
+	if q.since == "" {
+		return 0, true
+	}
+	tick, ok := r.parseClock(q.since)
+	return tick, !ok
+}
+
+// query runs q and returns the response PDU.
+func (r *root) query(q *query) pdu {
+	since, fresh := r.since(q)
+	return r.results(q, since, fresh)
+}
+
+// results returns the files matching q that changed after the since
+// tick. A fresh instance result, as for a query without a since clock,
+// lists all existing files instead.
+func (r *root) results(q *query, since int, fresh bool) pdu {
+	names := make([]string, 0, len(r.files))
+	for name := range r.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	files := []interface{}{}
+	for _, name := range names {
+		e := r.files[name]
+		if fresh && !e.Exists || !fresh && e.oclock <= since {
+			continue
+		}
+		rel, ok := trimRel(q.relativeRoot, name)
+		if !ok || !q.generate(rel) {
+			continue
+		}
+		if q.expression != nil && !q.expression(r, e, rel) {
+			continue
+		}
+		files = append(files, q.project(r, e, rel, !fresh && e.cclock > since))
+	}
+
+	res := pdu{
+		"clock":             r.clock(),
+		"files":             files,
+		"is_fresh_instance": fresh,
+	}
+	return res
+}
+
+// subscribe registers a subscription. Notifications begin once start is
+// called.
+func (r *root) subscribe(c *conn, name string, q *query) *subscription {
+	sub := &subscription{name: name, conn: c, query: q}
+	r.subs[subscriptionKey{conn: c, name: name}] = sub
+	return sub
+}
+
+// start sends the initial notification of sub, unless it was replaced
+// or removed in the meantime.
+func (r *root) start(sub *subscription) {
+	if r.subs[subscriptionKey{conn: sub.conn, name: sub.name}] != sub {
+		return
+	}
+
+	since, fresh := r.since(sub.query)
+	sub.ready = true
+	sub.tick = r.tick
+	res := r.results(sub.query, since, fresh)
+	if fresh && sub.emptyOnFresh {
+		res["files"] = []interface{}{}
+	}
+	r.push(sub, res, since, fresh)
+}
+
+func (r *root) unsubscribe(c *conn, name string) bool {
+	key := subscriptionKey{conn: c, name: name}
+	_, ok := r.subs[key]
+	delete(r.subs, key)
+	return ok
+}
+
+// disconnect removes the subscriptions of c, and vacates the states
+// it entered.
+func (r *root) disconnect(c *conn) {
+	for key := range r.subs {
+		if key.conn == c {
+			delete(r.subs, key)
+		}
+	}
+	for name, owner := range r.states {
+		if owner == c {
+			r.leaveState(c, name, nil, true)
+		}
+	}
+}
+
+// cancel notifies every subscriber that the root is no longer watched.
+func (r *root) cancel() {
+	for key, sub := range r.subs {
+		sub.conn.push(pdu{
+			"subscription": sub.name,
+			"root":         r.dir,
+			"canceled":     true,
+		})
+		delete(r.subs, key)
+	}
+}
+
+func (r *root) enterState(c *conn, name string, metadata interface{}) error {
+	if _, ok := r.states[name]; ok {
+		return fmt.Errorf("state %s is already asserted", name)
+	}
+	r.states[name] = c
+	r.notifyState(pdu{"state-enter": name}, metadata)
+	return nil
+}
+
+func (r *root) leaveState(c *conn, name string, metadata interface{}, abandoned bool) error {
+	if owner, ok := r.states[name]; !ok || owner != c {
+		return fmt.Errorf("state %s is not asserted by this client", name)
+	}
+	delete(r.states, name)
+
+	res := pdu{"state-leave": name}
+	if abandoned {
+		res["abandoned"] = true
+	}
+	r.notifyState(res, metadata)
+
+	// deliver the changes deferred by the state
+	r.notify()
+	return nil
+}
+
+// notifyState sends a state-enter or state-leave notification to every
+// subscription.
+func (r *root) notifyState(x pdu, metadata interface{}) {
+	for _, sub := range r.subs {
+		if !sub.ready {
+			continue
+		}
+		res := pdu{
+			"subscription": sub.name,
+			"root":         r.dir,
+			"clock":        r.clock(),
+		}
+		for k, v := range x {
+			res[k] = v
+		}
+		if metadata != nil {
+			res["metadata"] = metadata
+		}
+		sub.conn.push(res)
+	}
+}
+
+// notify sends a notification to every subscription with matching
+// changes since its previous notification, unless it is suspended by
+// an asserted state.
+func (r *root) notify() {
+	for _, sub := range r.subs {
+		if !sub.ready {
+			continue
+		}
+		deferred, dropped := sub.suspended(r)
+		if dropped {
+			sub.tick = r.tick
+		}
+		if deferred || dropped {
+			continue
+		}
+		since := sub.tick
+		sub.tick = r.tick
+		res := r.results(sub.query, since, false)
+		if len(res["files"].([]interface{})) == 0 {
+			continue
+		}
+		r.push(sub, res, since, false)
+	}
+}
+
+// flush sends the changes that sub has not been notified of, even if
+// they are deferred by an asserted state, and returns the field of the
+// flush-subscriptions response that lists it. Changes are dropped by
+// notify as they happen, so none are reported as dropped.
+func (r *root) flush(sub *subscription) string {
+	if !sub.ready || sub.tick == r.tick {
+		return "no_sync_needed"
+	}
+
+	since := sub.tick
+	sub.tick = r.tick
+	res := r.results(sub.query, since, false)
+	if len(res["files"].([]interface{})) == 0 {
+		return "no_sync_needed"
+	}
+	r.push(sub, res, since, false)
+	return "synced"
+}
+
+func (r *root) push(sub *subscription, res pdu, since int, fresh bool) {
+	res["subscription"] = sub.name
+	res["root"] = r.dir
+	if !fresh {
+		res["since"] = r.clockAt(since)
+	}
+	sub.conn.push(res)
+}
@@ -0,0 +1,420 @@
+// Package watchmantest provides an in-process fake Watchman server for
+// testing code that uses package watchman or package protocol without
+// a real Watchman binary.
+//
+// The fake server speaks the Watchman wire protocol, in both JSON and
+// BSER encodings, over a UNIX domain socket or an in-memory pipe. Its
+// filesystem is virtual: files are created, changed, and removed by the
+// test through methods such as WriteFile and RemoveFile, which makes
+// notifications deterministic.
+//
+// A minimal test looks like:
+//
+//	srv := watchmantest.NewServer()
+//	defer srv.Close()
+//	t.Setenv("WATCHMAN_SOCK", srv.SockName())
+//
+//	client, err := watchman.Connect()
+//
+// Alternatively, ConnectOptions connects over in-memory pipes, without a
+// socket or environment variable:
+//
+//	srv := watchmantest.NewUnstartedServer()
+//	defer srv.Close()
+//
+//	client, err := watchman.ConnectWithOptions(ctx, watchman.Options{
+//		Connection: srv.ConnectOptions(),
+//	})
+package watchmantest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cdmistman/watchman/protocol"
+)
+
+// Version is the server version reported by the fake server.
+const Version = "4.9.0"
+
+// A HandlerFunc implements a Watchman command. It receives the
+// arguments of the request, not including the command name, and returns
+// the response PDU. If it returns an error, an error PDU is sent.
+type HandlerFunc func(args []interface{}) (map[string]interface{}, error)
+
+// A Server is a fake Watchman server.
+type Server struct {
+	listener net.Listener
+	dir      string
+	start    int64
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	conns    map[*conn]struct{}
+	roots    map[string]*root
+	numRoots int
+	commands map[string]command
+	requests []Request
+	closed   bool
+}
+
+// A Request records a request received by the Server.
+type Request struct {
+	Command string
+	Args    []interface{}
+}
+
+// NewServer starts a Server listening on a UNIX domain socket in a new
+// temporary directory. The caller should call Close when finished.
+func NewServer() *Server {
+	dir, err := os.MkdirTemp("", "watchmantest")
+	if err != nil {
+		panic(fmt.Sprintf("watchmantest: %v", err))
+	}
+
+	sockname := filepath.Join(dir, "sock")
+	listener, err := net.Listen("unix", sockname)
+	if err != nil {
+		os.RemoveAll(dir)
+		panic(fmt.Sprintf("watchmantest: failed to listen on %s: %v", sockname, err))
+	}
+
+	s := newServer()
+	s.listener = listener
+	s.dir = dir
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			socket, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(socket)
+		}
+	}()
+	return s
+}
+
+// NewUnstartedServer returns a Server that does not listen on a socket.
+// Connections can only be made with Dial.
+func NewUnstartedServer() *Server {
+	return newServer()
+}
+
+func newServer() *Server {
+	s := &Server{
+		start: time.Now().Unix(),
+		conns: map[*conn]struct{}{},
+		roots: map[string]*root{},
+	}
+	s.commands = s.builtinCommands()
+	return s
+}
+
+// SockName returns the path of the UNIX domain socket the Server listens
+// on, suitable for the WATCHMAN_SOCK environment variable.
+func (s *Server) SockName() string {
+	if s.listener == nil {
+		return ""
+	}
+	return s.listener.Addr().String()
+}
+
+// Dial returns the client end of an in-memory connection to the Server.
+func (s *Server) Dial(ctx context.Context) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if closed {
+		return nil, errors.New("watchmantest: server closed")
+	}
+
+	client, server := net.Pipe()
+	s.serve(server)
+	return client, nil
+}
+
+// ConnectOptions returns options for protocol.ConnectWithOptions, and
+// watchman.Options.Connection, that connect to the Server with Dial,
+// without a socket or a watchman executable.
+func (s *Server) ConnectOptions() protocol.ConnectOptions {
+	sockname := s.SockName()
+	if sockname == "" {
+		sockname = "watchmantest"
+	}
+	return protocol.ConnectOptions{
+		SockName: sockname,
+		Dialer: func(ctx context.Context, sockname string) (net.Conn, error) {
+			return s.Dial(ctx)
+		},
+	}
+}
+
+// Close stops the Server and closes every connection.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.CloseConnections()
+	s.wg.Wait()
+
+	if s.dir != "" {
+		return os.RemoveAll(s.dir)
+	}
+	return nil
+}
+
+// CloseConnections closes every open connection, as if the Watchman
+// server had restarted, but keeps accepting new connections. Watches
+// and files are preserved, subscriptions are not.
+func (s *Server) CloseConnections() {
+	s.mu.Lock()
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.close()
+	}
+}
+
+// shutdown implements the shutdown-server command: the Server forgets
+// its watches and starts a new instance, as if the Watchman server had
+// restarted, and closes every connection once its responses are
+// written.
+func (s *Server) shutdown() {
+	s.mu.Lock()
+	s.roots = map[string]*root{}
+	// clocks of the previous instance are not recognized
+	s.start++
+	conns := make([]*conn, 0, len(s.conns))
+	for c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+
+	for _, c := range conns {
+		c.closeWhenFlushed()
+	}
+}
+
+// Handle registers a handler for a command, replacing the built-in
+// implementation if there is one. The command is also advertised as a
+// "cmd-" capability.
+func (s *Server) Handle(name string, handler HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.commands[name] = command{custom: handler}
+}
+
+// Requests returns the requests received by the Server, in order.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Push sends a unilateral PDU to every connection. The "unilateral" and
+// "version" fields are added automatically.
+func (s *Server) Push(pdu map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for c := range s.conns {
+		c.push(pdu)
+	}
+}
+
+// Watch makes dir a watched root, as if by the watch-project command.
+func (s *Server) Watch(dir string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.root(dir)
+}
+
+// SetConfig replaces the configuration of a watched root, as returned
+// by the get-config command. By default, the configuration is read from
+// the .watchmanconfig file of dir, if any, when it starts being watched.
+func (s *Server) SetConfig(dir string, config map[string]interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.root(dir).config = config
+}
+
+// WriteFile creates or updates a file in a watched root, and notifies
+// subscribers. The name of f is relative to the root, and its metadata
+// is reported as given, except that the type defaults to a regular file
+// and the exists, new, cclock and oclock fields are maintained by the
+// Server.
+func (s *Server) WriteFile(dir string, f protocol.File) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.root(dir)
+	r.write(f)
+	r.notify()
+}
+
+// RemoveFile removes a file from a watched root, and notifies
+// subscribers.
+func (s *Server) RemoveFile(dir, name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.root(dir)
+	r.remove(name)
+	r.notify()
+}
+
+// Log writes a message to the log of the Server, which sends it to
+// every connection whose log-level selects the level. level must be
+// protocol.LogError or protocol.LogDebug.
+func (s *Server) Log(level protocol.LogLevel, message string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log(level, message)
+}
+
+// log must be called with s.mu held.
+func (s *Server) log(level protocol.LogLevel, message string) {
+	for c := range s.conns {
+		if logs(c.logLevel, level) {
+			c.push(pdu{"log": message, "level": string(level)})
+		}
+	}
+}
+
+// logs reports whether a connection with log level selects messages of
+// level.
+func logs(selected, level protocol.LogLevel) bool {
+	switch selected {
+	case protocol.LogDebug:
+		return level == protocol.LogDebug || level == protocol.LogError
+	case protocol.LogError:
+		return level == protocol.LogError
+	}
+	return false
+}
+
+// Roots returns the watched roots.
+func (s *Server) Roots() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	roots := make([]string, 0, len(s.roots))
+	for root := range s.roots {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// root returns the watched root for dir, creating it if needed. It must
+// be called with s.mu held.
+func (s *Server) root(dir string) *root {
+	dir = filepath.Clean(dir)
+	if r, ok := s.roots[dir]; ok {
+		return r
+	}
+	// root numbers are not reused, so clocks of a deleted root are not
+	// recognized if it is watched again
+	s.numRoots++
+	r := newRoot(s, dir, s.numRoots)
+	s.roots[dir] = r
+	return r
+}
+
+// capabilities returns the capabilities advertised by the Server. It
+// must be called with s.mu held.
+func (s *Server) capabilities() []string {
+	caps := append([]string(nil), staticCapabilities...)
+	for name := range s.commands {
+		caps = append(caps, "cmd-"+name)
+	}
+	sort.Strings(caps)
+	return caps
+}
+
+func (s *Server) serve(socket net.Conn) {
+	c := newConn(s, socket)
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		socket.Close()
+		return
+	}
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		c.serve()
+
+		s.mu.Lock()
+		delete(s.conns, c)
+		for _, r := range s.roots {
+			r.disconnect(c)
+		}
+		s.mu.Unlock()
+	}()
+}
+
+// handle runs a request and returns the response PDU.
+func (s *Server) handle(c *conn, args []interface{}) pdu {
+	if len(args) == 0 {
+		return errorPDU(errors.New("invalid command (expected an array with some elements!)"))
+	}
+	name, ok := args[0].(string)
+	if !ok {
+		return errorPDU(errors.New("invalid command: expected element 0 to be the command name"))
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{Command: name, Args: args[1:]})
+	cmd, ok := s.commands[name]
+	if !ok {
+		s.mu.Unlock()
+		return errorPDU(fmt.Errorf("unknown command %s", name))
+	}
+
+	var res pdu
+	var err error
+	if cmd.custom != nil {
+		// custom handlers may call methods of the Server
+		s.mu.Unlock()
+		res, err = cmd.custom(args[1:])
+	} else {
+		res, err = cmd.builtin(c, args[1:])
+		s.mu.Unlock()
+	}
+
+	if err != nil {
+		return errorPDU(err)
+	}
+	if res == nil {
+		res = pdu{}
+	}
+	res["version"] = Version
+	return res
+}
+
+func errorPDU(err error) pdu {
+	return pdu{"error": err.Error(), "version": Version}
+}
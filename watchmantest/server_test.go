@@ -0,0 +1,322 @@
+package watchmantest_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cdmistman/watchman/protocol"
+	"github.com/cdmistman/watchman/protocol/query"
+	"github.com/cdmistman/watchman/watchmantest"
+)
+
+func connect(t *testing.T, srv *watchmantest.Server) *protocol.Connection {
+	t.Setenv("WATCHMAN_SOCK", srv.SockName())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := protocol.ConnectContext(ctx)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func roundTrip(t *testing.T, conn *protocol.Connection, req protocol.Request) (protocol.ResponsePDU, error) {
+	require.NoError(t, conn.Send(req))
+	return conn.Recv()
+}
+
+func TestServer(t *testing.T) {
+	require := require.New(t)
+
+	srv := watchmantest.NewServer()
+	defer srv.Close()
+	conn := connect(t, srv)
+
+	require.Equal(protocol.EncodingBSERv2, conn.Encoding())
+	require.Equal(watchmantest.Version, conn.Version())
+	require.True(conn.HasCapability("cmd-subscribe"))
+	require.True(conn.HasCapability("relative_root"))
+
+	pdu, err := roundTrip(t, conn, &protocol.WatchProjectRequest{Path: "/src"})
+	require.NoError(err)
+	watch := protocol.NewWatchProjectResponse(pdu)
+	require.Equal("/src", watch.Watch())
+	require.Equal("", watch.RelativePath())
+
+	pdu, err = roundTrip(t, conn, &protocol.WatchProjectRequest{Path: "/src/lib"})
+	require.NoError(err)
+	watch = protocol.NewWatchProjectResponse(pdu)
+	require.Equal("/src", watch.Watch())
+	require.Equal("lib", watch.RelativePath())
+
+	pdu, err = roundTrip(t, conn, &protocol.WatchListRequest{})
+	require.NoError(err)
+	require.Equal([]string{"/src"}, protocol.NewWatchListResponse(pdu).Roots())
+	require.Equal([]string{"/src"}, srv.Roots())
+
+	srv.WriteFile("/src", protocol.File{Name: "main.go", Size: 10})
+	srv.WriteFile("/src", protocol.File{Name: "lib/lib.go"})
+	srv.WriteFile("/src", protocol.File{Name: "lib/README"})
+	srv.WriteFile("/src", protocol.File{Name: "lib", Type: "d"})
+
+	pdu, err = roundTrip(t, conn, &protocol.ClockRequest{Path: "/src"})
+	require.NoError(err)
+	clock := protocol.NewClockResponse(pdu).Clock()
+	require.NotEmpty(clock)
+
+	q := query.New().Type(query.TFileRegular).Suffix("go").Fields(query.FName, query.FSize).Query()
+	require.NoError(q.Validate(conn.HasCapability))
+	pdu, err = roundTrip(t, conn, &protocol.QueryRequest{Root: "/src", Query: q})
+	require.NoError(err)
+	res := protocol.NewQueryResponse(pdu)
+	require.Equal(clock, res.Clock())
+	require.True(res.IsFreshInstance())
+	require.Equal([]protocol.File{
+		{Name: "lib/lib.go"},
+		{Name: "main.go", Size: 10},
+	}, res.Files())
+
+	srv.RemoveFile("/src", "main.go")
+	pdu, err = roundTrip(t, conn, &protocol.QueryRequest{
+		Root: "/src/lib",
+		Query: &query.Query{
+			Generators: query.Generators{query.GSince: clock},
+			Fields:     query.Fields{query.FName, query.FExists},
+		},
+	})
+	require.NoError(err)
+	res = protocol.NewQueryResponse(pdu)
+	require.False(res.IsFreshInstance())
+	require.Empty(res.Files())
+
+	pdu, err = roundTrip(t, conn, &protocol.QueryRequest{
+		Root: "/src",
+		Query: &query.Query{
+			Generators: query.Generators{query.GSince: clock},
+			Fields:     query.Fields{query.FName, query.FExists},
+		},
+	})
+	require.NoError(err)
+	require.Equal([]protocol.File{{Name: "main.go"}}, protocol.NewQueryResponse(pdu).Files())
+
+	pdu, err = roundTrip(t, conn, &protocol.SinceRequest{
+		Root:     "/src",
+		Clock:    "c:0:0:0:0",
+		Patterns: []string{"lib/*.go", "*.md"},
+	})
+	require.NoError(err)
+	since := protocol.NewSinceResponse(pdu)
+	require.True(since.IsFreshInstance())
+	require.Equal([]protocol.File{{Name: "lib/lib.go", Exists: true}}, since.Files())
+
+	_, err = roundTrip(t, conn, &protocol.ClockRequest{Path: "/elsewhere"})
+	require.Error(err)
+	require.IsType(&protocol.WatchmanError{}, err)
+	require.ErrorIs(err, protocol.ErrRootNotWatched)
+	require.Equal("clock", err.(*protocol.WatchmanError).Command())
+
+	requests := srv.Requests()
+	require.Equal("list-capabilities", requests[0].Command)
+	require.Equal("clock", requests[len(requests)-1].Command)
+	require.Equal([]interface{}{"/elsewhere"}, requests[len(requests)-1].Args)
+}
+
+func TestSubscribe(t *testing.T) {
+	require := require.New(t)
+
+	srv := watchmantest.NewServer()
+	defer srv.Close()
+	srv.Watch("/src")
+	srv.WriteFile("/src", protocol.File{Name: "a.go"})
+	srv.WriteFile("/src", protocol.File{Name: "b.txt"})
+	conn := connect(t, srv)
+
+	pdu, err := roundTrip(t, conn, &protocol.SubscribeRequest{
+		Root: "/src",
+		Name: "sub1",
+		Query: &query.Query{
+			Expression: query.TSuffix{"go"},
+			Fields:     query.Fields{query.FName},
+		},
+	})
+	require.NoError(err)
+	require.Equal("sub1", protocol.NewSubscribeResponse(pdu).Subscription())
+
+	pdu, err = conn.Recv()
+	require.NoError(err)
+	require.True(pdu.IsUnilateral())
+	sub := protocol.NewSubscription(pdu)
+	require.Equal("sub1", sub.Subscription())
+	require.Equal("/src", sub.Root())
+	require.True(sub.IsFreshInstance())
+	require.Equal([]protocol.File{{Name: "a.go"}}, sub.Files())
+
+	// changes that do not match are not notified
+	srv.WriteFile("/src", protocol.File{Name: "c.txt"})
+	srv.WriteFile("/src", protocol.File{Name: "d.go"})
+	pdu, err = conn.Recv()
+	require.NoError(err)
+	sub = protocol.NewSubscription(pdu)
+	require.False(sub.IsFreshInstance())
+	require.Equal([]protocol.File{{Name: "d.go"}}, sub.Files())
+
+	srv.Push(map[string]interface{}{"log": "hello"})
+	pdu, err = conn.Recv()
+	require.NoError(err)
+	require.True(pdu.IsUnilateral())
+	require.Equal("hello", pdu["log"])
+
+	pdu, err = roundTrip(t, conn, &protocol.UnsubscribeRequest{Root: "/src", Name: "sub1"})
+	require.NoError(err)
+	require.True(protocol.NewUnsubscribeResponse(pdu).Deleted())
+
+	srv.WriteFile("/src", protocol.File{Name: "e.go"})
+	pdu, err = roundTrip(t, conn, &protocol.WatchListRequest{})
+	require.NoError(err)
+	require.False(pdu.IsUnilateral())
+}
+
+func TestHandle(t *testing.T) {
+	require := require.New(t)
+
+	srv := watchmantest.NewServer()
+	defer srv.Close()
+	srv.Handle("watch-list", func(args []interface{}) (map[string]interface{}, error) {
+		return map[string]interface{}{"roots": []string{"/a", "/b"}}, nil
+	})
+	srv.Handle("watch-project", func(args []interface{}) (map[string]interface{}, error) {
+		return nil, errors.New("no watching allowed")
+	})
+	conn := connect(t, srv)
+
+	pdu, err := roundTrip(t, conn, &protocol.WatchListRequest{})
+	require.NoError(err)
+	require.Equal([]string{"/a", "/b"}, protocol.NewWatchListResponse(pdu).Roots())
+
+	_, err = roundTrip(t, conn, &protocol.WatchProjectRequest{Path: "/a"})
+	require.EqualError(err, "no watching allowed")
+}
+
+func TestJSON(t *testing.T) {
+	require := require.New(t)
+
+	srv := watchmantest.NewUnstartedServer()
+	defer srv.Close()
+	srv.Watch("/src")
+
+	socket, err := srv.Dial(context.Background())
+	require.NoError(err)
+	defer socket.Close()
+	reader := bufio.NewReader(socket)
+
+	recv := func() map[string]interface{} {
+		line, err := reader.ReadBytes('\n')
+		require.NoError(err)
+		var pdu map[string]interface{}
+		require.NoError(json.Unmarshal(line, &pdu))
+		return pdu
+	}
+
+	_, err = socket.Write([]byte(`["subscribe", "/src", "sub1", {"fields": ["name"]}]` + "\n"))
+	require.NoError(err)
+	require.Equal("sub1", recv()["subscribe"])
+	require.Equal([]interface{}{}, recv()["files"])
+
+	srv.WriteFile("/src", protocol.File{Name: "a"})
+	pdu := recv()
+	require.Equal(true, pdu["unilateral"])
+	require.Equal([]interface{}{"a"}, pdu["files"])
+
+	_, err = socket.Write([]byte(`["nope"]` + "\n"))
+	require.NoError(err)
+	require.Equal("unknown command nope", recv()["error"])
+}
+
+func TestConnectOptions(t *testing.T) {
+	require := require.New(t)
+
+	srv := watchmantest.NewUnstartedServer()
+	defer srv.Close()
+
+	conn, err := protocol.ConnectWithOptions(context.Background(), srv.ConnectOptions())
+	require.NoError(err)
+	defer conn.Close()
+	require.Equal("watchmantest", conn.SockName())
+	require.Equal(watchmantest.Version, conn.Version())
+	require.True(conn.HasCapability("cmd-watch-project"))
+
+	pdu, err := roundTrip(t, conn, &protocol.WatchProjectRequest{Path: "/src"})
+	require.NoError(err)
+	require.Equal("/src", protocol.NewWatchProjectResponse(pdu).Watch())
+}
+
+func TestReconnect(t *testing.T) {
+	require := require.New(t)
+
+	srv := watchmantest.NewServer()
+	defer srv.Close()
+	srv.Watch("/src")
+	conn := connect(t, srv)
+
+	srv.CloseConnections()
+	_, err := conn.Recv()
+	require.Error(err)
+
+	conn = connect(t, srv)
+	pdu, err := roundTrip(t, conn, &protocol.WatchListRequest{})
+	require.NoError(err)
+	require.Equal([]string{"/src"}, protocol.NewWatchListResponse(pdu).Roots())
+}
+
+func TestStates(t *testing.T) {
+	require := require.New(t)
+
+	srv := watchmantest.NewServer()
+	defer srv.Close()
+	srv.Watch("/src")
+	sub := connect(t, srv)
+	owner := connect(t, srv)
+
+	_, err := roundTrip(t, sub, &protocol.SubscribeRequest{
+		Root:  "/src",
+		Name:  "sub1",
+		Defer: []string{"hg.update"},
+	})
+	require.NoError(err)
+	_, err = sub.Recv()
+	require.NoError(err)
+
+	pdu, err := roundTrip(t, owner, &protocol.StateEnterRequest{Root: "/src", Name: "hg.update"})
+	require.NoError(err)
+	require.Equal("hg.update", protocol.NewStateEnterResponse(pdu).State())
+
+	_, err = roundTrip(t, owner, &protocol.StateEnterRequest{Root: "/src", Name: "hg.update"})
+	require.Error(err)
+
+	pdu, err = sub.Recv()
+	require.NoError(err)
+	require.Equal("hg.update", protocol.NewSubscription(pdu).StateEnter())
+
+	// only the owner can leave the state
+	_, err = roundTrip(t, sub, &protocol.StateLeaveRequest{Root: "/src", Name: "hg.update"})
+	require.Error(err)
+
+	srv.WriteFile("/src", protocol.File{Name: "a"})
+	owner.Close()
+
+	pdu, err = sub.Recv()
+	require.NoError(err)
+	n := protocol.NewSubscription(pdu)
+	require.Equal("hg.update", n.StateLeave())
+	require.True(n.Abandoned())
+
+	pdu, err = sub.Recv()
+	require.NoError(err)
+	require.Equal("a", protocol.NewSubscription(pdu).Files()[0].Name)
+}